@@ -4,15 +4,19 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 
 	"example.com/haproxy-k8s-sync/internal/config"
 	"example.com/haproxy-k8s-sync/internal/controller"
 	"example.com/haproxy-k8s-sync/internal/k8s"
+	"example.com/haproxy-k8s-sync/internal/metrics"
 	"example.com/haproxy-k8s-sync/pkg/haproxy"
 )
 
@@ -20,7 +24,16 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	go startHealthServer(ctx)
+	if len(os.Args) > 1 && os.Args[1] == "rollback" {
+		if err := runRollback(ctx, os.Args[2:]); err != nil {
+			log.Fatalf("rollback failed: %v", err)
+		}
+		return
+	}
+
+	registry := metrics.NewRegistry()
+	ready := newReadiness()
+	go startHealthServer(ctx, registry, ready)
 
 	cfg, err := config.Load()
 	if err != nil {
@@ -37,12 +50,74 @@ func main() {
 		log.Fatalf("failed to create kubernetes client: %v", err)
 	}
 
-	informers := k8s.NewInformers(clientset, cfg.IngressNamespace, cfg.IngressServiceName, cfg.ResyncPeriod)
-	haproxyClient := haproxy.NewDataPlaneClient(cfg.HAProxyBaseURL, cfg.HAProxyUsername, cfg.HAProxyPassword, cfg.HAProxyToken, cfg.HAProxyBackendName)
-	syncer := haproxy.NewSyncerWithPortAndProxy(haproxyClient, cfg.HAProxyBackendPort, cfg.SendProxyV2)
-	ctrl := controller.NewController(informers, syncer, cfg.WorkerCount)
+	var informers *k8s.Informers
+	if cfg.IngressMode == config.IngressModeHostPort {
+		checkIngressPods(ctx, clientset, cfg)
+		informers = k8s.NewHostPortInformers(clientset, cfg.IngressNamespace, cfg.IngressPodSelector, cfg.ResyncPeriod)
+	} else {
+		informers = k8s.NewInformers(clientset, cfg.IngressNamespace, cfg.IngressServiceName, cfg.ResyncPeriod)
+	}
+	var agent *haproxy.AgentServer
+	if cfg.AgentCheck {
+		agent = haproxy.NewAgentServer(cfg.Agent)
+		go func() {
+			if err := agent.ListenAndServe(ctx, cfg.AgentListenAddr); err != nil {
+				log.Fatalf("agent-check server error: %v", err)
+			}
+		}()
+	}
+
+	haproxyClient, stats := newHAProxyClient(cfg, registry)
+	var adaptive *haproxy.AdaptiveWeights
+	if cfg.AdaptiveWeights {
+		adaptive = haproxy.NewAdaptiveWeights(stats, cfg.Adaptive)
+	}
+
+	var dampener *haproxy.Dampener
+	if cfg.Dampening.Enabled() {
+		dampener = haproxy.NewDampener(cfg.Dampening)
+		registerDampeningMetrics(registry, dampener)
+	}
+
+	syncer := haproxy.NewSyncerWithOptions(haproxyClient, haproxy.SyncerOptions{
+		Port:            cfg.HAProxyBackendPort,
+		PodPort:         cfg.IngressPodPort,
+		ProxyProtocol:   cfg.ProxyProtocol,
+		Weights:         cfg.Weights,
+		DNS:             cfg.DNS,
+		HealthCheck:     cfg.HealthCheck,
+		Balance:         cfg.Balance,
+		Persistence:     cfg.Persistence,
+		BackendTemplate: cfg.BackendTemplate,
+		ServerTemplate:  cfg.ServerTemplate,
+		Adaptive:        adaptive,
+		Dampener:        dampener,
+		Agent:           agent,
+		Recorder:        k8s.NewEventRecorder(clientset, "haproxy-k8s-sync"),
+		Verify:          cfg.Verify,
+		Stats:           stats,
+		EventObject:     eventObject(cfg),
+		History:         newHistory(cfg, clientset),
+	})
+	if cfg.Verify.Enabled() {
+		registry.Counter("haproxy_sync_rollbacks_total", "Committed changes restored after failing post-commit verification.", func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(syncer.Rollbacks())}}
+		})
+	}
+	ctrl := controller.NewController(informers, readySyncer{BackendSyncer: syncer, client: haproxyClient, cfg: cfg, ready: ready}, cfg.WorkerCount)
+	if adaptive != nil {
+		go adaptive.Run(ctx, ctrl.Resync)
+	}
 
-	log.Printf("starting controller for %s/%s", cfg.IngressNamespace, cfg.IngressServiceName)
+	if cfg.IngressMode == config.IngressModeHostPort {
+		log.Printf("starting controller for pods %q in %s (host-port mode)", cfg.IngressPodSelector, cfg.IngressNamespace)
+	} else {
+		log.Printf("starting controller for %s/%s", cfg.IngressNamespace, cfg.IngressServiceName)
+	}
+	if err := waitForHAProxy(ctx, haproxyClient, cfg, ready); err != nil {
+		log.Printf("controller stopped before the Data Plane API became usable: %v", err)
+		return
+	}
 	if err := ctrl.Run(ctx); err != nil {
 		log.Fatalf("controller stopped with error: %v", err)
 	}
@@ -50,14 +125,102 @@ func main() {
 	log.Printf("controller exited gracefully at %s", time.Now().Format(time.RFC3339))
 }
 
-func startHealthServer(ctx context.Context) {
+// newHAProxyClient creates the configured client; both implementations also serve statistics.
+func newHAProxyClient(cfg config.Config, registry *metrics.Registry) (haproxy.Client, haproxy.StatsClient) {
+	switch cfg.HAProxyClient {
+	case config.ClientRuntime:
+		client, err := haproxy.NewRuntimeClient(cfg.RuntimeSocket, cfg.HAProxyBackendName, haproxy.RuntimeOptions{
+			SlotPrefix: cfg.RuntimeSlotPrefix,
+		})
+		if err != nil {
+			log.Fatalf("failed to create Runtime API client: %v", err)
+		}
+		return client, client
+	case config.ClientFile:
+		client, err := haproxy.NewFileClient(cfg.HAProxyBackendName, cfg.File)
+		if err != nil {
+			log.Fatalf("failed to create file client: %v", err)
+		}
+		return client, client
+	}
+
+	client, err := haproxy.NewDataPlaneClientWithOptions(cfg.HAProxyBaseURL, cfg.HAProxyBackendName, haproxy.DataPlaneOptions{
+		Username:      cfg.HAProxyUsername,
+		Password:      cfg.HAProxyPassword,
+		Token:         cfg.HAProxyToken,
+		UsernameFile:  cfg.HAProxyUserFile,
+		PasswordFile:  cfg.HAProxyPassFile,
+		TokenFile:     cfg.HAProxyTokenFile,
+		TLS:           cfg.HAProxyTLS,
+		APIVersion:    cfg.HAProxyAPIVersion,
+		ReloadTimeout: cfg.ReloadTimeout,
+	})
+	if err != nil {
+		log.Fatalf("failed to create Data Plane client: %v", err)
+	}
+	registry.Counter("haproxy_sync_reload_failures_total", "HAProxy reloads that failed after a committed transaction.", func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(client.ReloadFailures())}}
+	})
+	return client, client
+}
+
+// newHistory returns the configured history store, or nil when history is disabled.
+func newHistory(cfg config.Config, clientset kubernetes.Interface) *haproxy.History {
+	switch {
+	case cfg.HistoryFile != "":
+		return haproxy.NewHistory(haproxy.FileHistoryStore{Path: cfg.HistoryFile}, cfg.HistoryLimit)
+	case cfg.HistoryConfigMap != "":
+		store := k8s.ConfigMapHistoryStore{Client: clientset, Namespace: cfg.HistoryNamespace, Name: cfg.HistoryConfigMap}
+		return haproxy.NewHistory(store, cfg.HistoryLimit)
+	}
+	return nil
+}
+
+// checkIngressPods stops the controller when the ingress Pods serve several ports on their
+// node and none is selected, since the servers would mix the ingress, metrics and webhook ports.
+func checkIngressPods(ctx context.Context, clientset kubernetes.Interface, cfg config.Config) {
+	list, err := clientset.CoreV1().Pods(cfg.IngressNamespace).List(ctx, metav1.ListOptions{LabelSelector: cfg.IngressPodSelector})
+	if err != nil {
+		log.Fatalf("failed to list ingress pods: %v", err)
+	}
+	pods := make([]*corev1.Pod, 0, len(list.Items))
+	for i := range list.Items {
+		pods = append(pods, &list.Items[i])
+	}
+	if err := haproxy.CheckPodPorts(pods, cfg.IngressPodPort, cfg.HAProxyBackendPort); err != nil {
+		log.Fatalf("invalid host-port configuration: %v", err)
+	}
+}
+
+// eventObject is what rollback Events are attached to: the ingress Service, or its
+// namespace in host-port mode where there is no Service.
+func eventObject(cfg config.Config) *corev1.ObjectReference {
+	if cfg.IngressMode == config.IngressModeHostPort {
+		return &corev1.ObjectReference{APIVersion: "v1", Kind: "Namespace", Name: cfg.IngressNamespace}
+	}
+	return &corev1.ObjectReference{APIVersion: "v1", Kind: "Service", Namespace: cfg.IngressNamespace, Name: cfg.IngressServiceName}
+}
+
+func registerDampeningMetrics(registry *metrics.Registry, dampener *haproxy.Dampener) {
+	registry.Gauge("haproxy_sync_servers_dampened", "Servers kept in maint by flap dampening.", func() []metrics.Sample {
+		state := dampener.State()
+		return []metrics.Sample{
+			{Labels: map[string]string{"reason": "hold_down"}, Value: float64(state.HoldingDown)},
+			{Labels: map[string]string{"reason": "flapping"}, Value: float64(state.Flapping)},
+		}
+	})
+	registry.Counter("haproxy_sync_server_flaps_total", "Times a server was detected as flapping.", func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(dampener.State().FlapsTotal)}}
+	})
+}
+
+func startHealthServer(ctx context.Context, registry *metrics.Registry, ready *readiness) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
-	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
+	mux.Handle("/readyz", ready)
+	mux.Handle("/metrics", registry)
 
 	server := &http.Server{
 		Addr:    ":8080",
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"example.com/haproxy-k8s-sync/internal/config"
+	"example.com/haproxy-k8s-sync/internal/controller"
+	"example.com/haproxy-k8s-sync/pkg/haproxy"
+)
+
+// probeRetryInterval is how often an incompatible or unreachable Data Plane API is probed again.
+const probeRetryInterval = 10 * time.Second
+
+// readiness is what /readyz reports: the reason the controller cannot sync yet, or nil.
+type readiness struct {
+	mu  sync.Mutex
+	err error
+}
+
+func newReadiness() *readiness {
+	return &readiness{err: errors.New("starting")}
+}
+
+func (r *readiness) set(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+}
+
+func (r *readiness) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	r.mu.Lock()
+	err := r.err
+	r.mu.Unlock()
+	if err != nil {
+		http.Error(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// capabilityProber is implemented by clients that can check their target before syncing.
+type capabilityProber interface {
+	Probe(ctx context.Context) (haproxy.Capabilities, error)
+}
+
+// waitForHAProxy probes the Data Plane API until it is usable with cfg, reporting failures
+// on /readyz. Other clients are ready immediately.
+func waitForHAProxy(ctx context.Context, client haproxy.Client, cfg config.Config, ready *readiness) error {
+	prober, ok := client.(capabilityProber)
+	if !ok {
+		ready.set(nil)
+		return nil
+	}
+	for {
+		caps, err := probe(ctx, prober, cfg)
+		if err == nil {
+			log.Printf("detected %s", caps)
+			if !caps.RuntimeAddServer {
+				log.Printf("HAProxy cannot add servers at runtime, every added server reloads it")
+			}
+			ready.set(nil)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("Data Plane API is not usable, retrying in %s: %v", probeRetryInterval, err)
+		ready.set(err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(probeRetryInterval):
+		}
+	}
+}
+
+func probe(ctx context.Context, prober capabilityProber, cfg config.Config) (haproxy.Capabilities, error) {
+	caps, err := prober.Probe(ctx)
+	if err != nil {
+		return caps, err
+	}
+	return caps, caps.Require(cfg.ProxyProtocol)
+}
+
+// readySyncer keeps /readyz current after startup: a sync the Data Plane API rejects with
+// 401 or 403, e.g. after a credential rotation, probes again and fails readiness with the
+// probe result, or with the sync error when only a write was denied, until a sync succeeds.
+type readySyncer struct {
+	controller.BackendSyncer
+	client haproxy.Client
+	cfg    config.Config
+	ready  *readiness
+}
+
+// NextSync forwards the wrapped syncer's delayed sync, see controller.DelayedSyncer.
+func (s readySyncer) NextSync() (time.Duration, bool) {
+	if delayed, ok := s.BackendSyncer.(controller.DelayedSyncer); ok {
+		return delayed.NextSync()
+	}
+	return 0, false
+}
+
+func (s readySyncer) Sync(ctx context.Context, state haproxy.ClusterState) error {
+	err := s.BackendSyncer.Sync(ctx, state)
+	if err == nil {
+		s.ready.set(nil)
+		return nil
+	}
+	prober, ok := s.client.(capabilityProber)
+	if !ok || !haproxy.IsUnauthorized(err) {
+		return err
+	}
+	if _, probeErr := probe(ctx, prober, s.cfg); probeErr != nil {
+		log.Printf("Data Plane API is not usable: %v", probeErr)
+		s.ready.set(probeErr)
+	} else {
+		s.ready.set(err)
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+
+	"example.com/haproxy-k8s-sync/internal/config"
+	"example.com/haproxy-k8s-sync/internal/k8s"
+	"example.com/haproxy-k8s-sync/internal/metrics"
+	"example.com/haproxy-k8s-sync/pkg/haproxy"
+)
+
+// runRollback implements "rollback --to <id>", which pauses the syncs of the running
+// controllers and writes the backend saved in a history entry back through a transaction,
+// "rollback --list", which prints the stored entries, and "rollback --pause" and
+// "rollback --resume". It reads the same environment variables as the controller.
+func runRollback(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	to := flags.Int64("to", 0, "history entry to restore")
+	list := flags.Bool("list", false, "print the stored history entries")
+	pause := flags.Bool("pause", false, "pause the syncs of the running controllers")
+	resume := flags.Bool("resume", false, "resume the syncs of the running controllers")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !*list && !*pause && !*resume && *to <= 0 {
+		return errors.New("--to <id>, --list, --pause or --resume is required")
+	}
+	if *resume && (*pause || *to > 0) {
+		return errors.New("--resume cannot be combined with --pause or --to")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load configuration: %w", err)
+	}
+
+	var clientset kubernetes.Interface
+	if cfg.HistoryConfigMap != "" {
+		restCfg, err := k8s.BuildConfig(ctx, cfg.KubeconfigPath)
+		if err != nil {
+			return fmt.Errorf("build kube config: %w", err)
+		}
+		if clientset, err = kubernetes.NewForConfig(restCfg); err != nil {
+			return fmt.Errorf("create kubernetes client: %w", err)
+		}
+	}
+	history := newHistory(cfg, clientset)
+	if history == nil {
+		return errors.New("no history configured: set HAPROXY_HISTORY_FILE or HAPROXY_HISTORY_CONFIGMAP")
+	}
+
+	if *list {
+		entries, err := history.Entries(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tVERSION\tTIME\tSERVERS\tCHANGE")
+		for _, e := range entries {
+			version := "-"
+			if e.Version > 0 {
+				version = strconv.FormatInt(e.Version, 10)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\n", e.ID, version, e.Time.Format(time.RFC3339), len(e.Servers), describeDiff(e.Diff))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if *resume {
+		if err := history.SetPaused(ctx, false); err != nil {
+			return err
+		}
+		log.Printf("resumed syncs")
+		return nil
+	}
+	if *pause || *to > 0 {
+		// The running controllers check the switch before each sync, so none overwrites the
+		// restore with the cluster state that took traffic down.
+		if err := history.SetPaused(ctx, true); err != nil {
+			return err
+		}
+		log.Printf("paused syncs, resume them with rollback --resume")
+	}
+	if *to <= 0 {
+		return nil
+	}
+
+	entry, err := history.Find(ctx, *to)
+	if err != nil {
+		return err
+	}
+	client, _ := newHAProxyClient(cfg, metrics.NewRegistry())
+	syncer := haproxy.NewSyncerWithOptions(client, haproxy.SyncerOptions{History: history})
+	if err := syncer.Restore(ctx, entry); err != nil {
+		return err
+	}
+	log.Printf("restored the backend saved in entry %d at %s with %d servers", entry.ID, entry.Time.Format(time.RFC3339), len(entry.Servers))
+	return nil
+}
+
+func describeDiff(d haproxy.HistoryDiff) string {
+	parts := []string{d.String()}
+	for _, change := range []struct {
+		sign  string
+		names []string
+	}{{"+", d.Added}, {"-", d.Removed}, {"~", d.Changed}} {
+		for _, name := range change.names {
+			parts = append(parts, change.sign+name)
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -1,24 +1,81 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"example.com/haproxy-k8s-sync/pkg/haproxy"
+)
+
+// Ingress discovery modes.
+const (
+	// IngressModeService discovers backends from the ingress Service Endpoints/EndpointSlices.
+	IngressModeService = "service"
+	// IngressModeHostPort discovers backends from ingress Pods running with hostPort or hostNetwork.
+	IngressModeHostPort = "hostport"
+)
+
+// HAProxy client implementations.
+const (
+	// ClientDataPlane manages the backend through the Data Plane API.
+	ClientDataPlane = "dataplane"
+	// ClientRuntime manages the backend's servers through the Runtime API socket.
+	ClientRuntime = "runtime"
+	// ClientFile renders the backend into a configuration file and reloads HAProxy.
+	ClientFile = "file"
 )
 
 // Config holds controller runtime configuration sourced from environment variables.
 type Config struct {
+	HAProxyClient      string
 	HAProxyBaseURL     string
 	HAProxyUsername    string
 	HAProxyPassword    string
 	HAProxyToken       string
+	HAProxyUserFile    string
+	HAProxyPassFile    string
+	HAProxyTokenFile   string
+	HAProxyTLS         haproxy.TLSConfig
+	HAProxyAPIVersion  haproxy.APIVersion
+	ReloadTimeout      time.Duration
 	HAProxyBackendName string
 	HAProxyBackendPort int32
-	SendProxyV2        bool
+	RuntimeSocket      string
+	RuntimeSlotPrefix  string
+	File               haproxy.FileOptions
+	ProxyProtocol      haproxy.ProxyProtocolConfig
+	AdaptiveWeights    bool
+	Adaptive           haproxy.AdaptiveConfig
+	Dampening          haproxy.DampeningConfig
+	Verify             haproxy.VerifyConfig
+	// History is kept in HistoryFile or in the ConfigMap HistoryNamespace/HistoryConfigMap.
+	HistoryFile        string
+	HistoryNamespace   string
+	HistoryConfigMap   string
+	HistoryLimit       int
+	AgentCheck         bool
+	AgentListenAddr    string
+	Agent              haproxy.AgentConfig
+	Weights            haproxy.WeightConfig
+	HealthCheck        haproxy.HealthCheckConfig
+	Balance            haproxy.BalanceConfig
+	Persistence        haproxy.PersistenceConfig
+	BackendTemplate    map[string]any
+	ServerTemplate     map[string]any
+	DNS                haproxy.DNSConfig
 	IngressNamespace   string
 	IngressServiceName string
+	IngressMode        string
+	IngressPodSelector string
+	IngressPodPort     string
 	WorkerCount        int
 	ResyncPeriod       time.Duration
 	KubeconfigPath     string
@@ -29,15 +86,41 @@ func Load() (Config, error) {
 	cfg := Config{
 		IngressNamespace:   getEnv("INGRESS_NAMESPACE", "ingress-nginx"),
 		IngressServiceName: getEnv("INGRESS_SERVICE_NAME", "ingress-nginx"),
+		IngressMode:        getEnv("INGRESS_MODE", IngressModeService),
+		IngressPodSelector: os.Getenv("INGRESS_POD_SELECTOR"),
+		IngressPodPort:     os.Getenv("INGRESS_POD_PORT"),
+		HAProxyClient:      getEnv("HAPROXY_CLIENT", ClientDataPlane),
 		HAProxyBaseURL:     getEnv("HAPROXY_DATAPLANE_URL", "http://haproxy:5555"),
+		RuntimeSocket:      os.Getenv("HAPROXY_RUNTIME_SOCKET"),
+		RuntimeSlotPrefix:  os.Getenv("HAPROXY_RUNTIME_SLOT_PREFIX"),
+		File: haproxy.FileOptions{
+			Path:         os.Getenv("HAPROXY_FILE_PATH"),
+			HAProxyBin:   os.Getenv("HAPROXY_BIN"),
+			ValidateWith: splitList(os.Getenv("HAPROXY_FILE_VALIDATE_WITH")),
+			MasterSocket: os.Getenv("HAPROXY_MASTER_SOCKET"),
+		},
 		HAProxyBackendName: getEnv("HAPROXY_BACKEND_NAME", ""),
-		SendProxyV2:        os.Getenv("HAPROXY_SEND_PROXY_V2") == "true",
 		WorkerCount:        runtime.NumCPU(),
 		ResyncPeriod:       30 * time.Second,
 		KubeconfigPath:     os.Getenv("KUBECONFIG"),
 		HAProxyUsername:    os.Getenv("HAPROXY_DATAPLANE_USERNAME"),
 		HAProxyPassword:    os.Getenv("HAPROXY_DATAPLANE_PASSWORD"),
 		HAProxyToken:       os.Getenv("HAPROXY_DATAPLANE_TOKEN"),
+		Weights: haproxy.WeightConfig{
+			Key: os.Getenv("HAPROXY_WEIGHT_KEY"),
+			Min: haproxy.DefaultMinWeight,
+			Max: haproxy.DefaultMaxWeight,
+		},
+		DNS: haproxy.DNSConfig{
+			Template: os.Getenv("HAPROXY_SERVER_FQDN_TEMPLATE"),
+			Resolvers: haproxy.ResolversConfig{
+				Name:             getEnv("HAPROXY_RESOLVERS_NAME", haproxy.DefaultResolversName),
+				Nameservers:      splitList(os.Getenv("HAPROXY_RESOLVERS_NAMESERVERS")),
+				HoldValidSeconds: haproxy.DefaultHoldValidSeconds,
+			},
+			ResolvePrefer: getEnv("HAPROXY_RESOLVE_PREFER", haproxy.DefaultResolvePrefer),
+			InitAddr:      getEnv("HAPROXY_INIT_ADDR", haproxy.DefaultInitAddr),
+		},
 	}
 
 	if v := os.Getenv("HAPROXY_BACKEND_PORT"); v != "" {
@@ -48,6 +131,22 @@ func Load() (Config, error) {
 		cfg.HAProxyBackendPort = int32(p)
 	}
 
+	if err := intEnv("HAPROXY_WEIGHT_MIN", &cfg.Weights.Min); err != nil {
+		return Config{}, err
+	}
+	if err := intEnv("HAPROXY_WEIGHT_MAX", &cfg.Weights.Max); err != nil {
+		return Config{}, err
+	}
+
+	strategy, err := haproxy.ParseWeightStrategy(os.Getenv("HAPROXY_WEIGHT_STRATEGY"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid HAPROXY_WEIGHT_STRATEGY: %w", err)
+	}
+	cfg.Weights.Strategy = strategy
+	if err := cfg.Weights.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid weight configuration: %w", err)
+	}
+
 	if v := os.Getenv("RESYNC_PERIOD"); v != "" {
 		dur, err := time.ParseDuration(v)
 		if err != nil {
@@ -56,6 +155,142 @@ func Load() (Config, error) {
 		cfg.ResyncPeriod = dur
 	}
 
+	switch cfg.IngressMode {
+	case IngressModeService:
+	case IngressModeHostPort:
+		if cfg.IngressPodSelector == "" {
+			return Config{}, fmt.Errorf("INGRESS_POD_SELECTOR is required when INGRESS_MODE=%s", IngressModeHostPort)
+		}
+		if _, err := labels.Parse(cfg.IngressPodSelector); err != nil {
+			return Config{}, fmt.Errorf("invalid INGRESS_POD_SELECTOR value %q: %w", cfg.IngressPodSelector, err)
+		}
+	default:
+		return Config{}, fmt.Errorf("invalid INGRESS_MODE value %q: expected %s or %s", cfg.IngressMode, IngressModeService, IngressModeHostPort)
+	}
+
+	switch mode := getEnv("HAPROXY_ADDRESS_MODE", "ip"); mode {
+	case "ip":
+	case "dns":
+		cfg.DNS.Enabled = true
+	default:
+		return Config{}, fmt.Errorf("invalid HAPROXY_ADDRESS_MODE value %q: expected ip or dns", mode)
+	}
+
+	if v := os.Getenv("HAPROXY_RESOLVERS_HOLD_VALID"); v != "" {
+		dur, err := time.ParseDuration(v)
+		if err != nil || dur < time.Second {
+			return Config{}, fmt.Errorf("invalid HAPROXY_RESOLVERS_HOLD_VALID value %q: must be a duration of at least 1s", v)
+		}
+		cfg.DNS.Resolvers.HoldValidSeconds = int(dur / time.Second)
+	}
+
+	if err := cfg.DNS.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid DNS configuration: %w", err)
+	}
+
+	if err := loadHealthCheck(&cfg.HealthCheck); err != nil {
+		return Config{}, err
+	}
+
+	if err := loadBalance(&cfg.Balance, &cfg.Persistence); err != nil {
+		return Config{}, err
+	}
+
+	backendTemplate, err := readTemplate("HAPROXY_BACKEND_TEMPLATE")
+	if err != nil {
+		return Config{}, err
+	}
+	if cfg.BackendTemplate, err = haproxy.ParseBackendTemplate(backendTemplate); err != nil {
+		return Config{}, fmt.Errorf("invalid HAPROXY_BACKEND_TEMPLATE: %w", err)
+	}
+
+	if err := loadProxyProtocol(&cfg.ProxyProtocol); err != nil {
+		return Config{}, err
+	}
+
+	if err := loadAdaptive(&cfg); err != nil {
+		return Config{}, err
+	}
+
+	for _, c := range []struct {
+		key  string
+		file *string
+	}{
+		{"HAPROXY_DATAPLANE_USERNAME", &cfg.HAProxyUserFile},
+		{"HAPROXY_DATAPLANE_PASSWORD", &cfg.HAProxyPassFile},
+		{"HAPROXY_DATAPLANE_TOKEN", &cfg.HAProxyTokenFile},
+	} {
+		*c.file = os.Getenv(c.key + "_FILE")
+		if *c.file != "" && os.Getenv(c.key) != "" {
+			return Config{}, fmt.Errorf("only one of %s and %s_FILE may be set", c.key, c.key)
+		}
+	}
+
+	if err := loadDataPlaneTLS(&cfg.HAProxyTLS, cfg.HAProxyBaseURL); err != nil {
+		return Config{}, err
+	}
+
+	apiVersion, err := haproxy.ParseAPIVersion(os.Getenv("HAPROXY_DATAPLANE_API_VERSION"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid HAPROXY_DATAPLANE_API_VERSION: %w", err)
+	}
+	cfg.HAProxyAPIVersion = apiVersion
+
+	cfg.ReloadTimeout = haproxy.DefaultReloadTimeout
+	if err := durationEnv("HAPROXY_DATAPLANE_RELOAD_TIMEOUT", &cfg.ReloadTimeout); err != nil {
+		return Config{}, err
+	}
+	if cfg.ReloadTimeout <= 0 {
+		return Config{}, fmt.Errorf("HAPROXY_DATAPLANE_RELOAD_TIMEOUT must be positive")
+	}
+
+	if err := loadDampening(&cfg.Dampening); err != nil {
+		return Config{}, err
+	}
+
+	if err := loadAgent(&cfg); err != nil {
+		return Config{}, err
+	}
+
+	if err := loadVerify(&cfg.Verify); err != nil {
+		return Config{}, err
+	}
+	if cfg.Verify.Enabled() && cfg.HAProxyClient != ClientDataPlane {
+		return Config{}, fmt.Errorf("HAPROXY_VERIFY_SETTLE needs HAPROXY_CLIENT=%s to restore the previous backend", ClientDataPlane)
+	}
+
+	if err := loadHistory(&cfg); err != nil {
+		return Config{}, err
+	}
+
+	switch cfg.HAProxyClient {
+	case ClientDataPlane:
+	case ClientRuntime:
+		if cfg.RuntimeSocket == "" {
+			return Config{}, fmt.Errorf("HAPROXY_RUNTIME_SOCKET is required when HAPROXY_CLIENT=%s", ClientRuntime)
+		}
+		if cfg.AgentCheck {
+			return Config{}, fmt.Errorf("HAPROXY_AGENT_CHECK is not supported with HAPROXY_CLIENT=%s", ClientRuntime)
+		}
+	case ClientFile:
+		if cfg.File.Path == "" {
+			return Config{}, fmt.Errorf("HAPROXY_FILE_PATH is required when HAPROXY_CLIENT=%s", ClientFile)
+		}
+		if cfg.AdaptiveWeights && cfg.File.MasterSocket == "" {
+			return Config{}, fmt.Errorf("HAPROXY_ADAPTIVE_WEIGHTS needs HAPROXY_MASTER_SOCKET when HAPROXY_CLIENT=%s", ClientFile)
+		}
+	default:
+		return Config{}, fmt.Errorf("invalid HAPROXY_CLIENT value %q: expected %s, %s or %s", cfg.HAProxyClient, ClientDataPlane, ClientRuntime, ClientFile)
+	}
+
+	serverTemplate, err := readTemplate("HAPROXY_SERVER_TEMPLATE")
+	if err != nil {
+		return Config{}, err
+	}
+	if cfg.ServerTemplate, err = haproxy.ParseServerTemplate(serverTemplate); err != nil {
+		return Config{}, fmt.Errorf("invalid HAPROXY_SERVER_TEMPLATE: %w", err)
+	}
+
 	if cfg.HAProxyBackendName == "" {
 		cfg.HAProxyBackendName = cfg.IngressServiceName
 	}
@@ -63,6 +298,325 @@ func Load() (Config, error) {
 	return cfg, nil
 }
 
+// loadHealthCheck reads the HAPROXY_CHECK_* variables on top of the default tcp-check.
+func loadHealthCheck(hc *haproxy.HealthCheckConfig) error {
+	*hc = haproxy.DefaultHealthCheckConfig()
+	hc.Type = haproxy.CheckType(getEnv("HAPROXY_CHECK_TYPE", string(hc.Type)))
+	hc.HTTPMethod = getEnv("HAPROXY_CHECK_HTTP_METHOD", hc.HTTPMethod)
+	hc.HTTPPath = getEnv("HAPROXY_CHECK_HTTP_PATH", hc.HTTPPath)
+	hc.HTTPHost = os.Getenv("HAPROXY_CHECK_HTTP_HOST")
+	hc.HTTPExpectStatus = getEnv("HAPROXY_CHECK_HTTP_EXPECT_STATUS", hc.HTTPExpectStatus)
+
+	for key, dst := range map[string]*time.Duration{
+		"HAPROXY_CHECK_INTER":     &hc.Interval,
+		"HAPROXY_CHECK_FASTINTER": &hc.FastInterval,
+		"HAPROXY_CHECK_DOWNINTER": &hc.DownInterval,
+	} {
+		if err := durationEnv(key, dst); err != nil {
+			return err
+		}
+	}
+	if err := intEnv("HAPROXY_CHECK_RISE", &hc.RiseCount); err != nil {
+		return err
+	}
+	if err := intEnv("HAPROXY_CHECK_FALL", &hc.FallCount); err != nil {
+		return err
+	}
+
+	var port int
+	if err := intEnv("HAPROXY_CHECK_PORT", &port); err != nil {
+		return err
+	}
+	hc.Port = int32(port)
+	if err := boolEnv("HAPROXY_CHECK_SSL", &hc.SSL); err != nil {
+		return err
+	}
+
+	if err := hc.Validate(); err != nil {
+		return fmt.Errorf("invalid health check configuration: %w", err)
+	}
+	return nil
+}
+
+// loadProxyProtocol reads HAPROXY_PROXY_PROTOCOL and its options. The older
+// HAPROXY_SEND_PROXY_V2=true is still honoured when no version is set.
+func loadProxyProtocol(p *haproxy.ProxyProtocolConfig) error {
+	version := os.Getenv("HAPROXY_PROXY_PROTOCOL")
+	if version == "" && os.Getenv("HAPROXY_SEND_PROXY_V2") == "true" {
+		version = string(haproxy.ProxyV2)
+	}
+	v, err := haproxy.ParseProxyProtocolVersion(version)
+	if err != nil {
+		return fmt.Errorf("invalid HAPROXY_PROXY_PROTOCOL: %w", err)
+	}
+	p.Version = v
+	p.V2Options = splitList(os.Getenv("HAPROXY_PROXY_V2_OPTIONS"))
+	if err := boolEnv("HAPROXY_CHECK_SEND_PROXY", &p.CheckSendProxy); err != nil {
+		return err
+	}
+	if err := p.Validate(); err != nil {
+		return fmt.Errorf("invalid PROXY protocol configuration: %w", err)
+	}
+	return nil
+}
+
+// loadAdaptive reads the HAPROXY_ADAPTIVE_* variables.
+func loadAdaptive(cfg *Config) error {
+	if err := boolEnv("HAPROXY_ADAPTIVE_WEIGHTS", &cfg.AdaptiveWeights); err != nil {
+		return err
+	}
+	cfg.Adaptive = haproxy.DefaultAdaptiveConfig()
+	if !cfg.AdaptiveWeights {
+		return nil
+	}
+
+	a := &cfg.Adaptive
+	if err := durationEnv("HAPROXY_ADAPTIVE_INTERVAL", &a.Interval); err != nil {
+		return err
+	}
+	if err := durationEnv("HAPROXY_ADAPTIVE_MAX_RTIME", &a.MaxResponseTime); err != nil {
+		return err
+	}
+	if err := floatEnv("HAPROXY_ADAPTIVE_MAX_ERROR_RATE", &a.MaxErrorRate); err != nil {
+		return err
+	}
+	for key, dst := range map[string]*int{
+		"HAPROXY_ADAPTIVE_MIN_PERCENT":     &a.MinPercent,
+		"HAPROXY_ADAPTIVE_STEP":            &a.Step,
+		"HAPROXY_ADAPTIVE_RECOVER_SAMPLES": &a.RecoverSamples,
+	} {
+		if err := intEnv(key, dst); err != nil {
+			return err
+		}
+	}
+	if err := a.Validate(); err != nil {
+		return fmt.Errorf("invalid adaptive weight configuration: %w", err)
+	}
+	return nil
+}
+
+// loadDataPlaneTLS reads the TLS settings for the Data Plane API connection, which only an
+// https:// HAPROXY_DATAPLANE_URL uses.
+func loadDataPlaneTLS(t *haproxy.TLSConfig, baseURL string) error {
+	t.CAFile = os.Getenv("HAPROXY_DATAPLANE_CA_FILE")
+	t.CertFile = os.Getenv("HAPROXY_DATAPLANE_CERT_FILE")
+	t.KeyFile = os.Getenv("HAPROXY_DATAPLANE_KEY_FILE")
+	t.ServerName = os.Getenv("HAPROXY_DATAPLANE_SERVER_NAME")
+	if v := os.Getenv("HAPROXY_DATAPLANE_TLS_MIN_VERSION"); v != "" {
+		version, err := haproxy.ParseTLSVersion(v)
+		if err != nil {
+			return fmt.Errorf("invalid HAPROXY_DATAPLANE_TLS_MIN_VERSION: %w", err)
+		}
+		t.MinVersion = version
+	}
+	if err := t.Validate(); err != nil {
+		return fmt.Errorf("invalid Data Plane TLS configuration: %w", err)
+	}
+	if t.Enabled() && !strings.HasPrefix(strings.ToLower(baseURL), "https://") {
+		return errors.New("HAPROXY_DATAPLANE_CA_FILE, _CERT_FILE, _KEY_FILE, _SERVER_NAME and _TLS_MIN_VERSION need an https:// HAPROXY_DATAPLANE_URL")
+	}
+	return nil
+}
+
+// loadDampening reads the server hold-down and flap detection variables.
+func loadDampening(d *haproxy.DampeningConfig) error {
+	d.FlapWindow = haproxy.DefaultFlapWindow
+	d.StableFor = haproxy.DefaultFlapStable
+	if err := durationEnv("HAPROXY_REMOVAL_HOLD_DOWN", &d.HoldDown); err != nil {
+		return err
+	}
+	if err := intEnv("HAPROXY_FLAP_THRESHOLD", &d.FlapThreshold); err != nil {
+		return err
+	}
+	if err := durationEnv("HAPROXY_FLAP_WINDOW", &d.FlapWindow); err != nil {
+		return err
+	}
+	if err := durationEnv("HAPROXY_FLAP_STABLE", &d.StableFor); err != nil {
+		return err
+	}
+	if err := d.Validate(); err != nil {
+		return fmt.Errorf("invalid flap dampening configuration: %w", err)
+	}
+	return nil
+}
+
+// loadVerify reads the post-commit verification variables; HAPROXY_VERIFY_SETTLE enables it.
+func loadVerify(v *haproxy.VerifyConfig) error {
+	v.MinUpFraction = haproxy.DefaultVerifyMinUp
+	if err := durationEnv("HAPROXY_VERIFY_SETTLE", &v.Settle); err != nil {
+		return err
+	}
+	if err := floatEnv("HAPROXY_VERIFY_MIN_UP", &v.MinUpFraction); err != nil {
+		return err
+	}
+	if err := v.Validate(); err != nil {
+		return fmt.Errorf("invalid verification configuration: %w", err)
+	}
+	return nil
+}
+
+// loadHistory reads the configuration history variables. HAPROXY_HISTORY_CONFIGMAP is
+// "namespace/name", or a name in the ingress namespace.
+func loadHistory(cfg *Config) error {
+	cfg.HistoryFile = getEnv("HAPROXY_HISTORY_FILE", "")
+	cfg.HistoryLimit = haproxy.DefaultHistoryLimit
+	if err := intEnv("HAPROXY_HISTORY_LIMIT", &cfg.HistoryLimit); err != nil {
+		return err
+	}
+	if cfg.HistoryLimit <= 0 {
+		return fmt.Errorf("HAPROXY_HISTORY_LIMIT must be positive")
+	}
+
+	ref := getEnv("HAPROXY_HISTORY_CONFIGMAP", "")
+	if ref == "" {
+		return nil
+	}
+	if cfg.HistoryFile != "" {
+		return fmt.Errorf("HAPROXY_HISTORY_FILE and HAPROXY_HISTORY_CONFIGMAP are mutually exclusive")
+	}
+	cfg.HistoryNamespace, cfg.HistoryConfigMap = cfg.IngressNamespace, ref
+	if ns, name, ok := strings.Cut(ref, "/"); ok {
+		cfg.HistoryNamespace, cfg.HistoryConfigMap = ns, name
+	}
+	if cfg.HistoryNamespace == "" || cfg.HistoryConfigMap == "" {
+		return fmt.Errorf("invalid HAPROXY_HISTORY_CONFIGMAP %q: expected name or namespace/name", ref)
+	}
+	return nil
+}
+
+// loadAgent reads the HAPROXY_AGENT_* variables. The agent port HAProxy connects to
+// defaults to the listen port, which fits hostNetwork or a Service with the same port.
+func loadAgent(cfg *Config) error {
+	if err := boolEnv("HAPROXY_AGENT_CHECK", &cfg.AgentCheck); err != nil {
+		return err
+	}
+	cfg.AgentListenAddr = getEnv("HAPROXY_AGENT_LISTEN_ADDR", haproxy.DefaultAgentListenAddr)
+	if !cfg.AgentCheck {
+		return nil
+	}
+
+	_, listenPort, err := net.SplitHostPort(cfg.AgentListenAddr)
+	if err != nil {
+		return fmt.Errorf("invalid HAPROXY_AGENT_LISTEN_ADDR value %q: %w", cfg.AgentListenAddr, err)
+	}
+	port, err := strconv.Atoi(getEnv("HAPROXY_AGENT_PORT", listenPort))
+	if err != nil {
+		return fmt.Errorf("invalid HAPROXY_AGENT_PORT value: %w", err)
+	}
+	cfg.Agent = haproxy.AgentConfig{
+		Addr:     os.Getenv("HAPROXY_AGENT_ADDR"),
+		Port:     int32(port),
+		Interval: haproxy.DefaultAgentInterval,
+	}
+	if err := durationEnv("HAPROXY_AGENT_INTER", &cfg.Agent.Interval); err != nil {
+		return err
+	}
+	if err := cfg.Agent.Validate(); err != nil {
+		return fmt.Errorf("invalid agent-check configuration: %w", err)
+	}
+	return nil
+}
+
+// loadBalance reads the balance algorithm and session persistence variables.
+func loadBalance(b *haproxy.BalanceConfig, p *haproxy.PersistenceConfig) error {
+	b.Algorithm = getEnv("HAPROXY_BALANCE", haproxy.DefaultBalanceAlgorithm)
+	b.HashType = os.Getenv("HAPROXY_HASH_TYPE")
+	if err := b.Validate(); err != nil {
+		return fmt.Errorf("invalid balance configuration: %w", err)
+	}
+
+	p.CookieName = os.Getenv("HAPROXY_COOKIE_NAME")
+	p.CookieMode = getEnv("HAPROXY_COOKIE_MODE", haproxy.DefaultCookieMode)
+	p.StickTableSize = haproxy.DefaultStickTableSize
+	p.StickTableExpire = haproxy.DefaultStickTableExpire
+	if err := boolEnv("HAPROXY_STICK_ON_SRC", &p.StickOnSource); err != nil {
+		return err
+	}
+	if err := intEnv("HAPROXY_STICK_TABLE_SIZE", &p.StickTableSize); err != nil {
+		return err
+	}
+	if err := durationEnv("HAPROXY_STICK_TABLE_EXPIRE", &p.StickTableExpire); err != nil {
+		return err
+	}
+	if err := p.Validate(); err != nil {
+		return fmt.Errorf("invalid persistence configuration: %w", err)
+	}
+	return nil
+}
+
+// readTemplate returns the inline value of key, or the contents of the file named by key_FILE.
+func readTemplate(key string) ([]byte, error) {
+	inline := os.Getenv(key)
+	file := os.Getenv(key + "_FILE")
+	switch {
+	case inline != "" && file != "":
+		return nil, fmt.Errorf("only one of %s and %s_FILE may be set", key, key)
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s_FILE: %w", key, err)
+		}
+		return data, nil
+	default:
+		return []byte(inline), nil
+	}
+}
+
+func intEnv(key string, dst *int) error {
+	if v := os.Getenv(key); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s value %q: %w", key, v, err)
+		}
+		*dst = n
+	}
+	return nil
+}
+
+func durationEnv(key string, dst *time.Duration) error {
+	if v := os.Getenv(key); v != "" {
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s value %q: %w", key, v, err)
+		}
+		*dst = dur
+	}
+	return nil
+}
+
+func floatEnv(key string, dst *float64) error {
+	if v := os.Getenv(key); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s value %q: %w", key, v, err)
+		}
+		*dst = f
+	}
+	return nil
+}
+
+func boolEnv(key string, dst *bool) error {
+	if v := os.Getenv(key); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s value %q: %w", key, v, err)
+		}
+		*dst = b
+	}
+	return nil
+}
+
+// splitList splits a comma-separated value, dropping empty items.
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
 func getEnv(key, defaultValue string) string {
 	if v, ok := os.LookupEnv(key); ok {
 		return v
@@ -8,22 +8,28 @@ import (
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
-	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 
 	"example.com/haproxy-k8s-sync/internal/k8s"
+	"example.com/haproxy-k8s-sync/pkg/haproxy"
 )
 
 const queueKey = "ingress-backends"
 
 // BackendSyncer reconciles Kubernetes endpoints to HAProxy backends.
 type BackendSyncer interface {
-	Sync(ctx context.Context, slices []*discoveryv1.EndpointSlice, endpoints []*corev1.Endpoints, nodeIPs map[string]string) error
+	Sync(ctx context.Context, state haproxy.ClusterState) error
 }
 
-// Controller watches Endpoints and EndpointSlices and syncs HAProxy backends.
+// DelayedSyncer is implemented by syncers that need another sync after a delay even when
+// nothing changes in Kubernetes, e.g. when a dampening period ends.
+type DelayedSyncer interface {
+	NextSync() (time.Duration, bool)
+}
+
+// Controller watches Endpoints and EndpointSlices (or ingress Pods in host-port mode) and syncs HAProxy backends.
 type Controller struct {
 	queue             workqueue.RateLimitingInterface
 	informers         *k8s.Informers
@@ -48,9 +54,7 @@ func NewController(informers *k8s.Informers, syncer BackendSyncer, workerCount i
 		DeleteFunc: func(_ interface{}) { c.enqueue(nil) },
 	}
 
-	informers.EndpointsInformer.AddEventHandler(handler)
-	informers.EndpointSliceInformer.AddEventHandler(handler)
-	informers.NodeInformer.AddEventHandler(handler)
+	informers.AddEventHandler(handler)
 
 	return c
 }
@@ -75,6 +79,11 @@ func (c *Controller) Run(ctx context.Context) error {
 	return nil
 }
 
+// Resync requests a reconcile outside of informer events, e.g. when adaptive weights change.
+func (c *Controller) Resync() {
+	c.enqueue(nil)
+}
+
 func (c *Controller) enqueue(_ interface{}) {
 	c.queue.Add(queueKey)
 }
@@ -99,23 +108,41 @@ func (c *Controller) processNextWorkItem(ctx context.Context) bool {
 	}
 
 	c.queue.Forget(item)
+	if delayed, ok := c.syncer.(DelayedSyncer); ok {
+		if after, ok := delayed.NextSync(); ok {
+			c.queue.AddAfter(queueKey, after)
+		}
+	}
 	return true
 }
 
 func (c *Controller) sync(ctx context.Context) error {
-	slices, err := c.informers.EndpointSliceLister.List(labels.Everything())
-	if err != nil {
-		return fmt.Errorf("listing endpoint slices: %w", err)
+	var state haproxy.ClusterState
+
+	if c.informers.EndpointSliceLister != nil {
+		slices, err := c.informers.EndpointSliceLister.List(labels.Everything())
+		if err != nil {
+			return fmt.Errorf("listing endpoint slices: %w", err)
+		}
+		for i := range slices {
+			state.EndpointSlices = append(state.EndpointSlices, slices[i])
+		}
 	}
 
-	var slicePtrs []*discoveryv1.EndpointSlice
-	for i := range slices {
-		slicePtrs = append(slicePtrs, slices[i])
+	if c.informers.EndpointsLister != nil {
+		endpoints, err := c.informers.EndpointsLister.List(labels.Everything())
+		if err != nil {
+			return fmt.Errorf("listing endpoints: %w", err)
+		}
+		state.Endpoints = endpoints
 	}
 
-	endpoints, err := c.informers.EndpointsLister.List(labels.Everything())
-	if err != nil {
-		return fmt.Errorf("listing endpoints: %w", err)
+	if c.informers.PodLister != nil {
+		pods, err := c.informers.PodLister.List(labels.Everything())
+		if err != nil {
+			return fmt.Errorf("listing pods: %w", err)
+		}
+		state.Pods = pods
 	}
 
 	nodes, err := c.informers.NodeLister.List(labels.Everything())
@@ -123,16 +150,21 @@ func (c *Controller) sync(ctx context.Context) error {
 		return fmt.Errorf("listing nodes: %w", err)
 	}
 
-	nodeIPs := make(map[string]string, len(nodes))
+	state.Nodes = nodes
+	state.NodeIPs = make(map[string]string, len(nodes))
 	for _, n := range nodes {
 		if ip := internalIP(n); ip != "" {
-			nodeIPs[n.Name] = ip
+			state.NodeIPs[n.Name] = ip
 		}
 	}
 
-	log.Printf("reconciling backends: %d endpoint slices, %d endpoints", len(slicePtrs), len(endpoints))
+	if c.informers.PodLister != nil {
+		log.Printf("reconciling backends: %d ingress pods", len(state.Pods))
+	} else {
+		log.Printf("reconciling backends: %d endpoint slices, %d endpoints", len(state.EndpointSlices), len(state.Endpoints))
+	}
 
-	if err := c.syncer.Sync(ctx, slicePtrs, endpoints, nodeIPs); err != nil {
+	if err := c.syncer.Sync(ctx, state); err != nil {
 		return fmt.Errorf("syncing haproxy backends: %w", err)
 	}
 
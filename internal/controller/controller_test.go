@@ -11,6 +11,7 @@ import (
 	"k8s.io/client-go/kubernetes/fake"
 
 	"example.com/haproxy-k8s-sync/internal/k8s"
+	"example.com/haproxy-k8s-sync/pkg/haproxy"
 )
 
 func TestProcessNextWorkItemInvokesSyncer(t *testing.T) {
@@ -53,18 +54,82 @@ func TestProcessNextWorkItemInvokesSyncer(t *testing.T) {
 	}
 }
 
+func TestProcessNextWorkItemPassesPodsInHostPortMode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	informers := k8s.NewHostPortInformers(client, "ingress-nginx", "app=ingress", 0)
+	syncer := &stubSyncer{}
+	c := NewController(informers, syncer, 1)
+
+	informers.Start(ctx)
+	if ok := informers.WaitForSync(ctx); !ok {
+		t.Fatalf("failed to sync caches")
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress-abc", Namespace: "ingress-nginx"},
+		Spec:       corev1.PodSpec{NodeName: "worker-1"},
+	}
+	if err := informers.PodInformer.GetStore().Add(pod); err != nil {
+		t.Fatalf("failed adding pod to store: %v", err)
+	}
+
+	c.enqueue(nil)
+	if ok := c.processNextWorkItem(ctx); !ok {
+		t.Fatalf("work item was not processed")
+	}
+
+	if len(syncer.last.Pods) != 1 {
+		t.Fatalf("expected 1 pod passed to syncer, got %d", len(syncer.last.Pods))
+	}
+	if syncer.last.EndpointSlices != nil || syncer.last.Endpoints != nil {
+		t.Fatalf("expected no endpoint sources in host-port mode")
+	}
+}
+
 type stubSyncer struct {
 	calls int
+	last  haproxy.ClusterState
 }
 
-func (s *stubSyncer) Sync(_ context.Context, slices []*discoveryv1.EndpointSlice, _ []*corev1.Endpoints, _ map[string]string) error {
-	if len(slices) == 0 {
+func (s *stubSyncer) Sync(_ context.Context, state haproxy.ClusterState) error {
+	s.last = state
+	if len(state.EndpointSlices) == 0 && len(state.Pods) == 0 {
 		return nil
 	}
 	s.calls++
 	return nil
 }
 
+type delayedSyncer struct {
+	stubSyncer
+	after time.Duration
+}
+
+func (s *delayedSyncer) NextSync() (time.Duration, bool) { return s.after, true }
+
+func TestProcessNextWorkItemRequeuesDelayedSync(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	informers := k8s.NewInformers(fake.NewSimpleClientset(), "ingress-nginx", "ingress-nginx", 0)
+	c := NewController(informers, &delayedSyncer{after: 50 * time.Millisecond}, 1)
+
+	c.enqueue(nil)
+	if ok := c.processNextWorkItem(ctx); !ok {
+		t.Fatalf("work item was not processed")
+	}
+	if n := c.queue.Len(); n != 0 {
+		t.Fatalf("expected the next sync to wait, queue has %d items", n)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := c.queue.Len(); n != 1 {
+		t.Fatalf("expected a sync queued after the delay, queue has %d items", n)
+	}
+}
+
 func int32Ptr(v int32) *int32 {
 	return &v
 }
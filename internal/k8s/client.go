@@ -5,16 +5,20 @@ import (
 	"fmt"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	coreinformers "k8s.io/client-go/informers/core/v1"
 	discoveryinformers "k8s.io/client-go/informers/discovery/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	discoverylisters "k8s.io/client-go/listers/discovery/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 )
 
 // BuildConfig builds a Kubernetes rest.Config using in-cluster config by default and falling back to an optional kubeconfig path.
@@ -25,16 +29,27 @@ func BuildConfig(_ context.Context, kubeconfigPath string) (*rest.Config, error)
 	return rest.InClusterConfig()
 }
 
+// NewEventRecorder returns an EventRecorder that publishes Events through the API server as the given component.
+func NewEventRecorder(client kubernetes.Interface, component string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component})
+}
+
 // Informers bundles the shared informers and listers used by the controller.
+// Service mode populates the Endpoints and EndpointSlice informers, host-port mode the Pod informer; the others are nil.
 type Informers struct {
 	EndpointsInformer       cache.SharedIndexInformer
 	EndpointSliceInformer   cache.SharedIndexInformer
+	PodInformer             cache.SharedIndexInformer
 	NodeInformer            cache.SharedIndexInformer
 	EndpointsLister         corelisters.EndpointsLister
 	EndpointSliceLister     discoverylisters.EndpointSliceLister
+	PodLister               corelisters.PodLister
 	NodeLister              corelisters.NodeLister
 	endpointsHasSynced      cache.InformerSynced
 	endpointSlicesHasSynced cache.InformerSynced
+	podHasSynced            cache.InformerSynced
 	nodeHasSynced           cache.InformerSynced
 }
 
@@ -75,14 +90,62 @@ func NewInformers(client kubernetes.Interface, namespace, serviceName string, re
 	}
 }
 
+// NewHostPortInformers sets up informers for ingress Pods matching podSelector in the given namespace.
+// It is used when the ingress controller runs with hostPort or hostNetwork and no Service fronts it.
+func NewHostPortInformers(client kubernetes.Interface, namespace, podSelector string, resync time.Duration) *Informers {
+	podInformer := coreinformers.NewFilteredPodInformer(
+		client,
+		namespace,
+		resync,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+		func(options *metav1.ListOptions) {
+			options.LabelSelector = podSelector
+		},
+	)
+
+	nodeInformer := coreinformers.NewNodeInformer(client, resync, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+
+	return &Informers{
+		PodInformer:   podInformer,
+		NodeInformer:  nodeInformer,
+		PodLister:     corelisters.NewPodLister(podInformer.GetIndexer()),
+		NodeLister:    corelisters.NewNodeLister(nodeInformer.GetIndexer()),
+		podHasSynced:  podInformer.HasSynced,
+		nodeHasSynced: nodeInformer.HasSynced,
+	}
+}
+
 // Start begins informer event processing.
 func (i *Informers) Start(ctx context.Context) {
-	go i.EndpointsInformer.Run(ctx.Done())
-	go i.EndpointSliceInformer.Run(ctx.Done())
-	go i.NodeInformer.Run(ctx.Done())
+	for _, inf := range i.active() {
+		go inf.Run(ctx.Done())
+	}
 }
 
 // WaitForSync blocks until caches have been synced or context is cancelled.
 func (i *Informers) WaitForSync(ctx context.Context) bool {
-	return cache.WaitForCacheSync(ctx.Done(), i.endpointsHasSynced, i.endpointSlicesHasSynced, i.nodeHasSynced)
+	var synced []cache.InformerSynced
+	for _, fn := range []cache.InformerSynced{i.endpointsHasSynced, i.endpointSlicesHasSynced, i.podHasSynced, i.nodeHasSynced} {
+		if fn != nil {
+			synced = append(synced, fn)
+		}
+	}
+	return cache.WaitForCacheSync(ctx.Done(), synced...)
+}
+
+// AddEventHandler registers handler on every configured informer.
+func (i *Informers) AddEventHandler(handler cache.ResourceEventHandler) {
+	for _, inf := range i.active() {
+		inf.AddEventHandler(handler)
+	}
+}
+
+func (i *Informers) active() []cache.SharedIndexInformer {
+	var out []cache.SharedIndexInformer
+	for _, inf := range []cache.SharedIndexInformer{i.EndpointsInformer, i.EndpointSliceInformer, i.PodInformer, i.NodeInformer} {
+		if inf != nil {
+			out = append(out, inf)
+		}
+	}
+	return out
 }
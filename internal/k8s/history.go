@@ -0,0 +1,145 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"example.com/haproxy-k8s-sync/pkg/haproxy"
+)
+
+// historyKey is the ConfigMap data key holding the JSON history.
+const historyKey = "history.json"
+
+// pausedAnnotation on the history ConfigMap pauses the syncs of the controllers using it.
+const pausedAnnotation = "haproxy-sync/paused"
+
+// maxHistorySize keeps the encoded history below the 1 MiB ConfigMap limit, leaving room
+// for the object metadata.
+const maxHistorySize = 900 << 10
+
+// ConfigMapHistoryStore keeps the configuration history in a ConfigMap, creating it on first save.
+type ConfigMapHistoryStore struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+}
+
+// Load reads the entries; a missing ConfigMap is an empty history.
+func (s ConfigMapHistoryStore) Load(ctx context.Context) ([]haproxy.HistoryEntry, error) {
+	cm, err := s.Client.CoreV1().ConfigMaps(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get history ConfigMap %s/%s: %w", s.Namespace, s.Name, err)
+	}
+	return haproxy.DecodeHistory([]byte(cm.Data[historyKey]))
+}
+
+// Save replaces the stored entries, dropping the oldest ones that do not fit in a ConfigMap.
+func (s ConfigMapHistoryStore) Save(ctx context.Context, entries []haproxy.HistoryEntry) error {
+	data, err := encodeHistory(entries, maxHistorySize)
+	if err != nil {
+		return err
+	}
+
+	configMaps := s.Client.CoreV1().ConfigMaps(s.Namespace)
+	cm, err := configMaps.Get(ctx, s.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: s.Name, Namespace: s.Namespace},
+			Data:       map[string]string{historyKey: string(data)},
+		}
+		if _, err := configMaps.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("create history ConfigMap %s/%s: %w", s.Namespace, s.Name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get history ConfigMap %s/%s: %w", s.Namespace, s.Name, err)
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[historyKey] = string(data)
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("update history ConfigMap %s/%s: %w", s.Namespace, s.Name, err)
+	}
+	return nil
+}
+
+// Paused reports whether the ConfigMap carries the pause annotation.
+func (s ConfigMapHistoryStore) Paused(ctx context.Context) (bool, error) {
+	cm, err := s.Client.CoreV1().ConfigMaps(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("get history ConfigMap %s/%s: %w", s.Namespace, s.Name, err)
+	}
+	return cm.Annotations[pausedAnnotation] == "true", nil
+}
+
+// SetPaused sets or removes the pause annotation, creating the ConfigMap if needed.
+func (s ConfigMapHistoryStore) SetPaused(ctx context.Context, paused bool) error {
+	configMaps := s.Client.CoreV1().ConfigMaps(s.Namespace)
+	cm, err := configMaps.Get(ctx, s.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if !paused {
+			return nil
+		}
+		cm = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:        s.Name,
+			Namespace:   s.Namespace,
+			Annotations: map[string]string{pausedAnnotation: "true"},
+		}}
+		if _, err := configMaps.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("create history ConfigMap %s/%s: %w", s.Namespace, s.Name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get history ConfigMap %s/%s: %w", s.Namespace, s.Name, err)
+	}
+
+	if paused {
+		if cm.Annotations == nil {
+			cm.Annotations = map[string]string{}
+		}
+		cm.Annotations[pausedAnnotation] = "true"
+	} else {
+		delete(cm.Annotations, pausedAnnotation)
+	}
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("update history ConfigMap %s/%s: %w", s.Namespace, s.Name, err)
+	}
+	return nil
+}
+
+// encodeHistory encodes the newest entries that fit in limit bytes.
+func encodeHistory(entries []haproxy.HistoryEntry, limit int) ([]byte, error) {
+	for dropped := 0; dropped < len(entries); dropped++ {
+		data, err := json.Marshal(entries[dropped:])
+		if err != nil {
+			return nil, fmt.Errorf("encode history: %w", err)
+		}
+		if len(data) <= limit {
+			if dropped > 0 {
+				log.Printf("dropping the %d oldest history entries to fit the ConfigMap size limit", dropped)
+			}
+			return data, nil
+		}
+	}
+	if len(entries) == 0 {
+		return json.Marshal(entries)
+	}
+	return nil, fmt.Errorf("history entry %d does not fit in a ConfigMap (%d bytes allowed)", entries[len(entries)-1].ID, limit)
+}
@@ -0,0 +1,115 @@
+package k8s
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"example.com/haproxy-k8s-sync/pkg/haproxy"
+)
+
+func TestConfigMapHistoryStore(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	store := ConfigMapHistoryStore{Client: client, Namespace: "ingress", Name: "haproxy-history"}
+
+	entries, err := store.Load(ctx)
+	if err != nil || entries != nil {
+		t.Fatalf("expected an empty history without the ConfigMap, got %+v: %v", entries, err)
+	}
+
+	first := []haproxy.HistoryEntry{{ID: 1, BackendSnapshot: haproxy.BackendSnapshot{Version: 7, Servers: []haproxy.BackendServer{{Name: "a", Address: "10.0.0.1", Port: 443}}}}}
+	if err := store.Save(ctx, first); err != nil {
+		t.Fatalf("save (create): %v", err)
+	}
+	second := append(first, haproxy.HistoryEntry{ID: 2, BackendSnapshot: haproxy.BackendSnapshot{Servers: []haproxy.BackendServer{{Name: "b", Address: "10.0.0.2", Port: 443}}}})
+	if err := store.Save(ctx, second); err != nil {
+		t.Fatalf("save (update): %v", err)
+	}
+
+	var verbs []string
+	for _, a := range client.Actions() {
+		verbs = append(verbs, a.GetVerb())
+	}
+	if got := strings.Join(verbs, ","); got != "get,get,create,get,update" {
+		t.Fatalf("unexpected actions %s", got)
+	}
+
+	entries, err = store.Load(ctx)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Version != 7 || entries[1].ID != 2 || entries[1].Servers[0].Name != "b" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+
+	cm, err := client.CoreV1().ConfigMaps("ingress").Get(ctx, "haproxy-history", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get ConfigMap: %v", err)
+	}
+	// Unset fields are omitted from the stored entries.
+	if data := cm.Data[historyKey]; strings.Contains(data, "NodeName") || strings.Contains(data, "ReadyEndpoints") {
+		t.Fatalf("stored history contains empty fields: %s", data)
+	}
+}
+
+func TestEncodeHistoryTrimsToLimit(t *testing.T) {
+	var entries []haproxy.HistoryEntry
+	for v := int64(1); v <= 5; v++ {
+		entries = append(entries, haproxy.HistoryEntry{ID: v, BackendSnapshot: haproxy.BackendSnapshot{Servers: []haproxy.BackendServer{{Name: strings.Repeat("x", 100)}}}})
+	}
+	one, err := encodeHistory(entries[4:], maxHistorySize)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	data, err := encodeHistory(entries, 2*len(one))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	kept, err := haproxy.DecodeHistory(data)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(kept) != 2 || kept[0].ID != 4 || kept[1].ID != 5 {
+		t.Fatalf("expected the newest entries to be kept, got %+v", kept)
+	}
+
+	if _, err := encodeHistory(entries, len(one)/2); err == nil {
+		t.Fatalf("expected an entry larger than the limit to fail")
+	}
+}
+
+func TestConfigMapHistoryStorePause(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	store := ConfigMapHistoryStore{Client: client, Namespace: "ingress", Name: "haproxy-history"}
+
+	if err := store.SetPaused(ctx, true); err != nil {
+		t.Fatalf("pause (create): %v", err)
+	}
+	if paused, err := store.Paused(ctx); err != nil || !paused {
+		t.Fatalf("expected paused, got %v: %v", paused, err)
+	}
+	// Saving entries keeps the annotation.
+	if err := store.Save(ctx, []haproxy.HistoryEntry{{ID: 1}}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if paused, err := store.Paused(ctx); err != nil || !paused {
+		t.Fatalf("expected paused after save, got %v: %v", paused, err)
+	}
+
+	if err := store.SetPaused(ctx, false); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	cm, err := client.CoreV1().ConfigMaps("ingress").Get(ctx, "haproxy-history", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get ConfigMap: %v", err)
+	}
+	if _, ok := cm.Annotations[pausedAnnotation]; ok || cm.Data[historyKey] == "" {
+		t.Fatalf("expected the annotation removed and the history kept, got %+v", cm)
+	}
+}
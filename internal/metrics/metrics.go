@@ -0,0 +1,103 @@
+// Package metrics exposes controller metrics in the Prometheus text format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Sample is one value of a metric with its labels.
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+type collector struct {
+	name    string
+	help    string
+	kind    string
+	collect func() []Sample
+}
+
+// Registry holds metrics that are read from their owners at scrape time.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Gauge registers a gauge whose samples are returned by collect.
+func (r *Registry) Gauge(name, help string, collect func() []Sample) {
+	r.register(collector{name: name, help: help, kind: "gauge", collect: collect})
+}
+
+// Counter registers a counter whose samples are returned by collect.
+func (r *Registry) Counter(name, help string, collect func() []Sample) {
+	r.register(collector{name: name, help: help, kind: "counter", collect: collect})
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+	sort.Slice(r.collectors, func(i, j int) bool { return r.collectors[i].name < r.collectors[j].name })
+}
+
+// Write renders all metrics.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, c := range collectors {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", c.name, escapeHelp(c.help), c.name, c.kind)
+		for _, s := range c.collect() {
+			fmt.Fprintf(&buf, "%s%s %v\n", c.name, formatLabels(s.Labels), s.Value)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ServeHTTP serves the metrics for scraping.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.Write(w)
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+`="`+escapeLabelValue(labels[k])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// escapeLabelValue escapes a label value as the text format specifies: only backslash, double
+// quote and line feed; everything else, including non-ASCII UTF-8, is written as is.
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
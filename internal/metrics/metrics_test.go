@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("haproxy_sync_servers_dampened", "Servers held in maint by dampening.", func() []Sample {
+		return []Sample{
+			{Labels: map[string]string{"reason": "hold_down"}, Value: 2},
+			{Labels: map[string]string{"reason": "flapping"}, Value: 1},
+		}
+	})
+	r.Counter("haproxy_sync_server_flaps_total", "Servers detected as flapping.", func() []Sample {
+		return []Sample{{Value: 3}}
+	})
+
+	var out strings.Builder
+	if err := r.Write(&out); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	want := `# HELP haproxy_sync_server_flaps_total Servers detected as flapping.
+# TYPE haproxy_sync_server_flaps_total counter
+haproxy_sync_server_flaps_total 3
+# HELP haproxy_sync_servers_dampened Servers held in maint by dampening.
+# TYPE haproxy_sync_servers_dampened gauge
+haproxy_sync_servers_dampened{reason="hold_down"} 2
+haproxy_sync_servers_dampened{reason="flapping"} 1
+`
+	if out.String() != want {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestRegistryEscapesLabelValues(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("haproxy_sync_server_weight", "Server weights.", func() []Sample {
+		return []Sample{{Labels: map[string]string{"server": "nœud-1 \"a\\b\"\nc\t"}, Value: 1}}
+	})
+	var out strings.Builder
+	if err := r.Write(&out); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	want := "haproxy_sync_server_weight{server=\"nœud-1 \\\"a\\\\b\\\"\\nc\t\"} 1\n"
+	if !strings.HasSuffix(out.String(), want) {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+}
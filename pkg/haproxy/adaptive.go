@@ -0,0 +1,245 @@
+package haproxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for adaptive weights.
+const (
+	DefaultAdaptiveInterval       = 10 * time.Second
+	DefaultAdaptiveMaxRespTime    = 500 * time.Millisecond
+	DefaultAdaptiveMaxErrorRate   = 0.05
+	DefaultAdaptiveMinPercent     = 25
+	DefaultAdaptiveStep           = 25
+	DefaultAdaptiveRecoverSamples = 3
+)
+
+// ServerStats is the subset of HAProxy runtime statistics used for adaptive weights.
+type ServerStats struct {
+	Name string
+	// CurrentSessions is scur; TotalSessions is the cumulative stot counter.
+	CurrentSessions int64
+	TotalSessions   int64
+	// ResponseTime is rtime, the average response time over the last 1024 requests.
+	ResponseTime time.Duration
+	// ResponseErrors (eresp) and ConnectionErrors (econ) are cumulative counters.
+	ResponseErrors   int64
+	ConnectionErrors int64
+	CheckStatus      string
+	Status           string
+}
+
+// StatsClient reads runtime statistics for the managed backend's servers.
+type StatsClient interface {
+	ServerStats(ctx context.Context) ([]ServerStats, error)
+}
+
+// AdaptiveConfig tunes how runtime statistics scale server weights.
+type AdaptiveConfig struct {
+	Interval time.Duration
+	// MaxResponseTime and MaxErrorRate (errors per new session) mark a server as degraded.
+	MaxResponseTime time.Duration
+	MaxErrorRate    float64
+	// MinPercent bounds how far a weight can be reduced; Step is the change per sample.
+	MinPercent int
+	Step       int
+	// RecoverSamples is the number of consecutive clearly healthy samples before a weight is raised again.
+	RecoverSamples int
+}
+
+// DefaultAdaptiveConfig returns the adaptive weight settings used when nothing is configured.
+func DefaultAdaptiveConfig() AdaptiveConfig {
+	return AdaptiveConfig{
+		Interval:        DefaultAdaptiveInterval,
+		MaxResponseTime: DefaultAdaptiveMaxRespTime,
+		MaxErrorRate:    DefaultAdaptiveMaxErrorRate,
+		MinPercent:      DefaultAdaptiveMinPercent,
+		Step:            DefaultAdaptiveStep,
+		RecoverSamples:  DefaultAdaptiveRecoverSamples,
+	}
+}
+
+// Validate checks the thresholds and bounds.
+func (a AdaptiveConfig) Validate() error {
+	if a.Interval < time.Second {
+		return fmt.Errorf("adaptive interval must be at least 1s, got %s", a.Interval)
+	}
+	if a.MaxResponseTime <= 0 {
+		return fmt.Errorf("adaptive max response time must be positive")
+	}
+	if a.MaxErrorRate <= 0 || a.MaxErrorRate > 1 {
+		return fmt.Errorf("adaptive max error rate must be in (0, 1], got %v", a.MaxErrorRate)
+	}
+	if a.MinPercent < 1 || a.MinPercent > 100 {
+		return fmt.Errorf("adaptive min percent must be 1-100, got %d", a.MinPercent)
+	}
+	if a.Step < 1 || a.Step > 100 {
+		return fmt.Errorf("adaptive step must be 1-100, got %d", a.Step)
+	}
+	if a.RecoverSamples < 1 {
+		return fmt.Errorf("adaptive recover samples must be at least 1")
+	}
+	return nil
+}
+
+// AdaptiveWeights keeps a weight factor per server derived from runtime statistics.
+// A degraded server loses Step percent per sample down to MinPercent; it only regains
+// weight after RecoverSamples samples well below the thresholds, which avoids oscillation.
+type AdaptiveWeights struct {
+	client StatsClient
+	config AdaptiveConfig
+
+	mu      sync.Mutex
+	factors map[string]int
+	healthy map[string]int
+	last    map[string]ServerStats
+}
+
+// NewAdaptiveWeights builds an AdaptiveWeights reading statistics from client.
+func NewAdaptiveWeights(client StatsClient, config AdaptiveConfig) *AdaptiveWeights {
+	return &AdaptiveWeights{
+		client:  client,
+		config:  config,
+		factors: make(map[string]int),
+		healthy: make(map[string]int),
+		last:    make(map[string]ServerStats),
+	}
+}
+
+// Run samples statistics every Interval until ctx is done and calls onChange when a factor changed.
+func (a *AdaptiveWeights) Run(ctx context.Context, onChange func()) {
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stats, err := a.client.ServerStats(ctx)
+		if err != nil {
+			log.Printf("reading server stats failed: %v", err)
+			continue
+		}
+		if a.Observe(stats) && onChange != nil {
+			onChange()
+		}
+	}
+}
+
+// Observe feeds one statistics sample and reports whether any factor changed.
+func (a *AdaptiveWeights) Observe(stats []ServerStats) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	changed := false
+	seen := make(map[string]struct{}, len(stats))
+	for _, st := range stats {
+		seen[st.Name] = struct{}{}
+		prev, hasPrev := a.last[st.Name]
+		a.last[st.Name] = st
+
+		factor, ok := a.factors[st.Name]
+		if !ok {
+			factor = 100
+		}
+		rate := 0.0
+		if hasPrev {
+			rate = errorRate(prev, st)
+		}
+
+		next := factor
+		switch {
+		case a.degraded(st, rate):
+			a.healthy[st.Name] = 0
+			next = max(factor-a.config.Step, a.config.MinPercent)
+		case a.recovering(st, rate):
+			a.healthy[st.Name]++
+			if factor < 100 && a.healthy[st.Name] >= a.config.RecoverSamples {
+				a.healthy[st.Name] = 0
+				next = min(factor+a.config.Step, 100)
+			}
+		default:
+			// Between the thresholds: hold the current factor.
+			a.healthy[st.Name] = 0
+		}
+
+		if next != factor {
+			log.Printf("adaptive weight for %s: %d%% -> %d%% (rtime %s, error rate %.3f, check %s)", st.Name, factor, next, st.ResponseTime, rate, st.CheckStatus)
+			changed = true
+		}
+		a.factors[st.Name] = next
+	}
+
+	for name := range a.factors {
+		if _, ok := seen[name]; !ok {
+			delete(a.factors, name)
+			delete(a.healthy, name)
+			delete(a.last, name)
+		}
+	}
+	return changed
+}
+
+// Factor returns the weight percentage for a server, 100 when unknown.
+func (a *AdaptiveWeights) Factor(name string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if f, ok := a.factors[name]; ok {
+		return f
+	}
+	return 100
+}
+
+// Apply scales server weights by their factor, never going below lower.
+// Drained servers (weight 0) are left alone.
+func (a *AdaptiveWeights) Apply(servers []BackendServer, lower int) []BackendServer {
+	for i := range servers {
+		f := a.Factor(servers[i].Name)
+		if f == 100 || servers[i].Weight == 0 {
+			continue
+		}
+		w := (servers[i].Weight*f + 99) / 100
+		servers[i].Weight = clampWeight(w, lower, servers[i].Weight)
+	}
+	return servers
+}
+
+func (a *AdaptiveWeights) degraded(st ServerStats, rate float64) bool {
+	return !checkPassing(st.CheckStatus) || st.ResponseTime > a.config.MaxResponseTime || rate > a.config.MaxErrorRate
+}
+
+// recovering requires a margin below the thresholds so a server hovering around them keeps its factor.
+func (a *AdaptiveWeights) recovering(st ServerStats, rate float64) bool {
+	return st.ResponseTime <= a.config.MaxResponseTime*4/5 && rate <= a.config.MaxErrorRate/2
+}
+
+func errorRate(prev, cur ServerStats) float64 {
+	sessions := cur.TotalSessions - prev.TotalSessions
+	errors := (cur.ResponseErrors + cur.ConnectionErrors) - (prev.ResponseErrors + prev.ConnectionErrors)
+	// Counters reset when HAProxy reloads; skip the sample rather than report a bogus rate.
+	if sessions <= 0 || errors < 0 {
+		return 0
+	}
+	return float64(errors) / float64(sessions)
+}
+
+// checkPassing treats unknown or not-yet-run checks as passing; only reported failures count.
+func checkPassing(status string) bool {
+	status = strings.TrimPrefix(strings.TrimSpace(status), "* ")
+	switch {
+	case status == "", status == "INI", status == "UNK":
+		return true
+	case strings.HasPrefix(status, "L4OK"), strings.HasPrefix(status, "L6OK"), strings.HasPrefix(status, "L7OK"):
+		return true
+	default:
+		return false
+	}
+}
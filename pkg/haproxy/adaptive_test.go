@@ -0,0 +1,96 @@
+package haproxy
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveWeightsHysteresis(t *testing.T) {
+	cfg := DefaultAdaptiveConfig()
+	a := NewAdaptiveWeights(nil, cfg)
+
+	slow := []ServerStats{{Name: "worker-1-443", ResponseTime: time.Second, CheckStatus: "L4OK"}}
+	borderline := []ServerStats{{Name: "worker-1-443", ResponseTime: 450 * time.Millisecond, CheckStatus: "L4OK"}}
+	fast := []ServerStats{{Name: "worker-1-443", ResponseTime: 50 * time.Millisecond, CheckStatus: "L4OK"}}
+
+	steps := []struct {
+		stats   []ServerStats
+		want    int
+		changed bool
+	}{
+		{stats: slow, want: 75, changed: true},
+		{stats: slow, want: 50, changed: true},
+		{stats: slow, want: 25, changed: true},
+		{stats: slow, want: 25},
+		{stats: borderline, want: 25},
+		{stats: fast, want: 25},
+		{stats: fast, want: 25},
+		{stats: borderline, want: 25},
+		{stats: fast, want: 25},
+		{stats: fast, want: 25},
+		{stats: fast, want: 50, changed: true},
+	}
+	for i, step := range steps {
+		if changed := a.Observe(step.stats); changed != step.changed {
+			t.Fatalf("step %d: expected changed=%v", i, step.changed)
+		}
+		if got := a.Factor("worker-1-443"); got != step.want {
+			t.Fatalf("step %d: expected factor %d, got %d", i, step.want, got)
+		}
+	}
+
+	servers := a.Apply([]BackendServer{{Name: "worker-1-443", Weight: 100}, {Name: "other-443", Weight: 100}, {Name: "worker-1-443", Weight: 0}}, 1)
+	if servers[0].Weight != 50 || servers[1].Weight != 100 || servers[2].Weight != 0 {
+		t.Fatalf("unexpected scaled weights: %+v", servers)
+	}
+
+	if a.Observe(nil); a.Factor("worker-1-443") != 100 {
+		t.Fatalf("expected factor of removed server to be forgotten")
+	}
+}
+
+func TestAdaptiveWeightsErrorRateAndChecks(t *testing.T) {
+	a := NewAdaptiveWeights(nil, DefaultAdaptiveConfig())
+
+	a.Observe([]ServerStats{{Name: "s", TotalSessions: 1000, ResponseErrors: 10, CheckStatus: "L7OK"}})
+	if a.Factor("s") != 100 {
+		t.Fatalf("first sample has no rate and should not degrade")
+	}
+	a.Observe([]ServerStats{{Name: "s", TotalSessions: 1100, ResponseErrors: 30, CheckStatus: "L7OK"}})
+	if a.Factor("s") != 75 {
+		t.Fatalf("expected 20%% error rate to degrade, got %d", a.Factor("s"))
+	}
+	a.Observe([]ServerStats{{Name: "s", TotalSessions: 10, CheckStatus: "L4CON"}})
+	if a.Factor("s") != 50 {
+		t.Fatalf("expected failing check to degrade, got %d", a.Factor("s"))
+	}
+}
+
+func TestDataPlaneClientServerStats(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("GET /v3/services/haproxy/stats/native", http.StatusOK, `[{"runtimeAPI":"/var/run/haproxy.sock","stats":[
+		{"type":"backend","name":"be_ingress","backend_name":"be_ingress","stats":{"scur":9}},
+		{"type":"server","name":"worker-1-443","backend_name":"be_ingress","stats":{"scur":3,"stot":120,"rtime":42,"eresp":1,"econ":2,"check_status":"L4OK","status":"UP"}},
+		{"type":"server","name":"other","backend_name":"be_other","stats":{"scur":1}}
+	]}]`)
+
+	c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+	stats, err := c.ServerStats(context.Background())
+	if err != nil {
+		t.Fatalf("server stats: %v", err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("expected one server of be_ingress, got %+v", stats)
+	}
+	want := ServerStats{Name: "worker-1-443", CurrentSessions: 3, TotalSessions: 120, ResponseTime: 42 * time.Millisecond, ResponseErrors: 1, ConnectionErrors: 2, CheckStatus: "L4OK", Status: "UP"}
+	if stats[0] != want {
+		t.Fatalf("unexpected stats: %+v", stats[0])
+	}
+
+	req, _ := fake.last("GET /v3/services/haproxy/stats/native")
+	if req.Query != "parent=be_ingress&type=server" {
+		t.Fatalf("unexpected stats query: %v", req.Query)
+	}
+}
@@ -0,0 +1,186 @@
+package haproxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Defaults for the embedded agent-check server.
+const (
+	DefaultAgentListenAddr = ":8081"
+	DefaultAgentInterval   = 2 * time.Second
+	agentReadTimeout       = 2 * time.Second
+)
+
+// AgentConfig describes how HAProxy reaches the embedded agent-check server.
+type AgentConfig struct {
+	// Addr and Port are what HAProxy connects to (agent-addr, agent-port).
+	Addr string
+	Port int32
+	// Interval is the agent-inter between two agent checks.
+	Interval time.Duration
+}
+
+// Validate checks the agent address, port and interval.
+func (a AgentConfig) Validate() error {
+	if a.Addr == "" {
+		return fmt.Errorf("agent address is required")
+	}
+	if a.Port <= 0 || a.Port > 65535 {
+		return fmt.Errorf("invalid agent port %d", a.Port)
+	}
+	if a.Interval < time.Millisecond {
+		return fmt.Errorf("agent interval must be at least 1ms, got %s", a.Interval)
+	}
+	return nil
+}
+
+// AgentStatus is the state the agent reports for one server.
+type AgentStatus struct {
+	// State is ready, drain or maint; Down marks the server down regardless of State.
+	State ServerState
+	Down  bool
+	// WeightPercent scales the configured server weight, 0-100.
+	WeightPercent int
+}
+
+// reply renders the status in the agent-check response format.
+func (s AgentStatus) reply() string {
+	switch {
+	case s.State == ServerStateMaint:
+		return "maint\n"
+	case s.Down:
+		return "down\n"
+	case s.State == ServerStateDrain:
+		return "drain\n"
+	default:
+		return fmt.Sprintf("ready up %d%%\n", s.WeightPercent)
+	}
+}
+
+// AgentStatuses derives agent answers from the servers built for a sync. Servers without
+// ready endpoints are drained while endpoints terminate and reported down otherwise; cordoned
+// nodes are drained and partially ready nodes get a proportional weight.
+func AgentStatuses(servers []BackendServer, nodes []*corev1.Node) map[string]AgentStatus {
+	cordoned := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		if n != nil && n.Spec.Unschedulable {
+			cordoned[n.Name] = true
+		}
+	}
+
+	statuses := make(map[string]AgentStatus, len(servers))
+	for _, srv := range servers {
+		status := AgentStatus{State: srv.State, WeightPercent: 100}
+		if status.State == "" {
+			status.State = ServerStateReady
+		}
+		total := srv.ReadyEndpoints + srv.TerminatingEndpoints + srv.NotReadyEndpoints
+		switch {
+		case srv.ReadyEndpoints == 0 && srv.TerminatingEndpoints > 0:
+			if status.State == ServerStateReady {
+				status.State = ServerStateDrain
+			}
+		case srv.ReadyEndpoints == 0:
+			status.Down = true
+		case total > srv.ReadyEndpoints:
+			status.WeightPercent = (100*srv.ReadyEndpoints + total - 1) / total
+		}
+		if cordoned[srv.NodeName] && status.State == ServerStateReady {
+			status.State = ServerStateDrain
+		}
+		statuses[srv.Name] = status
+	}
+	return statuses
+}
+
+// AgentServer answers HAProxy agent checks from the latest Kubernetes state. HAProxy sends
+// the server name (agent-send) and receives the status computed by the last sync.
+type AgentServer struct {
+	config AgentConfig
+
+	mu       sync.RWMutex
+	statuses map[string]AgentStatus
+}
+
+// NewAgentServer builds an AgentServer; it answers nothing until the first Update.
+func NewAgentServer(config AgentConfig) *AgentServer {
+	return &AgentServer{config: config}
+}
+
+// Config returns the agent settings written to the managed servers.
+func (a *AgentServer) Config() AgentConfig {
+	return a.config
+}
+
+// Update replaces the reported statuses.
+func (a *AgentServer) Update(statuses map[string]AgentStatus) {
+	a.mu.Lock()
+	a.statuses = statuses
+	a.mu.Unlock()
+}
+
+// Status returns the current status for a server name.
+func (a *AgentServer) Status(name string) (AgentStatus, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	status, ok := a.statuses[name]
+	return status, ok
+}
+
+// ListenAndServe listens on addr and serves agent checks until ctx is done.
+func (a *AgentServer) ListenAndServe(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("agent listen: %w", err)
+	}
+	return a.Serve(ctx, ln)
+}
+
+// Serve accepts agent-check connections on ln until ctx is done.
+func (a *AgentServer) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("agent accept: %w", err)
+		}
+		go a.handle(conn)
+	}
+}
+
+func (a *AgentServer) handle(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(agentReadTimeout))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	name := strings.TrimSpace(line)
+
+	status, ok := a.Status(name)
+	if !ok {
+		// Closing without an answer counts as an agent failure, which leaves the server unchanged.
+		return
+	}
+	if _, err := conn.Write([]byte(status.reply())); err != nil {
+		log.Printf("agent reply for %s failed: %v", name, err)
+	}
+}
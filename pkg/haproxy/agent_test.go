@@ -0,0 +1,111 @@
+package haproxy
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAgentStatuses(t *testing.T) {
+	servers := []BackendServer{
+		{Name: "ready", NodeName: "n1", ReadyEndpoints: 2},
+		{Name: "partial", NodeName: "n2", ReadyEndpoints: 1, NotReadyEndpoints: 2},
+		{Name: "terminating", NodeName: "n3", TerminatingEndpoints: 1},
+		{Name: "notready", NodeName: "n4", NotReadyEndpoints: 1},
+		{Name: "cordoned", NodeName: "n5", ReadyEndpoints: 1},
+		{Name: "maint", NodeName: "n5", ReadyEndpoints: 1, State: ServerStateMaint},
+	}
+	nodes := []*corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "n5"}, Spec: corev1.NodeSpec{Unschedulable: true}}}
+
+	want := map[string]string{
+		"ready":       "ready up 100%\n",
+		"partial":     "ready up 34%\n",
+		"terminating": "drain\n",
+		"notready":    "down\n",
+		"cordoned":    "drain\n",
+		"maint":       "maint\n",
+	}
+	statuses := AgentStatuses(servers, nodes)
+	for name, reply := range want {
+		if got := statuses[name].reply(); got != reply {
+			t.Fatalf("%s: expected reply %q, got %q", name, reply, got)
+		}
+	}
+}
+
+func TestAgentServerAnswersQueries(t *testing.T) {
+	agent := NewAgentServer(AgentConfig{Addr: "10.0.0.10", Port: 8081, Interval: time.Second})
+	agent.Update(map[string]AgentStatus{"worker-1-443": {State: ServerStateDrain}})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = agent.Serve(ctx, ln) }()
+
+	query := func(name string) string {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte(name + "\n")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		reply, _ := bufio.NewReader(conn).ReadString('\n')
+		return reply
+	}
+
+	if got := query("worker-1-443"); got != "drain\n" {
+		t.Fatalf("expected drain, got %q", got)
+	}
+	if got := query("unknown-443"); got != "" {
+		t.Fatalf("expected no answer for unknown server, got %q", got)
+	}
+}
+
+func TestSyncWithAgentKeepsTerminatingServers(t *testing.T) {
+	client := &stubClient{}
+	agent := NewAgentServer(AgentConfig{Addr: "10.0.0.10", Port: 8081, Interval: 2 * time.Second})
+	s := NewSyncerWithOptions(client, SyncerOptions{Agent: agent})
+
+	node := "worker-1"
+	port := int32(443)
+	notReady, terminating := false, true
+	state := ClusterState{EndpointSlices: []*discoveryv1.EndpointSlice{{
+		Ports: []discoveryv1.EndpointPort{{Port: &port}},
+		Endpoints: []discoveryv1.Endpoint{{
+			Addresses:  []string{"10.0.0.1"},
+			NodeName:   &node,
+			Conditions: discoveryv1.EndpointConditions{Ready: &notReady, Terminating: &terminating},
+		}},
+	}}}
+	if err := s.Sync(context.Background(), state); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+
+	if len(client.backends) != 1 || client.backends[0].Agent == nil {
+		t.Fatalf("expected terminating server kept with agent settings, got %+v", client.backends)
+	}
+	if status, ok := agent.Status("worker-1-443"); !ok || status.State != ServerStateDrain {
+		t.Fatalf("expected drain status, got %+v (found %v)", status, ok)
+	}
+
+	payload := newServerModel(client.backends[0])
+	if payload.AgentCheck != "enabled" || payload.AgentAddr != "10.0.0.10" || *payload.AgentPort != 8081 ||
+		payload.AgentSend != `worker-1-443\n` || *payload.AgentInter != 2000 {
+		t.Fatalf("unexpected agent payload: %+v", payload)
+	}
+
+	if servers := BuildBackendsFromEndpointSlices(state.EndpointSlices, nil, 0); len(servers) != 0 {
+		t.Fatalf("expected terminating endpoints skipped without agent, got %+v", servers)
+	}
+}
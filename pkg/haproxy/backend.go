@@ -0,0 +1,113 @@
+package haproxy
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ownedBackendFields are the backend fields the controller manages. Any other field
+// read from HAProxy is written back unchanged.
+var ownedBackendFields = []string{
+	"adv_check",
+	"httpchk_params",
+	"check_timeout",
+	"balance",
+	"hash_type",
+	"cookie",
+	"stick_table",
+}
+
+// ownedDefaultServerFields are the default_server parameters the controller manages.
+var ownedDefaultServerFields = []string{
+	"check",
+	"inter",
+	"fastinter",
+	"downinter",
+	"rise",
+	"fall",
+	"health_check_port",
+	"check-ssl",
+	"send-proxy",
+	"send-proxy-v2",
+	"send-proxy-v2-ssl",
+	"send-proxy-v2-ssl-cn",
+	"proxy-v2-options",
+	"check-send-proxy",
+}
+
+// desiredBackend builds the controller-owned part of the backend object.
+func desiredBackend(name string, settings BackendSettings) (backendModel, error) {
+	config := settings.HealthCheck
+	balance, err := balancePayload(settings.Balance.Algorithm)
+	if err != nil {
+		return backendModel{}, err
+	}
+	hashType, err := hashTypePayload(settings.Balance.HashType)
+	if err != nil {
+		return backendModel{}, err
+	}
+	ds := defaultServerPayload(settings)
+	payload := backendModel{backendFields: backendFields{
+		Name:          name,
+		AdvCheck:      "tcp-check",
+		Balance:       balance,
+		HashType:      hashType,
+		CheckTimeout:  int64Ptr(durationMillis(config.Interval)),
+		DefaultServer: &ds,
+	}}
+	if settings.Persistence.CookieName != "" {
+		payload.Cookie = cookiePayload(settings.Persistence)
+	}
+	if settings.Persistence.StickOnSource {
+		payload.StickTable = stickTablePayload(settings.Persistence)
+	}
+	if config.Type == CheckHTTP {
+		payload.AdvCheck = "httpchk"
+		payload.HTTPChkParams = &httpChkParamsModel{
+			Method:  config.HTTPMethod,
+			URI:     config.HTTPPath,
+			Version: "HTTP/1.1",
+			Host:    config.HTTPHost,
+		}
+	}
+	return payload, nil
+}
+
+// mergeBackend overlays template and then the owned fields of desired onto existing. Owned
+// fields unset in desired are cleared so that disabling a feature also clears it in HAProxy.
+// serverTemplate is applied to default_server on top of its owned fields; keys of the previously
+// applied server template that it no longer sets are cleared.
+func mergeBackend(existing backendModel, template, serverTemplate map[string]any, previousServerKeys []string, desired backendModel) (backendModel, error) {
+	merged, err := withFields(existing, template)
+	if err != nil {
+		return backendModel{}, fmt.Errorf("backend template: %w", err)
+	}
+	merged.Name = desired.Name
+	copyFields(&merged, desired, ownedBackendFields)
+
+	var ds defaultServerModel
+	if merged.DefaultServer != nil {
+		ds = *merged.DefaultServer
+	}
+	var dropped []string
+	for _, key := range previousServerKeys {
+		if _, ok := serverTemplate[key]; !ok {
+			dropped = append(dropped, key)
+		}
+	}
+	if ds, err = withoutFields(ds, dropped); err != nil {
+		return backendModel{}, fmt.Errorf("server template: %w", err)
+	}
+	if desired.DefaultServer != nil {
+		copyFields(&ds, *desired.DefaultServer, ownedDefaultServerFields)
+	}
+	if ds, err = withFields(ds, serverTemplate); err != nil {
+		return backendModel{}, fmt.Errorf("server template: %w", err)
+	}
+	if data, _ := json.Marshal(ds); string(data) != "{}" {
+		merged.DefaultServer = &ds
+	} else {
+		merged.DefaultServer = nil
+	}
+	return merged, nil
+}
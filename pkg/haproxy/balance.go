@@ -0,0 +1,158 @@
+package haproxy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BalanceConfig selects the backend load-balancing algorithm.
+type BalanceConfig struct {
+	// Algorithm is written as in haproxy.cfg, e.g. "leastconn", "hdr(Host)", "url_param id" or "hash req.cookie(id)".
+	Algorithm string
+	// HashType is written as in haproxy.cfg, e.g. "consistent" or "consistent sdbm avalanche".
+	HashType string
+}
+
+// PersistenceConfig configures session persistence for the backend.
+type PersistenceConfig struct {
+	// CookieName enables cookie persistence; each server uses its stable name as cookie value.
+	CookieName string
+	// CookieMode is insert, rewrite or prefix.
+	CookieMode string
+	// StickOnSource adds a stick-table keyed on the client source address and a "stick on src" rule.
+	StickOnSource    bool
+	StickTableSize   int
+	StickTableExpire time.Duration
+}
+
+// BackendSettings groups the backend-level settings the controller manages.
+type BackendSettings struct {
+	HealthCheck HealthCheckConfig
+	Balance     BalanceConfig
+	Persistence PersistenceConfig
+	// ProxyProtocol is applied to default_server so every server inherits it.
+	ProxyProtocol ProxyProtocolConfig
+	// Template holds extra backend fields merged under the controller-owned ones; see ParseBackendTemplate.
+	Template map[string]any
+	// ServerTemplate holds server parameters applied to default_server; see ParseServerTemplate.
+	ServerTemplate map[string]any
+}
+
+// Defaults for balance and persistence settings.
+const (
+	DefaultBalanceAlgorithm = "roundrobin"
+	DefaultCookieMode       = "insert"
+	DefaultStickTableSize   = 100000
+	DefaultStickTableExpire = 30 * time.Minute
+)
+
+var simpleAlgorithms = map[string]struct{}{
+	"roundrobin": {}, "static-rr": {}, "leastconn": {}, "first": {}, "source": {}, "uri": {}, "random": {},
+}
+
+// Validate checks the algorithm and hash-type syntax.
+func (b BalanceConfig) Validate() error {
+	if _, err := balancePayload(b.Algorithm); err != nil {
+		return err
+	}
+	if _, err := hashTypePayload(b.HashType); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Validate checks cookie and stick-table settings.
+func (p PersistenceConfig) Validate() error {
+	if p.CookieName != "" {
+		if strings.ContainsAny(p.CookieName, " ;,=\t") {
+			return fmt.Errorf("invalid cookie name %q", p.CookieName)
+		}
+		switch p.CookieMode {
+		case "insert", "rewrite", "prefix":
+		default:
+			return fmt.Errorf("cookie mode must be insert, rewrite or prefix, got %q", p.CookieMode)
+		}
+	}
+	if p.StickOnSource && (p.StickTableSize <= 0 || p.StickTableExpire <= 0) {
+		return fmt.Errorf("stick-table size and expire must be positive")
+	}
+	return nil
+}
+
+// balancePayload converts a haproxy.cfg style algorithm into the Data Plane balance object.
+func balancePayload(algorithm string) (*balanceModel, error) {
+	algorithm = strings.TrimSpace(algorithm)
+	if algorithm == "" {
+		algorithm = DefaultBalanceAlgorithm
+	}
+	if _, ok := simpleAlgorithms[algorithm]; ok {
+		return &balanceModel{Algorithm: algorithm}, nil
+	}
+
+	switch name, arg, _ := strings.Cut(algorithm, " "); {
+	case strings.HasPrefix(algorithm, "hdr(") && strings.HasSuffix(algorithm, ")"):
+		header := strings.TrimSuffix(strings.TrimPrefix(algorithm, "hdr("), ")")
+		if header == "" {
+			return nil, fmt.Errorf("balance hdr() requires a header name")
+		}
+		return &balanceModel{Algorithm: "hdr", HdrName: header}, nil
+	case name == "url_param" && strings.TrimSpace(arg) != "":
+		return &balanceModel{Algorithm: "url_param", URLParam: strings.TrimSpace(arg)}, nil
+	case name == "hash" && strings.TrimSpace(arg) != "":
+		return &balanceModel{Algorithm: "hash", HashExpression: strings.TrimSpace(arg)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported balance algorithm %q", algorithm)
+	}
+}
+
+// hashTypePayload converts "method [function [modifier]]" into the Data Plane hash_type object; empty input yields nil.
+func hashTypePayload(hashType string) (*hashTypeModel, error) {
+	fields := strings.Fields(hashType)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if len(fields) > 3 {
+		return nil, fmt.Errorf("invalid hash-type %q", hashType)
+	}
+
+	payload := &hashTypeModel{}
+	switch fields[0] {
+	case "map-based", "consistent":
+		payload.Method = fields[0]
+	default:
+		return nil, fmt.Errorf("hash-type method must be map-based or consistent, got %q", fields[0])
+	}
+	if len(fields) > 1 {
+		switch fields[1] {
+		case "sdbm", "djb2", "wt6", "crc32", "none":
+			payload.Function = fields[1]
+		default:
+			return nil, fmt.Errorf("unknown hash-type function %q", fields[1])
+		}
+	}
+	if len(fields) > 2 {
+		if fields[2] != "avalanche" {
+			return nil, fmt.Errorf("unknown hash-type modifier %q", fields[2])
+		}
+		payload.Modifier = fields[2]
+	}
+	return payload, nil
+}
+
+func cookiePayload(p PersistenceConfig) *cookieModel {
+	cookie := &cookieModel{Name: p.CookieName, Type: p.CookieMode}
+	if p.CookieMode == "insert" {
+		cookie.Indirect = true
+		cookie.Nocache = true
+	}
+	return cookie
+}
+
+func stickTablePayload(p PersistenceConfig) *stickTableModel {
+	return &stickTableModel{
+		Type:   "ip",
+		Size:   int64Ptr(p.StickTableSize),
+		Expire: int64Ptr(durationMillis(p.StickTableExpire)),
+	}
+}
@@ -0,0 +1,190 @@
+package haproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Capabilities is what the Data Plane API and the HAProxy behind it support, detected by Probe.
+type Capabilities struct {
+	// API is the negotiated dialect and APIBuild the version string from /info, empty when
+	// /info may not be read.
+	API      APIVersion
+	APIBuild string
+	// HAProxy is the HAProxy version from the runtime info, empty when unknown.
+	HAProxy string
+
+	// BulkServers replaces all servers of a backend with one PUT (v3).
+	BulkServers bool
+	// ReloadTracking polls /services/haproxy/reloads after commits that reload HAProxy.
+	ReloadTracking bool
+	// RuntimeAddServer lets the Data Plane API add servers without a reload (HAProxy 2.6+).
+	RuntimeAddServer bool
+	// ProxyV2Options accepts proxy-v2-options on default_server.
+	ProxyV2Options bool
+}
+
+// String is the summary logged at startup.
+func (c Capabilities) String() string {
+	haproxyVersion := c.HAProxy
+	if haproxyVersion == "" {
+		haproxyVersion = "unknown"
+	}
+	apiBuild := c.APIBuild
+	if apiBuild == "" {
+		apiBuild = "unknown"
+	}
+	return fmt.Sprintf("Data Plane API %s (%s), HAProxy %s: bulk servers %s, reload tracking %s, runtime add server %s, proxy-v2-options %s",
+		c.API, apiBuild, haproxyVersion, onOff(c.BulkServers), onOff(c.ReloadTracking), onOff(c.RuntimeAddServer), onOff(c.ProxyV2Options))
+}
+
+// Require checks that the configured features are available.
+func (c Capabilities) Require(p ProxyProtocolConfig) error {
+	if len(p.V2Options) > 0 && !c.ProxyV2Options {
+		return fmt.Errorf("HAPROXY_PROXY_V2_OPTIONS is set but Data Plane API %s does not support proxy-v2-options: upgrade the Data Plane API or remove the option", c.API)
+	}
+	return nil
+}
+
+func onOff(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// CapabilityError is a probe failure with the action that fixes it.
+type CapabilityError struct {
+	Reason string
+	Err    error
+}
+
+func (e *CapabilityError) Error() string {
+	if e.Err == nil {
+		return e.Reason
+	}
+	return fmt.Sprintf("%s: %v", e.Reason, e.Err)
+}
+
+func (e *CapabilityError) Unwrap() error {
+	return e.Err
+}
+
+// Probe detects the capabilities of the Data Plane API and stores them on the client, so
+// later requests skip the features it lacks. Wrong credentials, an unreachable URL or a
+// target that is not a usable Data Plane API return a *CapabilityError.
+func (c *DataPlaneClient) Probe(ctx context.Context) (Capabilities, error) {
+	d, err := c.dialect(ctx)
+	if err != nil {
+		return Capabilities{}, c.probeError("negotiate the API version", err)
+	}
+	caps := Capabilities{API: d.version}
+
+	var info struct {
+		API struct {
+			Version string `json:"version"`
+		} `json:"api"`
+	}
+	err = c.doRequest(ctx, http.MethodGet, d.path("info"), nil, nil, &info)
+	var apiErr *apiStatusError
+	switch {
+	case err == nil:
+		caps.APIBuild = info.API.Version
+	case errors.As(err, &apiErr) && apiErr.statusCode == http.StatusForbidden:
+		// A fixed HAPROXY_DATAPLANE_API_VERSION allows users that may not read /info.
+	default:
+		return Capabilities{}, c.probeError("read /info", err)
+	}
+
+	// Every sync reads the configuration version, so this also checks the credentials.
+	if _, err := c.fetchConfigurationVersion(ctx, d); err != nil {
+		return Capabilities{}, c.probeError("read the configuration version", err)
+	}
+
+	c.probeEndpoints(ctx, d, &caps)
+	c.probeHAProxy(ctx, d, &caps)
+
+	c.noBulkServers.Store(!caps.BulkServers)
+	c.noReloadTracking.Store(!caps.ReloadTracking)
+	// An unknown HAProxy version is not warned about on every sync.
+	c.noRuntimeAddServer.Store(caps.HAProxy != "" && !caps.RuntimeAddServer)
+	return caps, nil
+}
+
+// probeEndpoints reads the API specification; without one the dialect's defaults are assumed.
+func (c *DataPlaneClient) probeEndpoints(ctx context.Context, d dialect, caps *Capabilities) {
+	caps.BulkServers = d.version == APIVersionV3
+	caps.ReloadTracking = true
+	caps.ProxyV2Options = true
+
+	_, data, err := c.doRaw(ctx, http.MethodGet, d.path("specification"), nil, "", nil)
+	if err != nil {
+		log.Printf("reading the Data Plane API specification failed, assuming %s defaults: %v", d.version, err)
+		return
+	}
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(data, &spec); err != nil || len(spec.Paths) == 0 {
+		log.Printf("the Data Plane API specification has no paths, assuming %s defaults", d.version)
+		return
+	}
+	_, bulk := spec.Paths["/services/haproxy/configuration/backends/{parent_name}/servers"][strings.ToLower(http.MethodPut)]
+	caps.BulkServers = d.version == APIVersionV3 && bulk
+	_, caps.ReloadTracking = spec.Paths["/services/haproxy/reloads/{id}"]
+	caps.ProxyV2Options = bytes.Contains(data, []byte(`"proxy-v2-options"`))
+}
+
+// probeHAProxy reads the HAProxy version from the runtime info, an object in v3 and a
+// one-element list in v2.
+func (c *DataPlaneClient) probeHAProxy(ctx context.Context, d dialect, caps *Capabilities) {
+	type processInfo struct {
+		Info struct {
+			Version string `json:"version"`
+		} `json:"info"`
+	}
+	_, data, err := c.doRaw(ctx, http.MethodGet, d.path("services/haproxy/runtime/info"), nil, "", nil)
+	if err != nil {
+		log.Printf("reading the HAProxy version failed: %v", err)
+		return
+	}
+	var one processInfo
+	var list []processInfo
+	switch {
+	case json.Unmarshal(data, &one) == nil:
+	case json.Unmarshal(data, &list) == nil && len(list) > 0:
+		one = list[0]
+	}
+	caps.HAProxy = one.Info.Version
+	caps.RuntimeAddServer = versionAtLeast(caps.HAProxy, 2, 6)
+}
+
+// versionAtLeast compares the major and minor number of versions such as "2.8.5-1ppa1~jammy".
+func versionAtLeast(version string, major, minor int) bool {
+	var gotMajor, gotMinor int
+	if _, err := fmt.Sscanf(version, "%d.%d", &gotMajor, &gotMinor); err != nil {
+		return false
+	}
+	return gotMajor > major || gotMajor == major && gotMinor >= minor
+}
+
+// probeError turns a failed probe request into the action that fixes it.
+func (c *DataPlaneClient) probeError(step string, err error) error {
+	var apiErr *apiStatusError
+	if errors.As(err, &apiErr) {
+		switch apiErr.statusCode {
+		case http.StatusUnauthorized:
+			return &CapabilityError{Reason: fmt.Sprintf("Data Plane API at %s rejected the credentials: check HAPROXY_DATAPLANE_USERNAME/PASSWORD or HAPROXY_DATAPLANE_TOKEN", c.target()), Err: err}
+		case http.StatusForbidden:
+			return &CapabilityError{Reason: fmt.Sprintf("the Data Plane API user may not %s: grant it access to the HAProxy configuration", step), Err: err}
+		}
+		return &CapabilityError{Reason: fmt.Sprintf("Data Plane API at %s cannot %s, check that HAPROXY_DATAPLANE_URL points at the Data Plane API", c.target(), step), Err: err}
+	}
+	return &CapabilityError{Reason: fmt.Sprintf("cannot %s from the Data Plane API at %s, check HAPROXY_DATAPLANE_URL and the TLS settings", step, c.target()), Err: err}
+}
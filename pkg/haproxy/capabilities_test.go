@@ -0,0 +1,133 @@
+package haproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDataPlaneProbe(t *testing.T) {
+	const v3Spec = `{"paths":{
+		"/services/haproxy/configuration/backends/{parent_name}/servers":{"get":{},"put":{}},
+		"/services/haproxy/reloads/{id}":{"get":{}}},
+		"definitions":{"server_params":{"properties":{"proxy-v2-options":{}}}}}`
+
+	testCases := []struct {
+		name   string
+		routes map[string]string
+		want   Capabilities
+	}{
+		{
+			name: "v3",
+			routes: map[string]string{
+				"GET /v3/info":                          `{"api":{"version":"v3.0.1 3e0d2a8b"}}`,
+				"GET /v3/specification":                 v3Spec,
+				"GET /v3/services/haproxy/runtime/info": `{"info":{"version":"3.0.2-1"}}`,
+			},
+			want: Capabilities{API: APIVersionV3, APIBuild: "v3.0.1 3e0d2a8b", HAProxy: "3.0.2-1",
+				BulkServers: true, ReloadTracking: true, RuntimeAddServer: true, ProxyV2Options: true},
+		},
+		{
+			name: "v2 without specification",
+			routes: map[string]string{
+				"GET /v2/info":                          `{"api":{"version":"v2.4.0"}}`,
+				"GET /v2/services/haproxy/runtime/info": `[{"info":{"version":"2.4.22"},"runtimeAPI":"/var/run/haproxy.sock"}]`,
+			},
+			want: Capabilities{API: APIVersionV2, APIBuild: "v2.4.0", HAProxy: "2.4.22", ReloadTracking: true, ProxyV2Options: true},
+		},
+		{
+			name: "v3 without reloads, bulk servers or proxy-v2-options",
+			routes: map[string]string{
+				"GET /v3/info":          `{"api":{"version":"v3.0.0"}}`,
+				"GET /v3/specification": `{"paths":{"/services/haproxy/configuration/backends/{parent_name}/servers":{"get":{}}}}`,
+			},
+			want: Capabilities{API: APIVersionV3, APIBuild: "v3.0.0"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake, srv := newFakeDataPlane(t)
+			for route, body := range tc.routes {
+				fake.respond(route, http.StatusOK, body)
+			}
+			fake.respond("GET /"+string(tc.want.API)+"/services/haproxy/configuration/version", http.StatusOK, `3`)
+
+			c, err := NewDataPlaneClientWithOptions(srv.URL, "be_ingress", DataPlaneOptions{})
+			if err != nil {
+				t.Fatalf("new client: %v", err)
+			}
+			caps, err := c.Probe(context.Background())
+			if err != nil {
+				t.Fatalf("probe: %v", err)
+			}
+			if caps != tc.want {
+				t.Fatalf("got %+v, want %+v", caps, tc.want)
+			}
+			if c.noBulkServers.Load() == caps.BulkServers || c.noReloadTracking.Load() == caps.ReloadTracking {
+				t.Fatalf("capabilities not applied to the client: %+v", caps)
+			}
+		})
+	}
+}
+
+func TestDataPlaneProbeErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		status int
+		want   string
+	}{
+		{name: "wrong credentials", status: http.StatusUnauthorized, want: "rejected the credentials"},
+		{name: "forbidden", status: http.StatusForbidden, want: "may not read the configuration version"},
+		{name: "not a Data Plane API", status: http.StatusInternalServerError, want: "check that HAPROXY_DATAPLANE_URL points at the Data Plane API"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake, srv := newFakeDataPlane(t)
+			fake.respond("GET /v3/info", http.StatusOK, `{"api":{"version":"v3.0.1"}}`)
+			fake.respond("GET /v3/services/haproxy/configuration/version", tc.status, `{"message":"no"}`)
+
+			c, err := NewDataPlaneClientWithOptions(srv.URL, "be_ingress", DataPlaneOptions{APIVersion: APIVersionV3})
+			if err != nil {
+				t.Fatalf("new client: %v", err)
+			}
+			_, err = c.Probe(context.Background())
+			var capErr *CapabilityError
+			if !errors.As(err, &capErr) || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected a capability error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestCapabilitiesRequire(t *testing.T) {
+	p := ProxyProtocolConfig{Version: ProxyV2, V2Options: []string{"ssl"}}
+	if err := (Capabilities{ProxyV2Options: true}).Require(p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := (Capabilities{}).Require(p); err == nil {
+		t.Fatalf("expected proxy-v2-options to be rejected")
+	}
+	if err := (Capabilities{}).Require(ProxyProtocolConfig{Version: ProxyV2}); err != nil {
+		t.Fatalf("unexpected error without options: %v", err)
+	}
+}
+
+func TestSyncErrorIsUnauthorized(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("GET /v3/services/haproxy/configuration/version", http.StatusUnauthorized, `{"message":"invalid credentials"}`)
+
+	c, err := NewDataPlaneClientWithOptions(srv.URL, "be_ingress", DataPlaneOptions{APIVersion: APIVersionV3})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	s := NewSyncer(c)
+	err = s.SyncBackends(context.Background(), []BackendServer{{Name: "a-443", Address: "10.0.0.1", Port: 443}}, BackendSettings{})
+	if err == nil || !IsUnauthorized(err) {
+		t.Fatalf("expected an unauthorized sync error, got %v", err)
+	}
+	if IsUnauthorized(errors.New("status 401")) {
+		t.Fatalf("plain errors are not API responses")
+	}
+}
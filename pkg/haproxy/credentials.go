@@ -0,0 +1,103 @@
+package haproxy
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileValue is a secret read from a file, typically a mounted Secret key, and re-read when
+// the file changes so rotated credentials apply to the next request.
+type fileValue struct {
+	path string
+
+	mu      sync.Mutex
+	value   string
+	modTime time.Time
+}
+
+func newFileValue(path string) (*fileValue, error) {
+	f := &fileValue{path: path}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// get returns the current value; a file that cannot be read keeps the previous value.
+func (f *fileValue) get() string {
+	if err := f.reload(); err != nil {
+		log.Printf("re-reading %s failed, keeping previous value: %v", f.path, err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.value
+}
+
+func (f *fileValue) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if info.ModTime().Equal(f.modTime) {
+		return nil
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	f.value = strings.TrimRight(string(data), "\r\n")
+	f.modTime = info.ModTime()
+	return nil
+}
+
+// loadFileValues opens the credential files that are configured.
+func (c *DataPlaneClient) loadFileValues(opts DataPlaneOptions) error {
+	for _, f := range []struct {
+		path string
+		dst  **fileValue
+		what string
+	}{
+		{opts.UsernameFile, &c.usernameFile, "username"},
+		{opts.PasswordFile, &c.passwordFile, "password"},
+		{opts.TokenFile, &c.tokenFile, "token"},
+	} {
+		if f.path == "" {
+			continue
+		}
+		v, err := newFileValue(f.path)
+		if err != nil {
+			return fmt.Errorf("read %s file: %w", f.what, err)
+		}
+		*f.dst = v
+	}
+	return nil
+}
+
+// authorize sets bearer or basic authentication from the current credentials. File-backed
+// credentials take precedence over inline ones.
+func (c *DataPlaneClient) authorize(req *http.Request) {
+	username, password, token := c.username, c.password, c.token
+	if c.usernameFile != nil {
+		username = c.usernameFile.get()
+	}
+	if c.passwordFile != nil {
+		password = c.passwordFile.get()
+	}
+	if c.tokenFile != nil {
+		token = c.tokenFile.get()
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if username != "" || password != "" {
+		req.SetBasicAuth(username, password)
+	}
+}
@@ -0,0 +1,234 @@
+package haproxy
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Defaults for flap dampening.
+const (
+	DefaultFlapWindow = 5 * time.Minute
+	DefaultFlapStable = 5 * time.Minute
+)
+
+// DampeningConfig configures per-server hysteresis for servers that appear and disappear.
+type DampeningConfig struct {
+	// HoldDown keeps a vanished server in maint for this long before it is removed.
+	HoldDown time.Duration
+	// FlapThreshold holds a server in maint once it vanished more than this many times within FlapWindow; 0 disables.
+	FlapThreshold int
+	FlapWindow    time.Duration
+	// StableFor is how long a held server must go without appearing or vanishing before it is released.
+	StableFor time.Duration
+}
+
+// Enabled reports whether any dampening is configured.
+func (d DampeningConfig) Enabled() bool {
+	return d.HoldDown > 0 || d.FlapThreshold > 0
+}
+
+// Validate checks that durations and thresholds are usable.
+func (d DampeningConfig) Validate() error {
+	if d.HoldDown < 0 {
+		return fmt.Errorf("hold-down must not be negative")
+	}
+	if d.FlapThreshold < 0 {
+		return fmt.Errorf("flap threshold must not be negative")
+	}
+	if d.FlapThreshold > 0 && (d.FlapWindow <= 0 || d.StableFor <= 0) {
+		return fmt.Errorf("flap window and stable period must be positive")
+	}
+	return nil
+}
+
+// DampeningState summarises the servers currently affected by dampening.
+type DampeningState struct {
+	// HoldingDown counts vanished servers kept in maint until their hold-down expires.
+	HoldingDown int
+	// Flapping counts servers held in maint because they flapped.
+	Flapping int
+	// FlapsTotal counts how often servers were detected as flapping.
+	FlapsTotal int64
+}
+
+type serverHistory struct {
+	last        BackendServer
+	present     bool
+	absentSince time.Time
+	lastChange  time.Time
+	vanished    []time.Time
+	flapping    bool
+	removed     bool
+}
+
+// Dampener remembers servers across syncs so that short-lived changes do not reach HAProxy.
+type Dampener struct {
+	config DampeningConfig
+	now    func() time.Time
+
+	mu         sync.Mutex
+	history    map[string]*serverHistory
+	flapsTotal int64
+}
+
+// NewDampener builds a Dampener with the given settings.
+func NewDampener(config DampeningConfig) *Dampener {
+	return &Dampener{config: config, now: time.Now, history: make(map[string]*serverHistory)}
+}
+
+// Apply returns the servers to push: the desired ones, with flapping servers forced into maint,
+// plus vanished servers still within their hold-down, also in maint.
+func (d *Dampener) Apply(servers []BackendServer) []BackendServer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	out := make([]BackendServer, 0, len(servers))
+	desired := make(map[string]struct{}, len(servers))
+
+	for _, srv := range servers {
+		desired[srv.Name] = struct{}{}
+		h, ok := d.history[srv.Name]
+		if !ok {
+			h = &serverHistory{lastChange: now}
+			d.history[srv.Name] = h
+		} else if !h.present {
+			log.Printf("server %s reappeared after %s", srv.Name, now.Sub(h.absentSince).Round(time.Second))
+			h.lastChange = now
+		}
+		h.present = true
+		h.removed = false
+		h.last = srv
+
+		d.release(srv.Name, h, now)
+		if h.flapping {
+			srv.State = ServerStateMaint
+		}
+		out = append(out, srv)
+	}
+
+	var kept []BackendServer
+	for name, h := range d.history {
+		if _, ok := desired[name]; ok {
+			continue
+		}
+		if h.present {
+			h.present = false
+			h.absentSince = now
+			h.lastChange = now
+			d.recordVanish(name, h, now)
+			if d.config.HoldDown > 0 || h.flapping {
+				log.Printf("server %s vanished, keeping it in maint", name)
+			}
+		}
+
+		d.release(name, h, now)
+		if !h.flapping && now.Sub(h.absentSince) >= d.config.HoldDown {
+			// The history stays until forget so a server that comes back soon is still seen as flapping.
+			if !h.removed && d.config.HoldDown > 0 {
+				log.Printf("server %s removed after hold-down of %s", name, d.config.HoldDown)
+			}
+			h.removed = true
+			continue
+		}
+
+		srv := h.last
+		srv.State = ServerStateMaint
+		kept = append(kept, srv)
+	}
+	d.forget(now)
+
+	sort.Slice(kept, func(i, j int) bool { return kept[i].Name < kept[j].Name })
+	return append(out, kept...)
+}
+
+// State returns counts for logs and metrics.
+func (d *Dampener) State() DampeningState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state := DampeningState{FlapsTotal: d.flapsTotal}
+	now := d.now()
+	for _, h := range d.history {
+		switch {
+		case h.flapping:
+			state.Flapping++
+		case !h.present && now.Sub(h.absentSince) < d.config.HoldDown:
+			state.HoldingDown++
+		}
+	}
+	return state
+}
+
+// NextChange returns how long until the earliest hold-down or flap-stable period ends, when
+// a sync changes the servers without any change in Kubernetes.
+func (d *Dampener) NextChange() (time.Duration, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	var next time.Time
+	for _, h := range d.history {
+		var due time.Time
+		switch {
+		case h.flapping:
+			due = h.lastChange.Add(d.config.StableFor)
+		case !h.present && !h.removed && d.config.HoldDown > 0:
+			due = h.absentSince.Add(d.config.HoldDown)
+		default:
+			continue
+		}
+		if next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	if next.IsZero() {
+		return 0, false
+	}
+	return max(next.Sub(now), 0), true
+}
+
+func (d *Dampener) recordVanish(name string, h *serverHistory, now time.Time) {
+	if d.config.FlapThreshold <= 0 {
+		return
+	}
+	h.vanished = append(h.vanished, now)
+	h.vanished = pruneBefore(h.vanished, now.Add(-d.config.FlapWindow))
+	if !h.flapping && len(h.vanished) > d.config.FlapThreshold {
+		h.flapping = true
+		d.flapsTotal++
+		log.Printf("server %s is flapping (%d removals within %s), holding it in maint", name, len(h.vanished), d.config.FlapWindow)
+	}
+}
+
+func (d *Dampener) release(name string, h *serverHistory, now time.Time) {
+	if h.flapping && now.Sub(h.lastChange) >= d.config.StableFor {
+		h.flapping = false
+		h.vanished = nil
+		log.Printf("server %s stable for %s, releasing it from maint", name, d.config.StableFor)
+	}
+}
+
+// forget drops absent servers whose vanish history has aged out of the flap window.
+func (d *Dampener) forget(now time.Time) {
+	for name, h := range d.history {
+		if h.present || h.flapping {
+			continue
+		}
+		h.vanished = pruneBefore(h.vanished, now.Add(-d.config.FlapWindow))
+		if len(h.vanished) == 0 && now.Sub(h.absentSince) >= d.config.HoldDown {
+			delete(d.history, name)
+		}
+	}
+}
+
+func pruneBefore(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
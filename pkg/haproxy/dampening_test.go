@@ -0,0 +1,103 @@
+package haproxy
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func serverStates(servers []BackendServer) map[string]ServerState {
+	states := make(map[string]ServerState, len(servers))
+	for _, s := range servers {
+		states[s.Name] = s.State
+	}
+	return states
+}
+
+func TestDampenerHoldDown(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	d := NewDampener(DampeningConfig{HoldDown: 30 * time.Second})
+	d.now = clock.now
+
+	a := BackendServer{Name: "a-443", Weight: 10}
+	b := BackendServer{Name: "b-443", Weight: 10}
+	d.Apply([]BackendServer{a, b})
+
+	clock.advance(time.Second)
+	got := serverStates(d.Apply([]BackendServer{a}))
+	if len(got) != 2 || got["b-443"] != ServerStateMaint || got["a-443"] != "" {
+		t.Fatalf("expected vanished server kept in maint, got %v", got)
+	}
+	if state := d.State(); state.HoldingDown != 1 {
+		t.Fatalf("expected one server holding down, got %+v", state)
+	}
+
+	clock.advance(30 * time.Second)
+	if got := serverStates(d.Apply([]BackendServer{a})); len(got) != 1 {
+		t.Fatalf("expected server removed after hold-down, got %v", got)
+	}
+}
+
+func TestDampenerHoldsFlappingServers(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	d := NewDampener(DampeningConfig{FlapThreshold: 2, FlapWindow: time.Minute, StableFor: 2 * time.Minute})
+	d.now = clock.now
+
+	srv := BackendServer{Name: "a-443", Weight: 10}
+	for i := 0; i < 3; i++ {
+		d.Apply([]BackendServer{srv})
+		clock.advance(5 * time.Second)
+		d.Apply(nil)
+		clock.advance(5 * time.Second)
+	}
+
+	state := d.State()
+	if state.Flapping != 1 || state.FlapsTotal != 1 {
+		t.Fatalf("expected server detected as flapping, got %+v", state)
+	}
+	if got := serverStates(d.Apply(nil)); got["a-443"] != ServerStateMaint {
+		t.Fatalf("expected absent flapping server kept in maint, got %v", got)
+	}
+	if got := serverStates(d.Apply([]BackendServer{srv})); got["a-443"] != ServerStateMaint {
+		t.Fatalf("expected returning flapping server held in maint, got %v", got)
+	}
+
+	clock.advance(2 * time.Minute)
+	if got := serverStates(d.Apply([]BackendServer{srv})); got["a-443"] != "" {
+		t.Fatalf("expected server released after being stable, got %v", got)
+	}
+	if state := d.State(); state.Flapping != 0 {
+		t.Fatalf("expected no flapping servers, got %+v", state)
+	}
+}
+
+func TestDampenerNextChangeReleasesFlappingServers(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	d := NewDampener(DampeningConfig{FlapThreshold: 1, FlapWindow: time.Minute, StableFor: 2 * time.Minute})
+	d.now = clock.now
+
+	srv := BackendServer{Name: "a-443", Weight: 10}
+	for i := 0; i < 2; i++ {
+		d.Apply([]BackendServer{srv})
+		clock.advance(5 * time.Second)
+		d.Apply(nil)
+		clock.advance(5 * time.Second)
+	}
+	d.Apply([]BackendServer{srv})
+
+	after, ok := d.NextChange()
+	if !ok || after != 2*time.Minute {
+		t.Fatalf("expected a change when the stable period ends, got %s, %t", after, ok)
+	}
+	clock.advance(after)
+	if got := serverStates(d.Apply([]BackendServer{srv})); got["a-443"] != "" {
+		t.Fatalf("expected the server released, got %v", got)
+	}
+	if _, ok := d.NextChange(); ok {
+		t.Fatalf("did not expect another change")
+	}
+}
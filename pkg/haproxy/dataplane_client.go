@@ -7,60 +7,177 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const apiVersionPath = "/v3"
-
 // Client defines interactions with the HAProxy Data Plane API.
 type Client interface {
 	BeginTransaction(ctx context.Context) (string, error)
 	CommitTransaction(ctx context.Context, transactionID string) error
 	AbortTransaction(ctx context.Context, transactionID string) error
 	UpdateBackendsInTransaction(ctx context.Context, transactionID string, backends []BackendServer) error
-	UpdateHealthChecksInTransaction(ctx context.Context, transactionID string, config HealthCheckConfig) error
+	UpdateBackendSettingsInTransaction(ctx context.Context, transactionID string, settings BackendSettings) error
+	EnsureResolversInTransaction(ctx context.Context, transactionID string, config ResolversConfig) error
 }
 
 // DataPlaneClient is a minimal HTTP-based implementation of the Client interface.
 type DataPlaneClient struct {
-	baseURL     *url.URL
+	baseURL *url.URL
+	// socketPath is set for a unix:// URL; baseURL is then a placeholder.
+	socketPath  string
 	backendName string
 	client      *http.Client
 	username    string
 	password    string
 	token       string
+
+	usernameFile *fileValue
+	passwordFile *fileValue
+	tokenFile    *fileValue
+
+	apiVersion APIVersion
+	dialectMu  sync.Mutex
+	negotiated APIVersion
+
+	// noBulkServers is set once the API rejected a bulk server replacement.
+	noBulkServers atomic.Bool
+	// noReloadTracking is set by Probe when the API has no reloads endpoint to poll.
+	noReloadTracking atomic.Bool
+	// noRuntimeAddServer is set by Probe when HAProxy must reload to add servers.
+	noRuntimeAddServer atomic.Bool
+
+	// serverTemplateKeys are the default_server keys the committed server template set;
+	// pendingServerKeys holds them per open transaction until it is committed.
+	templateMu         sync.Mutex
+	serverTemplateKeys []string
+	pendingServerKeys  map[string][]string
+
+	// owned records the servers the controller created, the only ones it deletes.
+	owned serverOwnership
+
+	reloadTimeout      time.Duration
+	reloadPollInterval time.Duration
+	reloadFailures     atomic.Int64
 }
 
 // NewDataPlaneClient creates a new DataPlaneClient using the given base URL and backend name.
+// It speaks Data Plane API v3; use NewDataPlaneClientWithOptions to select or negotiate the version.
 func NewDataPlaneClient(baseURL, username, password, token, backendName string) *DataPlaneClient {
 	parsed, _ := url.Parse(baseURL)
-	return &DataPlaneClient{
+	c := &DataPlaneClient{
 		baseURL:     parsed,
 		backendName: backendName,
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
-		username: username,
-		password: password,
-		token:    token,
+		username:           username,
+		password:           password,
+		token:              token,
+		apiVersion:         APIVersionV3,
+		reloadTimeout:      DefaultReloadTimeout,
+		reloadPollInterval: defaultReloadPollInterval,
+	}
+	if parsed != nil && parsed.Scheme == "unix" {
+		c.useSocket(parsed)
+	}
+	return c
+}
+
+// useSocket sends every request over the unix socket at u ("unix:///path/to/socket"). The
+// requests keep their paths under a placeholder HTTP host.
+func (c *DataPlaneClient) useSocket(u *url.URL) {
+	c.socketPath = u.Path
+	if c.socketPath == "" {
+		c.socketPath = u.Opaque
+	}
+	c.baseURL = &url.URL{Scheme: "http", Host: "dataplaneapi"}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "unix", c.socketPath)
+	}
+	c.client.Transport = transport
+}
+
+// target names the API in errors: the socket or the URL without credentials.
+func (c *DataPlaneClient) target() string {
+	if c.socketPath != "" {
+		return "unix://" + c.socketPath
+	}
+	return c.baseURL.Redacted()
+}
+
+// DataPlaneOptions holds the connection settings for NewDataPlaneClientWithOptions.
+type DataPlaneOptions struct {
+	Username string
+	Password string
+	Token    string
+	// UsernameFile, PasswordFile and TokenFile are re-read when they change and win over the inline values.
+	UsernameFile string
+	PasswordFile string
+	TokenFile    string
+	TLS          TLSConfig
+	// APIVersion selects the API dialect; empty or auto probes the server on first use.
+	APIVersion APIVersion
+	// ReloadTimeout bounds the wait for the HAProxy reload after a commit; 0 means DefaultReloadTimeout.
+	ReloadTimeout time.Duration
+}
+
+// NewDataPlaneClientWithOptions creates a DataPlaneClient with credentials and TLS settings.
+// Credential and TLS files are loaded once here, so a missing or invalid file fails fast.
+func NewDataPlaneClientWithOptions(baseURL, backendName string, opts DataPlaneOptions) (*DataPlaneClient, error) {
+	c := NewDataPlaneClient(baseURL, opts.Username, opts.Password, opts.Token, backendName)
+	c.apiVersion = opts.APIVersion
+	if c.apiVersion == "" {
+		c.apiVersion = APIVersionAuto
+	}
+	if opts.ReloadTimeout > 0 {
+		c.reloadTimeout = opts.ReloadTimeout
+	}
+	if err := c.loadFileValues(opts); err != nil {
+		return nil, fmt.Errorf("data plane credentials: %w", err)
+	}
+	if opts.TLS.Enabled() {
+		if c.socketPath != "" {
+			return nil, fmt.Errorf("data plane TLS: not used over the unix socket %s", c.socketPath)
+		}
+		reloading, err := newReloadingTLS(opts.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("data plane TLS: %w", err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = reloading.clientConfig(c.baseURL.Hostname())
+		c.client.Transport = transport
 	}
+	return c, nil
 }
 
 // BeginTransaction starts a new transaction in HAProxy Data Plane API.
 func (c *DataPlaneClient) BeginTransaction(ctx context.Context) (string, error) {
-	version, err := c.fetchConfigurationVersion(ctx)
+	d, err := c.dialect(ctx)
+	if err != nil {
+		return "", err
+	}
+	version, err := c.fetchConfigurationVersion(ctx, d)
 	if err != nil {
 		return "", fmt.Errorf("fetch version: %w", err)
 	}
 
-	var resp transactionResponse
+	var resp transactionModel
 	values := url.Values{}
 	values.Set("version", fmt.Sprintf("%d", version))
 
-	if err := c.doRequest(ctx, http.MethodPost, apiVersionPath+"/services/haproxy/transactions", values, nil, &resp); err != nil {
+	if err := c.doRequest(ctx, http.MethodPost, d.path("services/haproxy/transactions"), values, nil, &resp); err != nil {
 		return "", fmt.Errorf("begin transaction: %w", err)
 	}
 	if resp.ID == "" {
@@ -69,13 +186,27 @@ func (c *DataPlaneClient) BeginTransaction(ctx context.Context) (string, error)
 	return resp.ID, nil
 }
 
-// CommitTransaction finalizes a transaction.
+// CommitTransaction finalizes a transaction. When the commit triggers an HAProxy reload, it
+// waits for the reload and returns a *ReloadError if HAProxy rejected the new configuration.
 func (c *DataPlaneClient) CommitTransaction(ctx context.Context, transactionID string) error {
 	if transactionID == "" {
 		return fmt.Errorf("commit transaction: empty transaction id")
 	}
-	path := fmt.Sprintf(apiVersionPath+"/services/haproxy/transactions/%s", transactionID)
-	return c.doRequest(ctx, http.MethodPut, path, nil, nil, nil)
+	d, err := c.dialect(ctx)
+	if err != nil {
+		return err
+	}
+	header, err := c.do(ctx, http.MethodPut, d.path("services/haproxy/transactions", transactionID), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	c.settleServerKeys(transactionID, true)
+	c.owned.settle(transactionID, true)
+	// A 200 without Reload-ID means the change was applied through the runtime API.
+	if id := header.Get("Reload-ID"); id != "" {
+		return c.waitForReload(ctx, d, id)
+	}
+	return nil
 }
 
 // AbortTransaction rolls back a transaction.
@@ -83,120 +214,446 @@ func (c *DataPlaneClient) AbortTransaction(ctx context.Context, transactionID st
 	if transactionID == "" {
 		return fmt.Errorf("abort transaction: empty transaction id")
 	}
-	path := fmt.Sprintf(apiVersionPath+"/services/haproxy/transactions/%s", transactionID)
-	return c.doRequest(ctx, http.MethodDelete, path, nil, nil, nil)
+	c.settleServerKeys(transactionID, false)
+	c.owned.settle(transactionID, false)
+	d, err := c.dialect(ctx)
+	if err != nil {
+		return err
+	}
+	return c.doRequest(ctx, http.MethodDelete, d.path("services/haproxy/transactions", transactionID), nil, nil, nil)
 }
 
-// UpdateBackendsInTransaction updates backend servers within a transaction.
+// UpdateBackendsInTransaction makes the backend's servers match backends within a transaction.
+// Servers the controller created and no longer wants are deleted; other servers in the backend,
+// such as hand-added backup or sorry servers, are kept (see OwnServers). On v3 the list is
+// replaced with one request; APIs without bulk replacement get one request per server.
 func (c *DataPlaneClient) UpdateBackendsInTransaction(ctx context.Context, transactionID string, backends []BackendServer) error {
+	d, err := c.dialect(ctx)
+	if err != nil {
+		return err
+	}
+	servers := d.servers(c.backendName)
+	values := servers.values(transactionID)
+
+	var existing []serverModel
+	err = c.getConfig(ctx, d, servers.path, values, &existing)
+	var apiErr *apiStatusError
+	backendExists := err == nil
+	if err != nil && !(errors.As(err, &apiErr) && apiErr.statusCode == http.StatusNotFound) {
+		return fmt.Errorf("list servers: %w", err)
+	}
+
+	desired := make(map[string]struct{}, len(backends))
 	for _, b := range backends {
-		payload := serverPayload{
-			Name:    b.Name,
-			Address: b.Address,
-			Port:    b.Port,
-			Weight:  b.Weight,
-			Check:   checkState(b.Check),
+		desired[b.Name] = struct{}{}
+	}
+	if c.noRuntimeAddServer.Load() {
+		c.warnAddedServers(backends, existing)
+	}
+	var kept, stale []serverModel
+	var added, deleted []string
+	for _, srv := range existing {
+		if _, ok := desired[srv.Name]; ok {
+			continue
 		}
-		values := url.Values{}
-		values.Set("transaction_id", transactionID)
-		resourcePath := path.Join(apiVersionPath, "services/haproxy/configuration/backends", c.backendName, "servers", b.Name)
-		if err := c.doRequest(ctx, http.MethodPut, resourcePath, values, payload, nil); err != nil {
-			var apiErr *apiStatusError
-			if errors.As(err, &apiErr) && apiErr.statusCode == http.StatusNotFound {
-				createPath := path.Join(apiVersionPath, "services/haproxy/configuration/backends", c.backendName, "servers")
-				if err := c.doRequest(ctx, http.MethodPost, createPath, values, payload, nil); err != nil {
-					return fmt.Errorf("create server %s: %w", b.Name, err)
-				}
-				continue
+		if c.owned.owns(srv.Name) {
+			stale = append(stale, srv)
+			deleted = append(deleted, srv.Name)
+		} else {
+			kept = append(kept, srv)
+		}
+	}
+	for _, b := range backends {
+		added = append(added, b.Name)
+	}
+	c.owned.stage(transactionID, added, deleted)
+
+	if d.version == APIVersionV3 && !c.noBulkServers.Load() {
+		if done, err := c.replaceServers(ctx, servers, values, backends, kept, backendExists); done || err != nil {
+			return err
+		}
+	}
+
+	for _, b := range backends {
+		body, err := serverBody(b)
+		if err != nil {
+			return fmt.Errorf("server %s: %w", b.Name, err)
+		}
+		if err := c.upsert(ctx, servers.path, b.Name, values, d.encodeServer(body)); err != nil {
+			return fmt.Errorf("server %s: %w", b.Name, err)
+		}
+	}
+
+	for _, srv := range stale {
+		if err := c.doRequest(ctx, http.MethodDelete, servers.item(srv.Name), values, nil, nil); err != nil {
+			return fmt.Errorf("delete server %s: %w", srv.Name, err)
+		}
+	}
+	return nil
+}
+
+// warnAddedServers logs the servers a commit adds when HAProxy cannot add them at runtime, so
+// the reloads they cause can be told apart from configuration changes.
+func (c *DataPlaneClient) warnAddedServers(backends []BackendServer, existing []serverModel) {
+	present := make(map[string]struct{}, len(existing))
+	for _, srv := range existing {
+		present[srv.Name] = struct{}{}
+	}
+	var added []string
+	for _, b := range backends {
+		if _, ok := present[b.Name]; !ok {
+			added = append(added, b.Name)
+		}
+	}
+	if len(added) > 0 {
+		log.Printf("adding servers %s reloads HAProxy, which cannot add servers at runtime before 2.6", strings.Join(added, ", "))
+	}
+}
+
+// settleServerKeys makes the server template keys written in a transaction the committed ones,
+// or forgets them when it was aborted.
+func (c *DataPlaneClient) settleServerKeys(transactionID string, committed bool) {
+	c.templateMu.Lock()
+	defer c.templateMu.Unlock()
+	keys, ok := c.pendingServerKeys[transactionID]
+	delete(c.pendingServerKeys, transactionID)
+	if ok && committed {
+		c.serverTemplateKeys = keys
+	}
+}
+
+// OwnServers marks names as servers the controller created, which it deletes once they are
+// no longer desired. Servers written in a committed transaction are owned automatically.
+func (c *DataPlaneClient) OwnServers(names []string) {
+	c.owned.own(names)
+}
+
+// replaceServers PUTs the desired servers plus the kept ones to the servers collection. It
+// returns false without an error when the API has no bulk replacement, so the caller falls
+// back to per-server calls. backendExists tells a 404 for a missing backend from an API that
+// routes only GET and POST on the collection.
+func (c *DataPlaneClient) replaceServers(ctx context.Context, servers collection, values url.Values, backends []BackendServer, kept []serverModel, backendExists bool) (bool, error) {
+	bodies := make([]serverModel, 0, len(backends)+len(kept))
+	for _, b := range backends {
+		body, err := serverBody(b)
+		if err != nil {
+			return false, fmt.Errorf("server %s: %w", b.Name, err)
+		}
+		bodies = append(bodies, body)
+	}
+	bodies = append(bodies, kept...)
+	err := c.doRequest(ctx, http.MethodPut, servers.path, values, bodies, nil)
+	var apiErr *apiStatusError
+	if errors.As(err, &apiErr) {
+		switch apiErr.statusCode {
+		case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+			log.Printf("Data Plane API does not support replacing all servers, updating them one by one")
+			c.noBulkServers.Store(true)
+			return false, nil
+		case http.StatusNotFound:
+			if !backendExists {
+				// The backend is missing; the per-server path reports it.
+				return false, nil
 			}
-			return fmt.Errorf("update server %s: %w", b.Name, err)
+			log.Printf("Data Plane API does not support replacing all servers, updating them one by one")
+			c.noBulkServers.Store(true)
+			return false, nil
+		}
+	}
+	if err != nil {
+		return false, fmt.Errorf("replace servers: %w", err)
+	}
+	return true, nil
+}
+
+// EnsureResolversInTransaction creates or updates the resolvers section and replaces its nameservers.
+func (c *DataPlaneClient) EnsureResolversInTransaction(ctx context.Context, transactionID string, config ResolversConfig) error {
+	d, err := c.dialect(ctx)
+	if err != nil {
+		return err
+	}
+	values := url.Values{}
+	values.Set("transaction_id", transactionID)
+
+	sectionsPath := d.path("services/haproxy/configuration/resolvers")
+	section := resolverModel{
+		Name:                config.Name,
+		AcceptedPayloadSize: int64Ptr(8192),
+		HoldValid:           int64Ptr(config.HoldValidSeconds * 1000),
+	}
+	if err := c.upsert(ctx, sectionsPath, config.Name, values, section); err != nil {
+		return fmt.Errorf("resolvers %s: %w", config.Name, err)
+	}
+
+	nameservers := d.nameservers(config.Name)
+	nsValues := nameservers.values(transactionID)
+	var existing []nameserverModel
+	if err := c.getConfig(ctx, d, nameservers.path, nsValues, &existing); err != nil {
+		return fmt.Errorf("list nameservers: %w", err)
+	}
+
+	desired := make(map[string]struct{}, len(config.Nameservers))
+	for i, ns := range config.Nameservers {
+		host, port, err := splitNameserver(ns)
+		if err != nil {
+			return err
+		}
+		payload := nameserverModel{Name: fmt.Sprintf("ns%d", i+1), Address: host, Port: int64Ptr(port)}
+		desired[payload.Name] = struct{}{}
+		if err := c.upsert(ctx, nameservers.path, payload.Name, nsValues, payload); err != nil {
+			return fmt.Errorf("nameserver %s: %w", payload.Name, err)
+		}
+	}
+
+	for _, ns := range existing {
+		if _, ok := desired[ns.Name]; ok {
+			continue
+		}
+		if err := c.doRequest(ctx, http.MethodDelete, nameservers.item(ns.Name), nsValues, nil, nil); err != nil {
+			return fmt.Errorf("delete nameserver %s: %w", ns.Name, err)
 		}
 	}
 	return nil
 }
 
-// UpdateHealthChecksInTransaction updates health check configuration within a transaction.
-func (c *DataPlaneClient) UpdateHealthChecksInTransaction(ctx context.Context, transactionID string, config HealthCheckConfig) error {
-	backendPath := fmt.Sprintf(apiVersionPath+"/services/haproxy/configuration/backends/%s", c.backendName)
-	payload := map[string]any{
-		"name":          c.backendName,
-		"adv_check":     "tcp-check",
-		"balance":       map[string]any{"algorithm": "roundrobin"},
-		"check_timeout": config.IntervalSeconds * 1000,
-	}
-	if config.SendProxyV2 {
-		payload["default_server"] = map[string]any{
-			"send-proxy-v2": "enabled",
-			"check":         "enabled",
-			"inter":         config.IntervalSeconds * 1000,
-			"rise":          config.RiseCount,
-			"fall":          config.FallCount,
+// upsert replaces collectionPath/name with payload, creating it in the collection when it does not exist yet.
+func (c *DataPlaneClient) upsert(ctx context.Context, collectionPath, name string, values url.Values, payload any) error {
+	err := c.doRequest(ctx, http.MethodPut, path.Join(collectionPath, name), values, payload, nil)
+	var apiErr *apiStatusError
+	if errors.As(err, &apiErr) && apiErr.statusCode == http.StatusNotFound {
+		if err := c.doRequest(ctx, http.MethodPost, collectionPath, values, payload, nil); err != nil {
+			return fmt.Errorf("create: %w", err)
 		}
+		return nil
 	}
+	if err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+	return nil
+}
+
+// UpdateBackendSettingsInTransaction updates health check, balance and persistence settings within a transaction.
+// The current backend is read first and only controller-owned fields are replaced, so settings
+// configured by hand (timeouts, mode, options) survive every reconcile.
+func (c *DataPlaneClient) UpdateBackendSettingsInTransaction(ctx context.Context, transactionID string, settings BackendSettings) error {
+	config := settings.HealthCheck
+	d, err := c.dialect(ctx)
+	if err != nil {
+		return err
+	}
+	collectionPath := d.path("services/haproxy/configuration/backends")
+	backendPath := path.Join(collectionPath, c.backendName)
+	desired, err := desiredBackend(c.backendName, settings)
+	if err != nil {
+		return err
+	}
+
 	values := url.Values{}
 	values.Set("transaction_id", transactionID)
-	return c.doRequest(ctx, http.MethodPut, backendPath, values, payload, nil)
+
+	var existing backendModel
+	err = c.getConfig(ctx, d, backendPath, values, &existing)
+	var apiErr *apiStatusError
+	notFound := errors.As(err, &apiErr) && apiErr.statusCode == http.StatusNotFound
+	if err != nil && !notFound {
+		return fmt.Errorf("get backend: %w", err)
+	}
+	existing = d.decodeBackend(existing)
+	c.templateMu.Lock()
+	previousKeys := c.serverTemplateKeys
+	c.templateMu.Unlock()
+	backend, err := mergeBackend(existing, settings.Template, settings.ServerTemplate, previousKeys, desired)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(settings.ServerTemplate))
+	for key := range settings.ServerTemplate {
+		keys = append(keys, key)
+	}
+	c.templateMu.Lock()
+	if c.pendingServerKeys == nil {
+		c.pendingServerKeys = make(map[string][]string)
+	}
+	c.pendingServerKeys[transactionID] = keys
+	c.templateMu.Unlock()
+	backend = d.encodeBackend(backend)
+	if notFound {
+		if err := c.doRequest(ctx, http.MethodPost, collectionPath, values, backend, nil); err != nil {
+			return fmt.Errorf("create backend: %w", err)
+		}
+	} else if err := c.doRequest(ctx, http.MethodPut, backendPath, values, backend, nil); err != nil {
+		return err
+	}
+
+	// The stick rule refers to the backend's own table, so it is owned together with stick_table.
+	stickRules := []any{}
+	if settings.Persistence.StickOnSource {
+		stickRules = append(stickRules, stickRuleModel{Type: "on", Pattern: "src"})
+	}
+	if err := c.replaceList(ctx, d, d.stickRules(c.backendName), transactionID, stickRules); err != nil {
+		return fmt.Errorf("replace stick rules: %w", err)
+	}
+
+	// TCP checks replace the list with an empty one, which removes the expect rule left
+	// behind by a previous http check.
+	rules := []any{}
+	if config.Type == CheckHTTP {
+		match, pattern, err := expectStatusRule(config.HTTPExpectStatus)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, httpCheckModel{Type: "expect", Match: match, Pattern: pattern})
+	}
+	if err := c.replaceList(ctx, d, d.httpChecks(c.backendName), transactionID, rules); err != nil {
+		return fmt.Errorf("replace http checks: %w", err)
+	}
+	return nil
 }
 
-type transactionResponse struct {
-	ID string `json:"id"`
+func defaultServerPayload(settings BackendSettings) defaultServerModel {
+	config := settings.HealthCheck
+	var ds defaultServerModel
+	ds.Check = enabled
+	ds.Inter = int64Ptr(durationMillis(config.Interval))
+	ds.Rise = int64Ptr(config.RiseCount)
+	ds.Fall = int64Ptr(config.FallCount)
+	if config.FastInterval > 0 {
+		ds.Fastinter = int64Ptr(durationMillis(config.FastInterval))
+	}
+	if config.DownInterval > 0 {
+		ds.Downinter = int64Ptr(durationMillis(config.DownInterval))
+	}
+	if config.Port > 0 {
+		ds.HealthCheckPort = int64Ptr(config.Port)
+	}
+	if config.SSL {
+		ds.CheckSsl = enabled
+	}
+	applyProxyProtocol(&ds.serverParams, settings.ProxyProtocol)
+	return ds
 }
 
-type serverPayload struct {
-	Name    string `json:"name"`
-	Address string `json:"address"`
-	Port    int32  `json:"port"`
-	Weight  int    `json:"weight,omitempty"`
-	Check   string `json:"check,omitempty"`
+// serverBody applies the server template options under the owned server fields.
+func serverBody(b BackendServer) (serverModel, error) {
+	body, err := withFields(serverModel{}, b.Options)
+	if err != nil {
+		return serverModel{}, fmt.Errorf("server template: %w", err)
+	}
+	owned := newServerModel(b)
+	copyFields(&body, owned, ownedServerFields)
+	if owned.Maxconn != nil {
+		body.Maxconn = owned.Maxconn
+	}
+	return body, nil
 }
 
-func (c *DataPlaneClient) doRequest(ctx context.Context, method, p string, query url.Values, body any, out any) error {
-	u := *c.baseURL
-	u.Path = path.Join(c.baseURL.Path, p)
-	if query != nil {
-		u.RawQuery = query.Encode()
+func newServerModel(b BackendServer) serverModel {
+	var s serverModel
+	s.Name = b.Name
+	s.Address = b.Address
+	s.Port = int64Ptr(b.Port)
+	s.Weight = int64Ptr(b.Weight)
+	s.Check = checkState(b.Check)
+	s.Cookie = b.Cookie
+	if b.MaxConn > 0 {
+		s.Maxconn = int64Ptr(b.MaxConn)
+	}
+	if b.FQDN != "" {
+		s.Address = b.FQDN
+		s.Resolvers = b.Resolvers
+		s.ResolvePrefer = b.ResolvePrefer
+		s.InitAddr = b.InitAddr
+	}
+	switch b.State {
+	case ServerStateMaint:
+		s.Maintenance = enabled
+	case ServerStateDrain:
+		// A zero weight keeps established sessions but sends no new traffic, like runtime drain.
+		s.Weight = int64Ptr(0)
 	}
+	if b.Backup {
+		s.Backup = enabled
+	}
+	if b.Agent != nil {
+		s.AgentCheck = enabled
+		s.AgentAddr = b.Agent.Addr
+		s.AgentPort = int64Ptr(b.Agent.Port)
+		// The escaped newline is expanded by HAProxy's configuration parser and terminates the query.
+		s.AgentSend = b.Name + `\n`
+		s.AgentInter = int64Ptr(durationMillis(b.Agent.Interval))
+	}
+	return s
+}
 
-	var buf io.ReadWriter
+func (c *DataPlaneClient) doRequest(ctx context.Context, method, p string, query url.Values, body any, out any) error {
+	_, err := c.do(ctx, method, p, query, body, out)
+	return err
+}
+
+// do is doRequest that also returns the response headers.
+func (c *DataPlaneClient) do(ctx context.Context, method, p string, query url.Values, body any, out any) (http.Header, error) {
+	var data []byte
+	contentType := ""
 	if body != nil {
-		buf = &bytes.Buffer{}
+		buf := &bytes.Buffer{}
 		if err := json.NewEncoder(buf).Encode(body); err != nil {
-			return fmt.Errorf("encode body: %w", err)
+			return nil, fmt.Errorf("encode body: %w", err)
 		}
+		data, contentType = buf.Bytes(), "application/json"
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, u.String(), buf)
+	header, resp, err := c.doRaw(ctx, method, p, query, contentType, data)
 	if err != nil {
-		return fmt.Errorf("build request: %w", err)
+		return nil, err
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(bytes.NewReader(resp)).Decode(out); err != nil {
+			return nil, fmt.Errorf("decode response: %w", err)
+		}
 	}
+
+	return header, nil
+}
+
+// doRaw sends body as is and returns the unparsed response; do builds on it for JSON.
+func (c *DataPlaneClient) doRaw(ctx context.Context, method, p string, query url.Values, contentType string, body []byte) (http.Header, []byte, error) {
+	u := *c.baseURL
+	u.Path = path.Join(c.baseURL.Path, p)
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+
+	var reader io.Reader
 	if body != nil {
-		req.Header.Set("Content-Type", "application/json")
+		reader = bytes.NewReader(body)
 	}
 
-	if c.token != "" {
-		req.Header.Set("Authorization", "Bearer "+c.token)
-	} else if c.username != "" || c.password != "" {
-		req.SetBasicAuth(c.username, c.password)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("build request: %w", err)
 	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	c.authorize(req)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("do request: %w", err)
+		return nil, nil, fmt.Errorf("do request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 300 {
 		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
-		return &apiStatusError{statusCode: resp.StatusCode, body: string(data)}
+		return nil, nil, &apiStatusError{statusCode: resp.StatusCode, body: string(data)}
 	}
 
-	if out != nil {
-		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
-			return fmt.Errorf("decode response: %w", err)
-		}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read response: %w", err)
 	}
-
-	return nil
+	return resp.Header, data, nil
 }
 
 func decodeVersion(body io.Reader) (int64, error) {
@@ -230,15 +687,32 @@ type apiStatusError struct {
 func (e *apiStatusError) Error() string {
 	return fmt.Sprintf("status %d: %s", e.statusCode, e.body)
 }
-func checkState(enabled bool) string {
-	if enabled {
-		return "enabled"
+
+// IsUnauthorized reports whether err is the Data Plane API rejecting the credentials or
+// denying the user access, which retrying with the same client does not fix.
+func IsUnauthorized(err error) bool {
+	var apiErr *apiStatusError
+	return errors.As(err, &apiErr) && (apiErr.statusCode == http.StatusUnauthorized || apiErr.statusCode == http.StatusForbidden)
+}
+
+func checkState(on bool) string {
+	if on {
+		return enabled
+	}
+	return disabled
+}
+
+// ConfigurationVersion returns HAProxy's current configuration version.
+func (c *DataPlaneClient) ConfigurationVersion(ctx context.Context) (int64, error) {
+	d, err := c.dialect(ctx)
+	if err != nil {
+		return 0, err
 	}
-	return "disabled"
+	return c.fetchConfigurationVersion(ctx, d)
 }
 
-func (c *DataPlaneClient) fetchConfigurationVersion(ctx context.Context) (int64, error) {
-	u := fmt.Sprintf("%s/services/haproxy/configuration/version", apiVersionPath)
+func (c *DataPlaneClient) fetchConfigurationVersion(ctx context.Context, d dialect) (int64, error) {
+	u := d.path("services/haproxy/configuration/version")
 
 	reqURL := *c.baseURL
 	reqURL.Path = path.Join(c.baseURL.Path, u)
@@ -247,11 +721,7 @@ func (c *DataPlaneClient) fetchConfigurationVersion(ctx context.Context) (int64,
 	if err != nil {
 		return 0, fmt.Errorf("build request: %w", err)
 	}
-	if c.token != "" {
-		req.Header.Set("Authorization", "Bearer "+c.token)
-	} else if c.username != "" || c.password != "" {
-		req.SetBasicAuth(c.username, c.password)
-	}
+	c.authorize(req)
 
 	httpResp, err := c.client.Do(req)
 	if err != nil {
@@ -261,7 +731,7 @@ func (c *DataPlaneClient) fetchConfigurationVersion(ctx context.Context) (int64,
 
 	if httpResp.StatusCode >= 300 {
 		data, _ := io.ReadAll(io.LimitReader(httpResp.Body, 4<<10))
-		return 0, fmt.Errorf("unexpected status %d: %s", httpResp.StatusCode, string(data))
+		return 0, &apiStatusError{statusCode: httpResp.StatusCode, body: string(data)}
 	}
 
 	version, err := decodeVersion(httpResp.Body)
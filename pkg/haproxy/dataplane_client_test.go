@@ -0,0 +1,884 @@
+package haproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDataPlane is a minimal Data Plane API stand-in that records requests and
+// answers from per-route handlers keyed by "METHOD /path". Unknown routes return 404.
+type fakeDataPlane struct {
+	t        *testing.T
+	mu       sync.Mutex
+	requests []recordedRequest
+	routes   map[string]http.HandlerFunc
+}
+
+type recordedRequest struct {
+	Method string
+	Path   string
+	Query  string
+	Raw    string
+	Body   map[string]any
+}
+
+func newFakeDataPlane(t *testing.T) (*fakeDataPlane, *httptest.Server) {
+	t.Helper()
+	f := &fakeDataPlane{t: t, routes: map[string]http.HandlerFunc{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+// newSocketFakeDataPlane serves the stand-in on a unix socket and returns its unix:// URL.
+func newSocketFakeDataPlane(t *testing.T) (*fakeDataPlane, string) {
+	t.Helper()
+	// Socket paths are limited to about 100 bytes, too short for t.TempDir under long test names.
+	dir, err := os.MkdirTemp("", "dataplane")
+	if err != nil {
+		t.Fatalf("temp dir: %v", err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "api.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	f := &fakeDataPlane{t: t, routes: map[string]http.HandlerFunc{}}
+	srv := &httptest.Server{Listener: listener, Config: &http.Server{Handler: f}}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return f, "unix://" + socket
+}
+
+func (f *fakeDataPlane) handle(route string, h http.HandlerFunc) {
+	f.routes[route] = h
+}
+
+func (f *fakeDataPlane) respond(route string, status int, body string) {
+	f.handle(route, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	})
+}
+
+func (f *fakeDataPlane) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec := recordedRequest{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery}
+	if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+		rec.Raw = string(data)
+		_ = json.Unmarshal(data, &rec.Body)
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, rec)
+	h, ok := f.routes[r.Method+" "+r.URL.Path]
+	f.mu.Unlock()
+	if !ok {
+		http.Error(w, `{"message":"not found"}`, http.StatusNotFound)
+		return
+	}
+	h(w, r)
+}
+
+// calls returns the recorded "METHOD /path" sequence.
+func (f *fakeDataPlane) calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]string, 0, len(f.requests))
+	for _, r := range f.requests {
+		out = append(out, r.Method+" "+r.Path)
+	}
+	return out
+}
+
+// last returns the most recent request for a route.
+func (f *fakeDataPlane) last(route string) (recordedRequest, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.requests) - 1; i >= 0; i-- {
+		if f.requests[i].Method+" "+f.requests[i].Path == route {
+			return f.requests[i], true
+		}
+	}
+	return recordedRequest{}, false
+}
+
+// lastRaw returns the raw body of the most recent request for a route.
+func (f *fakeDataPlane) lastRaw(route string) (string, bool) {
+	req, ok := f.last(route)
+	return req.Raw, ok
+}
+
+func TestEnsureResolversInTransaction(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("POST /v3/services/haproxy/configuration/resolvers", http.StatusCreated, `{}`)
+	fake.respond("GET /v3/services/haproxy/configuration/resolvers/k8s/nameservers", http.StatusOK, `[{"name":"ns1"},{"name":"stale"}]`)
+	fake.respond("PUT /v3/services/haproxy/configuration/resolvers/k8s/nameservers/ns1", http.StatusOK, `{}`)
+	fake.respond("POST /v3/services/haproxy/configuration/resolvers/k8s/nameservers", http.StatusCreated, `{}`)
+	fake.respond("DELETE /v3/services/haproxy/configuration/resolvers/k8s/nameservers/stale", http.StatusNoContent, ``)
+
+	c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+	cfg := ResolversConfig{Name: "k8s", Nameservers: []string{"10.96.0.10:53", "10.96.0.11:53"}, HoldValidSeconds: 10}
+	if err := c.EnsureResolversInTransaction(context.Background(), "tx1", cfg); err != nil {
+		t.Fatalf("ensure resolvers: %v", err)
+	}
+
+	expected := []string{
+		"PUT /v3/services/haproxy/configuration/resolvers/k8s",
+		"POST /v3/services/haproxy/configuration/resolvers",
+		"GET /v3/services/haproxy/configuration/resolvers/k8s/nameservers",
+		"PUT /v3/services/haproxy/configuration/resolvers/k8s/nameservers/ns1",
+		"PUT /v3/services/haproxy/configuration/resolvers/k8s/nameservers/ns2",
+		"POST /v3/services/haproxy/configuration/resolvers/k8s/nameservers",
+		"DELETE /v3/services/haproxy/configuration/resolvers/k8s/nameservers/stale",
+	}
+	if got := fake.calls(); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected calls:\n%s", strings.Join(got, "\n"))
+	}
+
+	created, _ := fake.last("POST /v3/services/haproxy/configuration/resolvers/k8s/nameservers")
+	if created.Body["address"] != "10.96.0.11" || created.Body["port"] != float64(53) {
+		t.Fatalf("unexpected nameserver payload: %v", created.Body)
+	}
+	if !strings.Contains(created.Query, "transaction_id=tx1") {
+		t.Fatalf("expected transaction id in query, got %q", created.Query)
+	}
+}
+
+func TestUpdateBackendsSendsFQDNServers(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/servers/worker-1-443", http.StatusOK, `{}`)
+
+	servers := ApplyDNS([]BackendServer{{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 1, Check: true, NodeName: "worker-1"}}, DNSConfig{
+		Enabled:       true,
+		Template:      "{node}.nodes.example.com",
+		Resolvers:     ResolversConfig{Name: "k8s"},
+		ResolvePrefer: "ipv4",
+		InitAddr:      "last,libc,none",
+	})
+
+	c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+	if err := c.UpdateBackendsInTransaction(context.Background(), "tx1", servers); err != nil {
+		t.Fatalf("update backends: %v", err)
+	}
+
+	req, ok := fake.last("PUT /v3/services/haproxy/configuration/backends/be_ingress/servers/worker-1-443")
+	if !ok {
+		t.Fatalf("server was not updated")
+	}
+	if req.Body["address"] != "worker-1.nodes.example.com" || req.Body["resolvers"] != "k8s" ||
+		req.Body["resolve-prefer"] != "ipv4" || req.Body["init-addr"] != "last,libc,none" {
+		t.Fatalf("unexpected server payload: %v", req.Body)
+	}
+}
+
+func TestUpdateHealthChecksHTTP(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("GET /v3/services/haproxy/configuration/backends/be_ingress", http.StatusOK, `{"name":"be_ingress"}`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress", http.StatusOK, `{}`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/stick_rules", http.StatusOK, `[]`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/http_checks", http.StatusOK, `[]`)
+
+	cfg := DefaultHealthCheckConfig()
+	cfg.Type = CheckHTTP
+	cfg.HTTPPath = "/healthz"
+	cfg.HTTPHost = "ingress.local"
+	cfg.HTTPExpectStatus = "^2"
+	cfg.FastInterval = time.Second
+	cfg.Port = 10254
+	cfg.SSL = true
+
+	c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+	if err := c.UpdateBackendSettingsInTransaction(context.Background(), "tx1", BackendSettings{HealthCheck: cfg}); err != nil {
+		t.Fatalf("update health checks: %v", err)
+	}
+
+	backend, _ := fake.last("PUT /v3/services/haproxy/configuration/backends/be_ingress")
+	if backend.Body["adv_check"] != "httpchk" {
+		t.Fatalf("expected httpchk, got %v", backend.Body["adv_check"])
+	}
+	params, _ := backend.Body["httpchk_params"].(map[string]any)
+	if params["uri"] != "/healthz" || params["method"] != "GET" || params["host"] != "ingress.local" {
+		t.Fatalf("unexpected httpchk params: %v", params)
+	}
+	ds, _ := backend.Body["default_server"].(map[string]any)
+	if ds["check"] != "enabled" || ds["inter"] != float64(5000) || ds["fastinter"] != float64(1000) ||
+		ds["health_check_port"] != float64(10254) || ds["check-ssl"] != "enabled" {
+		t.Fatalf("unexpected default_server: %v", ds)
+	}
+
+	checks, ok := fake.lastRaw("PUT /v3/services/haproxy/configuration/backends/be_ingress/http_checks")
+	if !ok || !strings.Contains(checks, `"match":"rstatus"`) || !strings.Contains(checks, `"pattern":"^2"`) {
+		t.Fatalf("unexpected http checks: %s", checks)
+	}
+}
+
+func TestUpdateHealthChecksTCPEnablesChecksWithoutProxy(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("GET /v3/services/haproxy/configuration/backends/be_ingress", http.StatusOK, `{"name":"be_ingress"}`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress", http.StatusOK, `{}`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/stick_rules", http.StatusOK, `[]`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/http_checks", http.StatusOK, `[]`)
+
+	c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+	if err := c.UpdateBackendSettingsInTransaction(context.Background(), "tx1", BackendSettings{HealthCheck: DefaultHealthCheckConfig()}); err != nil {
+		t.Fatalf("update health checks: %v", err)
+	}
+
+	backend, _ := fake.last("PUT /v3/services/haproxy/configuration/backends/be_ingress")
+	ds, _ := backend.Body["default_server"].(map[string]any)
+	if backend.Body["adv_check"] != "tcp-check" || ds["check"] != "enabled" {
+		t.Fatalf("expected tcp-check with checks enabled, got %v", backend.Body)
+	}
+	if _, ok := ds["send-proxy-v2"]; ok {
+		t.Fatalf("did not expect PROXY protocol when disabled: %v", ds)
+	}
+	// An empty list removes the expect rule of a previous http check.
+	if checks, ok := fake.lastRaw("PUT /v3/services/haproxy/configuration/backends/be_ingress/http_checks"); !ok || strings.TrimSpace(checks) != "[]" {
+		t.Fatalf("expected http checks to be cleared for tcp-check, got %q", checks)
+	}
+}
+
+func TestHealthCheckConfigValidate(t *testing.T) {
+	valid := DefaultHealthCheckConfig()
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("default config should be valid: %v", err)
+	}
+
+	testCases := []struct {
+		name   string
+		mutate func(*HealthCheckConfig)
+	}{
+		{name: "unknown type", mutate: func(h *HealthCheckConfig) { h.Type = "udp" }},
+		{name: "relative path", mutate: func(h *HealthCheckConfig) { h.Type = CheckHTTP; h.HTTPPath = "healthz" }},
+		{name: "status out of range", mutate: func(h *HealthCheckConfig) { h.Type = CheckHTTP; h.HTTPExpectStatus = "700" }},
+		{name: "zero rise", mutate: func(h *HealthCheckConfig) { h.RiseCount = 0 }},
+		{name: "zero interval", mutate: func(h *HealthCheckConfig) { h.Interval = 0 }},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := DefaultHealthCheckConfig()
+			tc.mutate(&cfg)
+			if err := cfg.Validate(); err == nil {
+				t.Fatalf("expected validation error")
+			}
+		})
+	}
+}
+
+func TestUpdateBackendSettingsBalanceAndPersistence(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("GET /v3/services/haproxy/configuration/backends/be_ingress", http.StatusOK, `{"name":"be_ingress"}`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress", http.StatusOK, `{}`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/stick_rules", http.StatusOK, `[]`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/http_checks", http.StatusOK, `[]`)
+
+	settings := BackendSettings{
+		HealthCheck: DefaultHealthCheckConfig(),
+		Balance:     BalanceConfig{Algorithm: "hdr(Host)", HashType: "consistent sdbm"},
+		Persistence: PersistenceConfig{
+			CookieName:       "SRVID",
+			CookieMode:       "insert",
+			StickOnSource:    true,
+			StickTableSize:   DefaultStickTableSize,
+			StickTableExpire: DefaultStickTableExpire,
+		},
+	}
+
+	c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+	if err := c.UpdateBackendSettingsInTransaction(context.Background(), "tx1", settings); err != nil {
+		t.Fatalf("update backend settings: %v", err)
+	}
+
+	backend, _ := fake.last("PUT /v3/services/haproxy/configuration/backends/be_ingress")
+	balance, _ := backend.Body["balance"].(map[string]any)
+	if balance["algorithm"] != "hdr" || balance["hdr_name"] != "Host" {
+		t.Fatalf("unexpected balance: %v", balance)
+	}
+	hashType, _ := backend.Body["hash_type"].(map[string]any)
+	if hashType["method"] != "consistent" || hashType["function"] != "sdbm" {
+		t.Fatalf("unexpected hash_type: %v", hashType)
+	}
+	cookie, _ := backend.Body["cookie"].(map[string]any)
+	if cookie["name"] != "SRVID" || cookie["type"] != "insert" || cookie["indirect"] != true {
+		t.Fatalf("unexpected cookie: %v", cookie)
+	}
+	table, _ := backend.Body["stick_table"].(map[string]any)
+	if table["type"] != "ip" || table["expire"] != float64(1800000) {
+		t.Fatalf("unexpected stick_table: %v", table)
+	}
+
+	rules, _ := fake.lastRaw("PUT /v3/services/haproxy/configuration/backends/be_ingress/stick_rules")
+	if !strings.Contains(rules, `"pattern":"src"`) {
+		t.Fatalf("expected stick on src rule, got %s", rules)
+	}
+}
+
+func TestBalancePayload(t *testing.T) {
+	testCases := []struct {
+		in       string
+		expected *balanceModel
+		wantErr  bool
+	}{
+		{in: "", expected: &balanceModel{Algorithm: "roundrobin"}},
+		{in: "leastconn", expected: &balanceModel{Algorithm: "leastconn"}},
+		{in: "url_param userid", expected: &balanceModel{Algorithm: "url_param", URLParam: "userid"}},
+		{in: "hash req.cookie(id)", expected: &balanceModel{Algorithm: "hash", HashExpression: "req.cookie(id)"}},
+		{in: "hdr()", wantErr: true},
+		{in: "fastest", wantErr: true},
+	}
+	for _, tc := range testCases {
+		got, err := balancePayload(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Fatalf("%q: expected error", tc.in)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.expected) {
+			t.Fatalf("%q: got %v, %v", tc.in, got, err)
+		}
+	}
+}
+
+func TestUpdateBackendSettingsPreservesUnmanagedFields(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("GET /v3/services/haproxy/configuration/backends/be_ingress", http.StatusOK, `{
+		"name": "be_ingress",
+		"mode": "tcp",
+		"server_timeout": 60000,
+		"http_reuse": "safe",
+		"hash_type": {"method": "consistent"},
+		"cookie": {"name": "OLD", "type": "insert"},
+		"default_server": {"check": "disabled", "maxconn": 500, "fastinter": 1000}
+	}`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress", http.StatusOK, `{}`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/stick_rules", http.StatusOK, `[]`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/http_checks", http.StatusOK, `[]`)
+
+	c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+	if err := c.UpdateBackendSettingsInTransaction(context.Background(), "tx1", BackendSettings{HealthCheck: DefaultHealthCheckConfig()}); err != nil {
+		t.Fatalf("update backend settings: %v", err)
+	}
+
+	get, _ := fake.last("GET /v3/services/haproxy/configuration/backends/be_ingress")
+	if !strings.Contains(get.Query, "transaction_id=tx1") {
+		t.Fatalf("expected backend to be read inside the transaction, got %q", get.Query)
+	}
+
+	put, _ := fake.last("PUT /v3/services/haproxy/configuration/backends/be_ingress")
+	if put.Body["mode"] != "tcp" || put.Body["server_timeout"] != float64(60000) || put.Body["http_reuse"] != "safe" {
+		t.Fatalf("unmanaged fields were not preserved: %v", put.Body)
+	}
+	if _, ok := put.Body["hash_type"]; ok {
+		t.Fatalf("expected unconfigured hash_type to be cleared: %v", put.Body)
+	}
+	if _, ok := put.Body["cookie"]; ok {
+		t.Fatalf("expected unconfigured cookie to be cleared: %v", put.Body)
+	}
+	ds, _ := put.Body["default_server"].(map[string]any)
+	if ds["check"] != "enabled" || ds["maxconn"] != float64(500) {
+		t.Fatalf("unexpected default_server merge: %v", ds)
+	}
+	if _, ok := ds["fastinter"]; ok {
+		t.Fatalf("expected unconfigured fastinter to be cleared: %v", ds)
+	}
+}
+
+func TestUpdateBackendSettingsCreatesMissingBackend(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("POST /v3/services/haproxy/configuration/backends", http.StatusCreated, `{}`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/stick_rules", http.StatusOK, `[]`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/http_checks", http.StatusOK, `[]`)
+
+	c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+	if err := c.UpdateBackendSettingsInTransaction(context.Background(), "tx1", BackendSettings{HealthCheck: DefaultHealthCheckConfig()}); err != nil {
+		t.Fatalf("update backend settings: %v", err)
+	}
+	created, ok := fake.last("POST /v3/services/haproxy/configuration/backends")
+	if !ok || created.Body["name"] != "be_ingress" {
+		t.Fatalf("expected backend to be created, got %v", created.Body)
+	}
+}
+
+func TestServerTemplateAppliedToServersAndDefaultServer(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/servers/worker-1-443", http.StatusOK, `{}`)
+	fake.respond("GET /v3/services/haproxy/configuration/backends/be_ingress", http.StatusOK, `{"name":"be_ingress","default_server":{"maxconn":50}}`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress", http.StatusOK, `{}`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/stick_rules", http.StatusOK, `[]`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/http_checks", http.StatusOK, `[]`)
+
+	template := map[string]any{"ssl": "enabled", "verify": "none", "maxconn": float64(500), "slowstart": float64(30000)}
+	c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+
+	servers := []BackendServer{{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 1, Check: true, MaxConn: 100, Options: template}}
+	if err := c.UpdateBackendsInTransaction(context.Background(), "tx1", servers); err != nil {
+		t.Fatalf("update backends: %v", err)
+	}
+	server, _ := fake.last("PUT /v3/services/haproxy/configuration/backends/be_ingress/servers/worker-1-443")
+	if server.Body["ssl"] != "enabled" || server.Body["slowstart"] != float64(30000) || server.Body["address"] != "192.168.0.1" {
+		t.Fatalf("template not merged into server: %v", server.Body)
+	}
+	if server.Body["maxconn"] != float64(100) {
+		t.Fatalf("expected annotation maxconn to win over template, got %v", server.Body["maxconn"])
+	}
+
+	settings := BackendSettings{HealthCheck: DefaultHealthCheckConfig(), ServerTemplate: template}
+	if err := c.UpdateBackendSettingsInTransaction(context.Background(), "tx1", settings); err != nil {
+		t.Fatalf("update backend settings: %v", err)
+	}
+	backend, _ := fake.last("PUT /v3/services/haproxy/configuration/backends/be_ingress")
+	ds, _ := backend.Body["default_server"].(map[string]any)
+	if ds["ssl"] != "enabled" || ds["maxconn"] != float64(500) || ds["check"] != "enabled" {
+		t.Fatalf("template not merged into default_server: %v", ds)
+	}
+}
+
+func TestServerTemplateKeysRemovedFromDefaultServer(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("GET /v3/services/haproxy/configuration/backends/be_ingress", http.StatusOK,
+		`{"name":"be_ingress","default_server":{"ssl":"enabled","slowstart":30000,"resolve_opts":"allow-dup-ip"}}`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress", http.StatusOK, `{}`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/stick_rules", http.StatusOK, `[]`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/http_checks", http.StatusOK, `[]`)
+	fake.respond("PUT /v3/services/haproxy/transactions/tx1", http.StatusOK, `{}`)
+	fake.respond("DELETE /v3/services/haproxy/transactions/tx2", http.StatusNoContent, ``)
+
+	c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+	ctx := context.Background()
+	apply := func(tx string, template map[string]any) map[string]any {
+		t.Helper()
+		settings := BackendSettings{HealthCheck: DefaultHealthCheckConfig(), ServerTemplate: template}
+		if err := c.UpdateBackendSettingsInTransaction(ctx, tx, settings); err != nil {
+			t.Fatalf("update backend settings: %v", err)
+		}
+		backend, _ := fake.last("PUT /v3/services/haproxy/configuration/backends/be_ingress")
+		ds, _ := backend.Body["default_server"].(map[string]any)
+		return ds
+	}
+
+	apply("tx1", map[string]any{"ssl": "enabled", "slowstart": float64(30000)})
+	if err := c.CommitTransaction(ctx, "tx1"); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	// An aborted transaction does not change which keys the template owns.
+	apply("tx2", map[string]any{"ssl": "enabled", "slowstart": float64(30000)})
+	if err := c.AbortTransaction(ctx, "tx2"); err != nil {
+		t.Fatalf("abort: %v", err)
+	}
+
+	ds := apply("tx3", map[string]any{"ssl": "enabled"})
+	if _, ok := ds["slowstart"]; ok || ds["ssl"] != "enabled" || ds["resolve_opts"] != "allow-dup-ip" {
+		t.Fatalf("expected only the removed template key to be cleared, got %v", ds)
+	}
+}
+
+func TestUpdateBackendSettingsProxyProtocol(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		proxy    ProxyProtocolConfig
+		existing string
+		want     map[string]any
+		absent   []string
+	}{
+		{
+			name:     "none clears previously managed fields",
+			proxy:    ProxyProtocolConfig{Version: ProxyNone},
+			existing: `{"name":"be_ingress","default_server":{"send-proxy-v2":"enabled","proxy-v2-options":["ssl"],"check-send-proxy":"enabled","ssl":"enabled"}}`,
+			want:     map[string]any{"check": "enabled", "ssl": "enabled"},
+			absent:   []string{"send-proxy", "send-proxy-v2", "proxy-v2-options", "check-send-proxy"},
+		},
+		{
+			name:   "v1 with checks",
+			proxy:  ProxyProtocolConfig{Version: ProxyV1, CheckSendProxy: true},
+			want:   map[string]any{"send-proxy": "enabled", "check-send-proxy": "enabled"},
+			absent: []string{"send-proxy-v2", "proxy-v2-options"},
+		},
+		{
+			name:     "v2 with TLVs replaces v1",
+			proxy:    ProxyProtocolConfig{Version: ProxyV2, V2Options: []string{"ssl", "authority", "unique-id"}},
+			existing: `{"name":"be_ingress","default_server":{"send-proxy":"enabled"}}`,
+			want:     map[string]any{"send-proxy-v2": "enabled", "proxy-v2-options": []any{"ssl", "authority", "unique-id"}},
+			absent:   []string{"send-proxy", "check-send-proxy"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			fake, srv := newFakeDataPlane(t)
+			existing := tc.existing
+			if existing == "" {
+				existing = `{"name":"be_ingress"}`
+			}
+			fake.respond("GET /v3/services/haproxy/configuration/backends/be_ingress", http.StatusOK, existing)
+			fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress", http.StatusOK, `{}`)
+			fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/stick_rules", http.StatusOK, `[]`)
+			fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/http_checks", http.StatusOK, `[]`)
+
+			c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+			settings := BackendSettings{HealthCheck: DefaultHealthCheckConfig(), ProxyProtocol: tc.proxy}
+			if err := c.UpdateBackendSettingsInTransaction(context.Background(), "tx1", settings); err != nil {
+				t.Fatalf("update backend settings: %v", err)
+			}
+
+			backend, _ := fake.last("PUT /v3/services/haproxy/configuration/backends/be_ingress")
+			ds, _ := backend.Body["default_server"].(map[string]any)
+			for k, v := range tc.want {
+				if !reflect.DeepEqual(ds[k], v) {
+					t.Fatalf("default_server[%s] = %v, want %v (got %v)", k, ds[k], v, ds)
+				}
+			}
+			for _, k := range tc.absent {
+				if _, ok := ds[k]; ok {
+					t.Fatalf("did not expect %s in default_server: %v", k, ds)
+				}
+			}
+		})
+	}
+}
+
+func TestProxyProtocolConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  ProxyProtocolConfig
+		wantErr bool
+	}{
+		{name: "none", config: ProxyProtocolConfig{Version: ProxyNone}},
+		{name: "v2 options", config: ProxyProtocolConfig{Version: ProxyV2, V2Options: []string{"ssl", "cert-cn", "crc32c"}, CheckSendProxy: true}},
+		{name: "unknown version", config: ProxyProtocolConfig{Version: "v3"}, wantErr: true},
+		{name: "options on v1", config: ProxyProtocolConfig{Version: ProxyV1, V2Options: []string{"ssl"}}, wantErr: true},
+		{name: "unknown option", config: ProxyProtocolConfig{Version: ProxyV2, V2Options: []string{"bogus"}}, wantErr: true},
+		{name: "check without proxy", config: ProxyProtocolConfig{Version: ProxyNone, CheckSendProxy: true}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		if err := tc.config.Validate(); (err != nil) != tc.wantErr {
+			t.Fatalf("%s: Validate() error = %v, wantErr %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestUpdateBackendsDeletesStaleServers(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("GET /v3/services/haproxy/configuration/backends/be_ingress/servers", http.StatusOK,
+		`[{"name":"worker-1-443","port":443},{"name":"worker-2-443","port":443},{"name":"worker-9-443","port":443},{"name":"sorry","address":"10.0.0.9","port":8080,"backup":"enabled"}]`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/servers/worker-1-443", http.StatusOK, `{}`)
+	fake.respond("DELETE /v3/services/haproxy/configuration/backends/be_ingress/servers/worker-2-443", http.StatusNoContent, ``)
+
+	c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+	// worker-9-443 is named like a controller server but was not created by it.
+	c.OwnServers([]string{"worker-2-443"})
+	servers := []BackendServer{{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 1, Check: true}}
+	if err := c.UpdateBackendsInTransaction(context.Background(), "tx1", servers); err != nil {
+		t.Fatalf("update backends: %v", err)
+	}
+
+	del, ok := fake.last("DELETE /v3/services/haproxy/configuration/backends/be_ingress/servers/worker-2-443")
+	if !ok || del.Query != "transaction_id=tx1" {
+		t.Fatalf("expected stale server deleted in the transaction, calls: %v", fake.calls())
+	}
+	if _, ok := fake.last("DELETE /v3/services/haproxy/configuration/backends/be_ingress/servers/worker-1-443"); ok {
+		t.Fatalf("did not expect desired server to be deleted")
+	}
+	for _, name := range []string{"sorry", "worker-9-443"} {
+		if _, ok := fake.last("DELETE /v3/services/haproxy/configuration/backends/be_ingress/servers/" + name); ok {
+			t.Fatalf("did not expect hand-added server %s to be deleted", name)
+		}
+	}
+}
+
+func TestDataPlaneClientReloadsCredentialFiles(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	var auth []string
+	fake.handle("GET /v3/services/haproxy/configuration/version", func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`7`))
+	})
+	fake.handle("POST /v3/services/haproxy/transactions", func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{"id":"tx1"}`))
+	})
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	start := time.Now().Add(-time.Minute)
+	writeFile(t, tokenFile, []byte("first\n"), start)
+
+	c, err := NewDataPlaneClientWithOptions(srv.URL, "be_ingress", DataPlaneOptions{Username: "admin", Password: "x", TokenFile: tokenFile, APIVersion: APIVersionV3})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	if _, err := c.BeginTransaction(context.Background()); err != nil {
+		t.Fatalf("begin transaction: %v", err)
+	}
+
+	writeFile(t, tokenFile, []byte("second"), start.Add(time.Second))
+	if _, err := c.BeginTransaction(context.Background()); err != nil {
+		t.Fatalf("begin transaction: %v", err)
+	}
+
+	want := []string{"Bearer first", "Bearer first", "Bearer second", "Bearer second"}
+	if !reflect.DeepEqual(auth, want) {
+		t.Fatalf("expected credentials re-read from file, got %v", auth)
+	}
+
+	if _, err := NewDataPlaneClientWithOptions(srv.URL, "be_ingress", DataPlaneOptions{PasswordFile: "/does/not/exist"}); err == nil {
+		t.Fatalf("expected missing password file to fail")
+	}
+}
+
+func TestUpdateBackendsReplacesServersInBulk(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/servers", http.StatusOK, `[]`)
+
+	servers := make([]BackendServer, 0, 300)
+	for i := 0; i < 300; i++ {
+		servers = append(servers, BackendServer{Name: fmt.Sprintf("worker-%d-443", i), Address: "192.168.0.1", Port: 443, Weight: 1, Check: true})
+	}
+
+	c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+	if err := c.UpdateBackendsInTransaction(context.Background(), "tx1", servers); err != nil {
+		t.Fatalf("update backends: %v", err)
+	}
+	if calls := fake.calls(); len(calls) != 2 {
+		t.Fatalf("expected one list and one bulk request, got %d: %v", len(calls), calls[:min(len(calls), 5)])
+	}
+
+	raw, _ := fake.lastRaw("PUT /v3/services/haproxy/configuration/backends/be_ingress/servers")
+	var body []map[string]any
+	if err := json.Unmarshal([]byte(raw), &body); err != nil || len(body) != 300 || body[299]["name"] != "worker-299-443" {
+		t.Fatalf("unexpected bulk payload (%d servers): %v", len(body), err)
+	}
+}
+
+func TestUpdateBackendsBulkKeepsHandAddedServers(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("GET /v3/services/haproxy/configuration/backends/be_ingress/servers", http.StatusOK,
+		`[{"name":"worker-2-443","address":"192.168.0.2","port":443},{"name":"sorry","address":"10.0.0.9","port":8080,"backup":"enabled"}]`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/servers", http.StatusOK, `[]`)
+
+	c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+	c.OwnServers([]string{"worker-2-443"})
+	servers := []BackendServer{{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 1, Check: true}}
+	if err := c.UpdateBackendsInTransaction(context.Background(), "tx1", servers); err != nil {
+		t.Fatalf("update backends: %v", err)
+	}
+
+	raw, _ := fake.lastRaw("PUT /v3/services/haproxy/configuration/backends/be_ingress/servers")
+	var body []map[string]any
+	if err := json.Unmarshal([]byte(raw), &body); err != nil || len(body) != 2 {
+		t.Fatalf("unexpected bulk payload %s: %v", raw, err)
+	}
+	if body[0]["name"] != "worker-1-443" || body[1]["name"] != "sorry" || body[1]["backup"] != "enabled" {
+		t.Fatalf("expected the desired server and the unchanged hand-added one, got %s", raw)
+	}
+}
+
+func TestUpdateBackendsFallsBackWithoutBulkReplace(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/servers", http.StatusMethodNotAllowed, `{"message":"method not allowed"}`)
+	fake.respond("GET /v3/services/haproxy/configuration/backends/be_ingress/servers", http.StatusOK, `[]`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/servers/worker-1-443", http.StatusOK, `{}`)
+
+	c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+	servers := []BackendServer{{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 1, Check: true}}
+	for i := 0; i < 2; i++ {
+		if err := c.UpdateBackendsInTransaction(context.Background(), "tx1", servers); err != nil {
+			t.Fatalf("update backends: %v", err)
+		}
+	}
+
+	expected := []string{
+		"GET /v3/services/haproxy/configuration/backends/be_ingress/servers",
+		"PUT /v3/services/haproxy/configuration/backends/be_ingress/servers",
+		"PUT /v3/services/haproxy/configuration/backends/be_ingress/servers/worker-1-443",
+		// The bulk request is not retried once the API rejected it.
+		"GET /v3/services/haproxy/configuration/backends/be_ingress/servers",
+		"PUT /v3/services/haproxy/configuration/backends/be_ingress/servers/worker-1-443",
+	}
+	if got := fake.calls(); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected calls:\n%s", strings.Join(got, "\n"))
+	}
+}
+
+func TestUpdateBackendsCachesBulkReplaceNotFound(t *testing.T) {
+	// Error bodies in the shape the Data Plane API's go-openapi router writes.
+	const (
+		routeNotFound   = `{"code":404,"message":"path /v3/services/haproxy/configuration/backends/be_ingress/servers was not found"}`
+		backendNotFound = `{"code":404,"message":"missing object: backend be_ingress does not exist"}`
+	)
+	testCases := []struct {
+		name     string
+		list     int
+		listBody string
+		expected []string
+	}{
+		{
+			name: "route missing", list: http.StatusOK, listBody: `[]`,
+			expected: []string{
+				"GET /v3/services/haproxy/configuration/backends/be_ingress/servers",
+				"PUT /v3/services/haproxy/configuration/backends/be_ingress/servers",
+				"PUT /v3/services/haproxy/configuration/backends/be_ingress/servers/worker-1-443",
+				// The backend exists, so the 404 was the route and is remembered.
+				"GET /v3/services/haproxy/configuration/backends/be_ingress/servers",
+				"PUT /v3/services/haproxy/configuration/backends/be_ingress/servers/worker-1-443",
+			},
+		},
+		{
+			name: "backend missing", list: http.StatusNotFound, listBody: backendNotFound,
+			expected: []string{
+				"GET /v3/services/haproxy/configuration/backends/be_ingress/servers",
+				"PUT /v3/services/haproxy/configuration/backends/be_ingress/servers",
+				"PUT /v3/services/haproxy/configuration/backends/be_ingress/servers/worker-1-443",
+				"GET /v3/services/haproxy/configuration/backends/be_ingress/servers",
+				"PUT /v3/services/haproxy/configuration/backends/be_ingress/servers",
+				"PUT /v3/services/haproxy/configuration/backends/be_ingress/servers/worker-1-443",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake, srv := newFakeDataPlane(t)
+			fake.respond("GET /v3/services/haproxy/configuration/backends/be_ingress/servers", tc.list, tc.listBody)
+			fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/servers", http.StatusNotFound, routeNotFound)
+			fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/servers/worker-1-443", http.StatusOK, `{}`)
+
+			c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+			servers := []BackendServer{{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 1, Check: true}}
+			for i := 0; i < 2; i++ {
+				if err := c.UpdateBackendsInTransaction(context.Background(), "tx1", servers); err != nil {
+					t.Fatalf("update backends: %v", err)
+				}
+			}
+			if got := fake.calls(); strings.Join(got, "\n") != strings.Join(tc.expected, "\n") {
+				t.Fatalf("unexpected calls:\n%s", strings.Join(got, "\n"))
+			}
+		})
+	}
+}
+
+func TestCommitTransactionWaitsForReload(t *testing.T) {
+	testCases := []struct {
+		name     string
+		statuses []string
+		wantErr  bool
+	}{
+		{name: "succeeded", statuses: []string{"in_progress", "succeeded"}},
+		{name: "failed", statuses: []string{"in_progress", "in_progress", "failed"}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake, srv := newFakeDataPlane(t)
+			fake.handle("PUT /v3/services/haproxy/transactions/tx1", func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Reload-ID", "2026-10-18-1")
+				w.WriteHeader(http.StatusAccepted)
+				_, _ = w.Write([]byte(`{"id":"tx1","status":"success"}`))
+			})
+			polls := 0
+			fake.handle("GET /v3/services/haproxy/reloads/2026-10-18-1", func(w http.ResponseWriter, _ *http.Request) {
+				status := tc.statuses[min(polls, len(tc.statuses)-1)]
+				polls++
+				_, _ = fmt.Fprintf(w, `{"id":"2026-10-18-1","status":%q,"response":"[ALERT] backend be_ingress: unknown keyword"}`, status)
+			})
+
+			c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+			c.reloadPollInterval = time.Millisecond
+			err := c.CommitTransaction(context.Background(), "tx1")
+			if polls != len(tc.statuses) {
+				t.Fatalf("expected %d polls, got %d", len(tc.statuses), polls)
+			}
+			if !tc.wantErr {
+				if err != nil || c.ReloadFailures() != 0 {
+					t.Fatalf("expected successful reload, got %v", err)
+				}
+				return
+			}
+			var reloadErr *ReloadError
+			if !errors.As(err, &reloadErr) || !strings.Contains(reloadErr.Response, "unknown keyword") {
+				t.Fatalf("expected reload error with response text, got %v", err)
+			}
+			if c.ReloadFailures() != 1 {
+				t.Fatalf("expected failed reload to be counted, got %d", c.ReloadFailures())
+			}
+		})
+	}
+}
+
+func TestCommitTransactionWithoutReload(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("PUT /v3/services/haproxy/transactions/tx1", http.StatusOK, `{"id":"tx1","status":"success"}`)
+
+	c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+	if err := c.CommitTransaction(context.Background(), "tx1"); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if calls := fake.calls(); len(calls) != 1 {
+		t.Fatalf("did not expect reload polling without Reload-ID: %v", calls)
+	}
+}
+
+func TestDataPlaneClientOverUnixSocket(t *testing.T) {
+	fake, socketURL := newSocketFakeDataPlane(t)
+	fake.respond("GET /v3/info", http.StatusOK, `{"api":{"version":"v3.0.1"}}`)
+	fake.respond("GET /v3/services/haproxy/configuration/version", http.StatusOK, `4`)
+	fake.respond("POST /v3/services/haproxy/transactions", http.StatusCreated, `{"id":"tx1"}`)
+	fake.respond("PUT /v3/services/haproxy/configuration/backends/be_ingress/servers", http.StatusOK, `[]`)
+	fake.respond("PUT /v3/services/haproxy/transactions/tx1", http.StatusOK, `{}`)
+
+	c, err := NewDataPlaneClientWithOptions(socketURL, "be_ingress", DataPlaneOptions{})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	ctx := context.Background()
+	if version, err := c.ConfigurationVersion(ctx); err != nil || version != 4 {
+		t.Fatalf("got configuration version %d, %v", version, err)
+	}
+	tx, err := c.BeginTransaction(ctx)
+	if err != nil {
+		t.Fatalf("begin transaction: %v", err)
+	}
+	servers := []BackendServer{{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 1}}
+	if err := c.UpdateBackendsInTransaction(ctx, tx, servers); err != nil {
+		t.Fatalf("update backends: %v", err)
+	}
+	if err := c.CommitTransaction(ctx, tx); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	want := []string{
+		"GET /v3/info",
+		"GET /v3/services/haproxy/configuration/version",
+		"GET /v3/services/haproxy/configuration/version",
+		"POST /v3/services/haproxy/transactions",
+		"GET /v3/services/haproxy/configuration/backends/be_ingress/servers",
+		"PUT /v3/services/haproxy/configuration/backends/be_ingress/servers",
+		"PUT /v3/services/haproxy/transactions/tx1",
+	}
+	if got := fake.calls(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected calls over the socket: %v", got)
+	}
+
+	if _, err := NewDataPlaneClientWithOptions(socketURL, "be_ingress", DataPlaneOptions{TLS: TLSConfig{CAFile: "ca.pem"}}); err == nil {
+		t.Fatalf("expected TLS over a unix socket to be rejected")
+	}
+}
@@ -0,0 +1,422 @@
+package haproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Typed Data Plane v3 models. They cover the fields the controller sets or templates may set;
+// objects read from the API keep all other fields in Extra, so a read-modify-write round trip
+// does not drop settings made outside the controller. JSON names follow the v3 OpenAPI schema,
+// which mixes snake_case and the haproxy.cfg keyword spelling (e.g. "health_check_port" next to
+// "send-proxy-v2").
+
+// Values of the v3 enabled/disabled toggles.
+const (
+	enabled  = "enabled"
+	disabled = "disabled"
+)
+
+// extraFields holds JSON fields a model does not declare.
+type extraFields map[string]json.RawMessage
+
+type transactionModel struct {
+	ID      string `json:"id,omitempty"`
+	Version int64  `json:"_version,omitempty"`
+	Status  string `json:"status,omitempty"`
+}
+
+type backendModel struct {
+	backendFields
+	Extra extraFields `json:"-"`
+}
+
+type backendFields struct {
+	Name          string              `json:"name"`
+	Mode          string              `json:"mode,omitempty"`
+	Description   string              `json:"description,omitempty"`
+	AdvCheck      string              `json:"adv_check,omitempty"`
+	HTTPChkParams *httpChkParamsModel `json:"httpchk_params,omitempty"`
+	CheckTimeout  *int64              `json:"check_timeout,omitempty"`
+	Balance       *balanceModel       `json:"balance,omitempty"`
+	HashType      *hashTypeModel      `json:"hash_type,omitempty"`
+	Cookie        *cookieModel        `json:"cookie,omitempty"`
+	StickTable    *stickTableModel    `json:"stick_table,omitempty"`
+	DefaultServer *defaultServerModel `json:"default_server,omitempty"`
+
+	ConnectTimeout       *int64           `json:"connect_timeout,omitempty"`
+	ServerTimeout        *int64           `json:"server_timeout,omitempty"`
+	QueueTimeout         *int64           `json:"queue_timeout,omitempty"`
+	TunnelTimeout        *int64           `json:"tunnel_timeout,omitempty"`
+	ServerFinTimeout     *int64           `json:"server_fin_timeout,omitempty"`
+	HTTPKeepAliveTimeout *int64           `json:"http_keep_alive_timeout,omitempty"`
+	HTTPRequestTimeout   *int64           `json:"http_request_timeout,omitempty"`
+	Retries              *int64           `json:"retries,omitempty"`
+	Fullconn             *int64           `json:"fullconn,omitempty"`
+	Redispatch           *redispatchModel `json:"redispatch,omitempty"`
+	HTTPReuse            string           `json:"http_reuse,omitempty"`
+	HTTPConnectionMode   string           `json:"http_connection_mode,omitempty"`
+	Forwardfor           *forwardforModel `json:"forwardfor,omitempty"`
+	Abortonclose         string           `json:"abortonclose,omitempty"`
+	Allbackups           string           `json:"allbackups,omitempty"`
+	PreferLastServer     string           `json:"prefer_last_server,omitempty"`
+	LogTag               string           `json:"log_tag,omitempty"`
+}
+
+type httpChkParamsModel struct {
+	Method  string `json:"method,omitempty"`
+	URI     string `json:"uri,omitempty"`
+	Version string `json:"version,omitempty"`
+	Host    string `json:"host,omitempty"`
+}
+
+type balanceModel struct {
+	Algorithm      string `json:"algorithm"`
+	HdrName        string `json:"hdr_name,omitempty"`
+	URLParam       string `json:"url_param,omitempty"`
+	HashExpression string `json:"hash_expression,omitempty"`
+}
+
+type hashTypeModel struct {
+	Method   string `json:"method,omitempty"`
+	Function string `json:"function,omitempty"`
+	Modifier string `json:"modifier,omitempty"`
+}
+
+type cookieModel struct {
+	Name     string `json:"name"`
+	Type     string `json:"type,omitempty"`
+	Indirect bool   `json:"indirect,omitempty"`
+	Nocache  bool   `json:"nocache,omitempty"`
+}
+
+type stickTableModel struct {
+	Type   string `json:"type,omitempty"`
+	Size   *int64 `json:"size,omitempty"`
+	Expire *int64 `json:"expire,omitempty"`
+}
+
+type redispatchModel struct {
+	Enabled  string `json:"enabled"`
+	Interval *int64 `json:"interval,omitempty"`
+}
+
+type forwardforModel struct {
+	Enabled string `json:"enabled"`
+	Except  string `json:"except,omitempty"`
+	Header  string `json:"header,omitempty"`
+	Ifnone  bool   `json:"ifnone,omitempty"`
+}
+
+// serverParams are the parameters shared by servers and default_server.
+type serverParams struct {
+	Weight      *int64 `json:"weight,omitempty"`
+	Check       string `json:"check,omitempty"`
+	Maintenance string `json:"maintenance,omitempty"`
+	Backup      string `json:"backup,omitempty"`
+	Cookie      string `json:"cookie,omitempty"`
+
+	Inter           *int64 `json:"inter,omitempty"`
+	Fastinter       *int64 `json:"fastinter,omitempty"`
+	Downinter       *int64 `json:"downinter,omitempty"`
+	Rise            *int64 `json:"rise,omitempty"`
+	Fall            *int64 `json:"fall,omitempty"`
+	HealthCheckPort *int64 `json:"health_check_port,omitempty"`
+	CheckSsl        string `json:"check-ssl,omitempty"`
+	CheckSni        string `json:"check-sni,omitempty"`
+	CheckAlpn       string `json:"check_alpn,omitempty"`
+
+	SendProxy        string   `json:"send-proxy,omitempty"`
+	SendProxyV2      string   `json:"send-proxy-v2,omitempty"`
+	SendProxyV2Ssl   string   `json:"send-proxy-v2-ssl,omitempty"`
+	SendProxyV2SslCn string   `json:"send-proxy-v2-ssl-cn,omitempty"`
+	ProxyV2Options   []string `json:"proxy-v2-options,omitempty"`
+	CheckSendProxy   string   `json:"check-send-proxy,omitempty"`
+
+	Resolvers     string `json:"resolvers,omitempty"`
+	ResolvePrefer string `json:"resolve-prefer,omitempty"`
+	InitAddr      string `json:"init-addr,omitempty"`
+
+	AgentCheck string `json:"agent-check,omitempty"`
+	AgentAddr  string `json:"agent-addr,omitempty"`
+	AgentPort  *int64 `json:"agent-port,omitempty"`
+	AgentSend  string `json:"agent-send,omitempty"`
+	AgentInter *int64 `json:"agent-inter,omitempty"`
+
+	Ssl            string `json:"ssl,omitempty"`
+	Verify         string `json:"verify,omitempty"`
+	SslCafile      string `json:"ssl_cafile,omitempty"`
+	SslCertificate string `json:"ssl_certificate,omitempty"`
+	Sni            string `json:"sni,omitempty"`
+	Alpn           string `json:"alpn,omitempty"`
+	Proto          string `json:"proto,omitempty"`
+	Maxconn        *int64 `json:"maxconn,omitempty"`
+	Maxqueue       *int64 `json:"maxqueue,omitempty"`
+	Minconn        *int64 `json:"minconn,omitempty"`
+	Slowstart      *int64 `json:"slowstart,omitempty"`
+	OnMarkedDown   string `json:"on-marked-down,omitempty"`
+	OnMarkedUp     string `json:"on-marked-up,omitempty"`
+	OnError        string `json:"on-error,omitempty"`
+	Observe        string `json:"observe,omitempty"`
+	ErrorLimit     *int64 `json:"error_limit,omitempty"`
+	PoolMaxConn    *int64 `json:"pool_max_conn,omitempty"`
+	PoolPurgeDelay *int64 `json:"pool_purge_delay,omitempty"`
+	Tfo            string `json:"tfo,omitempty"`
+	Ws             string `json:"ws,omitempty"`
+}
+
+type defaultServerModel struct {
+	serverParams
+	Extra extraFields `json:"-"`
+}
+
+type serverModel struct {
+	serverFields
+	Extra extraFields `json:"-"`
+}
+
+type serverFields struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Port    *int64 `json:"port,omitempty"`
+	serverParams
+}
+
+type frontendModel struct {
+	frontendFields
+	Extra extraFields `json:"-"`
+}
+
+type frontendFields struct {
+	Name                 string           `json:"name"`
+	Mode                 string           `json:"mode,omitempty"`
+	Description          string           `json:"description,omitempty"`
+	DefaultBackend       string           `json:"default_backend,omitempty"`
+	Maxconn              *int64           `json:"maxconn,omitempty"`
+	ClientTimeout        *int64           `json:"client_timeout,omitempty"`
+	HTTPKeepAliveTimeout *int64           `json:"http_keep_alive_timeout,omitempty"`
+	HTTPRequestTimeout   *int64           `json:"http_request_timeout,omitempty"`
+	HTTPConnectionMode   string           `json:"http_connection_mode,omitempty"`
+	Forwardfor           *forwardforModel `json:"forwardfor,omitempty"`
+	LogTag               string           `json:"log_tag,omitempty"`
+}
+
+type bindModel struct {
+	bindFields
+	Extra extraFields `json:"-"`
+}
+
+type bindFields struct {
+	Name           string `json:"name"`
+	Address        string `json:"address,omitempty"`
+	Port           *int64 `json:"port,omitempty"`
+	PortRangeEnd   *int64 `json:"port-range-end,omitempty"`
+	V4v6           bool   `json:"v4v6,omitempty"`
+	Transparent    bool   `json:"transparent,omitempty"`
+	AcceptProxy    bool   `json:"accept_proxy,omitempty"`
+	Ssl            bool   `json:"ssl,omitempty"`
+	SslCertificate string `json:"ssl_certificate,omitempty"`
+	Alpn           string `json:"alpn,omitempty"`
+	Maxconn        *int64 `json:"maxconn,omitempty"`
+}
+
+type resolverModel struct {
+	Name                string `json:"name"`
+	AcceptedPayloadSize *int64 `json:"accepted_payload_size,omitempty"`
+	HoldValid           *int64 `json:"hold_valid,omitempty"`
+}
+
+type nameserverModel struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Port    *int64 `json:"port,omitempty"`
+}
+
+type stickRuleModel struct {
+	Type    string `json:"type"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+type httpCheckModel struct {
+	Type    string `json:"type"`
+	Match   string `json:"match,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+func (m backendModel) MarshalJSON() ([]byte, error) {
+	return marshalModel(m.backendFields, m.Extra)
+}
+
+func (m *backendModel) UnmarshalJSON(data []byte) (err error) {
+	m.Extra, err = unmarshalModel(data, &m.backendFields)
+	return err
+}
+
+func (m defaultServerModel) MarshalJSON() ([]byte, error) {
+	return marshalModel(m.serverParams, m.Extra)
+}
+
+func (m *defaultServerModel) UnmarshalJSON(data []byte) (err error) {
+	m.Extra, err = unmarshalModel(data, &m.serverParams)
+	return err
+}
+
+func (m serverModel) MarshalJSON() ([]byte, error) {
+	return marshalModel(m.serverFields, m.Extra)
+}
+
+func (m *serverModel) UnmarshalJSON(data []byte) (err error) {
+	m.Extra, err = unmarshalModel(data, &m.serverFields)
+	return err
+}
+
+func (m frontendModel) MarshalJSON() ([]byte, error) {
+	return marshalModel(m.frontendFields, m.Extra)
+}
+
+func (m *frontendModel) UnmarshalJSON(data []byte) (err error) {
+	m.Extra, err = unmarshalModel(data, &m.frontendFields)
+	return err
+}
+
+func (m bindModel) MarshalJSON() ([]byte, error) {
+	return marshalModel(m.bindFields, m.Extra)
+}
+
+func (m *bindModel) UnmarshalJSON(data []byte) (err error) {
+	m.Extra, err = unmarshalModel(data, &m.bindFields)
+	return err
+}
+
+// marshalModel encodes the declared fields of v and adds extra fields it does not set.
+func marshalModel(v any, extra extraFields) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for k, raw := range extra {
+		if _, ok := fields[k]; !ok {
+			fields[k] = raw
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// unmarshalModel decodes data into v and returns the fields v does not declare.
+func unmarshalModel(data []byte, v any) (extraFields, error) {
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	var fields extraFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name := range jsonFields(reflect.TypeOf(v).Elem()) {
+		delete(fields, name)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields, nil
+}
+
+// jsonFields maps the JSON names of t's fields, including promoted ones, to their index paths.
+func jsonFields(t reflect.Type) map[string][]int {
+	out := map[string][]int{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			for name, index := range jsonFields(f.Type) {
+				out[name] = append([]int{i}, index...)
+			}
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if !f.IsExported() || name == "-" || name == "" {
+			continue
+		}
+		out[name] = []int{i}
+	}
+	return out
+}
+
+// copyFields sets the fields of dst named by their JSON names to the values in src, including
+// zero values, so that a setting the controller no longer wants is cleared.
+func copyFields[T any](dst *T, src T, names []string) {
+	fields := jsonFields(reflect.TypeOf(src))
+	d, s := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src)
+	for _, name := range names {
+		if index, ok := fields[name]; ok {
+			d.FieldByIndex(index).Set(s.FieldByIndex(index))
+		}
+	}
+}
+
+// withFields returns model with fields, given in the JSON schema, applied on top. Values that do
+// not fit the model's types are reported instead of being sent to the API.
+func withFields[T any](model T, fields map[string]any) (T, error) {
+	if len(fields) == 0 {
+		return model, nil
+	}
+	data, err := json.Marshal(model)
+	if err != nil {
+		return model, err
+	}
+	merged := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return model, err
+	}
+	for k, v := range fields {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return model, fmt.Errorf("field %s: %w", k, err)
+		}
+		merged[k] = raw
+	}
+	if data, err = json.Marshal(merged); err != nil {
+		return model, err
+	}
+	var out T
+	if err := json.Unmarshal(data, &out); err != nil {
+		return model, err
+	}
+	return out, nil
+}
+
+// withoutFields returns model with the fields named in the JSON schema removed.
+func withoutFields[T any](model T, names []string) (T, error) {
+	if len(names) == 0 {
+		return model, nil
+	}
+	data, err := json.Marshal(model)
+	if err != nil {
+		return model, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return model, err
+	}
+	for _, name := range names {
+		delete(fields, name)
+	}
+	if data, err = json.Marshal(fields); err != nil {
+		return model, err
+	}
+	var out T
+	if err := json.Unmarshal(data, &out); err != nil {
+		return model, err
+	}
+	return out, nil
+}
+
+func int64Ptr[T ~int | ~int32 | ~int64](v T) *int64 {
+	n := int64(v)
+	return &n
+}
@@ -0,0 +1,114 @@
+package haproxy
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestModelsRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name  string
+		model any
+		input string
+	}{
+		{name: "transaction", model: &transactionModel{}, input: `{"id":"273e3385-2d0c-4fb1-aa27-93cbb31ff203","_version":7,"status":"in_progress"}`},
+		{name: "backend", model: &backendModel{}, input: `{
+			"name":"be_ingress","mode":"tcp","adv_check":"httpchk","check_timeout":5000,
+			"httpchk_params":{"method":"GET","uri":"/healthz","version":"HTTP/1.1","host":"ingress.local"},
+			"balance":{"algorithm":"hdr","hdr_name":"Host"},
+			"hash_type":{"method":"consistent","function":"sdbm","modifier":"avalanche"},
+			"cookie":{"name":"SRVID","type":"insert","indirect":true,"nocache":true},
+			"stick_table":{"type":"ip","size":100000,"expire":1800000},
+			"redispatch":{"enabled":"enabled","interval":0},
+			"forwardfor":{"enabled":"enabled","header":"X-Client"},
+			"server_timeout":60000,"retries":3,"log_tag":"ingress",
+			"default_server":{"check":"enabled","inter":5000,"rise":2,"fall":2,"health_check_port":10254,
+				"send-proxy-v2":"enabled","proxy-v2-options":["ssl","authority"],"check-send-proxy":"enabled","resolve_opts":"allow-dup-ip"},
+			"hash_balance_factor":150,"http-check":{"unknown":"kept"}}`},
+		{name: "server", model: &serverModel{}, input: `{
+			"name":"worker-1-443","address":"worker-1.nodes.example.com","port":443,"weight":0,"check":"enabled",
+			"maintenance":"enabled","backup":"enabled","cookie":"worker-1-443","maxconn":100,
+			"resolvers":"k8s","resolve-prefer":"ipv4","init-addr":"last,libc,none",
+			"agent-check":"enabled","agent-addr":"10.0.0.10","agent-port":8081,"agent-send":"worker-1-443\\n","agent-inter":2000,
+			"ssl":"enabled","verify":"required","sni":"str(ingress.example.com)","check-sni":"ingress.example.com","check_alpn":"h2",
+			"on-marked-down":"shutdown-sessions","id":12}`},
+		{name: "frontend", model: &frontendModel{}, input: `{"name":"fe_https","mode":"tcp","default_backend":"be_ingress","maxconn":2000,"client_timeout":30000,"tcplog":true}`},
+		{name: "bind", model: &bindModel{}, input: `{"name":"https","address":"::","port":443,"v4v6":true,"accept_proxy":true,"ssl":true,"ssl_certificate":"/etc/haproxy/certs/","alpn":"h2,http/1.1","thread":"all"}`},
+		{name: "resolver", model: &resolverModel{}, input: `{"name":"k8s","accepted_payload_size":8192,"hold_valid":10000}`},
+		{name: "nameserver", model: &nameserverModel{}, input: `{"name":"ns1","address":"10.96.0.10","port":53}`},
+		{name: "stick rule", model: &stickRuleModel{}, input: `{"type":"on","pattern":"src"}`},
+		{name: "http check", model: &httpCheckModel{}, input: `{"type":"expect","match":"rstatus","pattern":"^2"}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := json.Unmarshal([]byte(tc.input), tc.model); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			out, err := json.Marshal(tc.model)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			var want, got any
+			_ = json.Unmarshal([]byte(tc.input), &want)
+			_ = json.Unmarshal(out, &got)
+			if !reflect.DeepEqual(want, got) {
+				t.Fatalf("round trip changed the object:\n in: %s\nout: %s", tc.input, out)
+			}
+		})
+	}
+}
+
+func TestModelsRejectMistypedValues(t *testing.T) {
+	for _, input := range []string{`{"name":"a","address":"x","port":"443"}`, `{"name":"a","address":"x","weight":1.5}`} {
+		var s serverModel
+		if err := json.Unmarshal([]byte(input), &s); err == nil {
+			t.Fatalf("expected %s to be rejected", input)
+		}
+	}
+}
+
+// The owned and template field lists name JSON fields; each must exist in the typed model, or
+// copyFields would skip it and templates would travel untyped.
+func TestFieldListsAreModelled(t *testing.T) {
+	backend := jsonFields(reflect.TypeOf(backendModel{}))
+	server := jsonFields(reflect.TypeOf(serverModel{}))
+	defaultServer := jsonFields(reflect.TypeOf(defaultServerModel{}))
+
+	check := func(what string, fields map[string][]int, names []string) {
+		for _, name := range names {
+			if _, ok := fields[name]; !ok {
+				t.Errorf("%s field %q is not in the model", what, name)
+			}
+		}
+	}
+	check("owned backend", backend, ownedBackendFields)
+	check("owned server", server, ownedServerFields)
+	check("owned default_server", defaultServer, ownedDefaultServerFields)
+	for name := range backendTemplateFields {
+		check("backend template", backend, []string{name})
+	}
+	for name := range serverTemplateFields {
+		check("server template", server, []string{name})
+		check("server template", defaultServer, []string{name})
+	}
+}
+
+func TestServerBodyKeepsZeroWeight(t *testing.T) {
+	body, err := serverBody(BackendServer{Name: "a", Address: "10.0.0.1", Port: 443, Weight: 5, MaxConn: 50, State: ServerStateDrain,
+		Options: map[string]any{"maxconn": float64(500), "proto": "h2"}})
+	if err != nil {
+		t.Fatalf("server body: %v", err)
+	}
+	data, _ := json.Marshal(body)
+	for _, part := range []string{`"weight":0`, `"maxconn":50`, `"proto":"h2"`, `"check":"disabled"`} {
+		if !strings.Contains(string(data), part) {
+			t.Fatalf("expected %s in %s", part, data)
+		}
+	}
+
+	if _, err := serverBody(BackendServer{Name: "a", Options: map[string]any{"slowstart": "soon"}}); err == nil {
+		t.Fatalf("expected a mistyped template option to be rejected")
+	}
+}
@@ -0,0 +1,211 @@
+package haproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// APIVersion selects the Data Plane API dialect.
+type APIVersion string
+
+const (
+	// APIVersionAuto probes /v3/info and then /v2/info on first use.
+	APIVersionAuto APIVersion = "auto"
+	// APIVersionV2 is Data Plane API 2.x, shipped with HAProxy 2.4-2.8.
+	APIVersionV2 APIVersion = "v2"
+	// APIVersionV3 is Data Plane API 3.x.
+	APIVersionV3 APIVersion = "v3"
+)
+
+// ParseAPIVersion maps a configuration string to an APIVersion; empty input means auto.
+func ParseAPIVersion(v string) (APIVersion, error) {
+	switch version := APIVersion(v); version {
+	case "":
+		return APIVersionAuto, nil
+	case APIVersionAuto, APIVersionV2, APIVersionV3:
+		return version, nil
+	default:
+		return "", fmt.Errorf("unknown Data Plane API version %q: expected auto, v2 or v3", v)
+	}
+}
+
+// dialect maps the resources the controller uses to the paths and payloads of one API version.
+// v3 nests child collections under their parent, returns bare objects and replaces lists with
+// one PUT; v2 keeps them flat, selects the parent with query parameters, wraps configuration
+// reads in {"_version": N, "data": ...} and addresses list entries by index.
+type dialect struct {
+	version APIVersion
+}
+
+// collection is a child resource list with the query parameters that select its parent.
+type collection struct {
+	path  string
+	query url.Values
+}
+
+func (d dialect) path(elem ...string) string {
+	return path.Join(append([]string{"/" + string(d.version)}, elem...)...)
+}
+
+func (d dialect) child(parentKind, parentName, kind string, v2Query url.Values) collection {
+	if d.version == APIVersionV2 {
+		return collection{path: d.path("services/haproxy/configuration", kind), query: v2Query}
+	}
+	return collection{path: d.path("services/haproxy/configuration", parentKind, parentName, kind)}
+}
+
+func (d dialect) servers(backend string) collection {
+	return d.child("backends", backend, "servers", url.Values{"backend": {backend}})
+}
+
+func (d dialect) nameservers(resolvers string) collection {
+	return d.child("resolvers", resolvers, "nameservers", url.Values{"resolver": {resolvers}})
+}
+
+func (d dialect) stickRules(backend string) collection {
+	return d.child("backends", backend, "stick_rules", url.Values{"backend": {backend}})
+}
+
+func (d dialect) httpChecks(backend string) collection {
+	return d.child("backends", backend, "http_checks", url.Values{"parent_type": {"backend"}, "parent_name": {backend}})
+}
+
+// values returns the collection's parent selection plus the transaction.
+func (c collection) values(transactionID string) url.Values {
+	values := url.Values{}
+	for k, v := range c.query {
+		values[k] = v
+	}
+	values.Set("transaction_id", transactionID)
+	return values
+}
+
+func (c collection) item(name string) string {
+	return path.Join(c.path, name)
+}
+
+// dialect returns the configured dialect, probing the API on first use in auto mode.
+// A failed probe is not cached, so the next call tries again.
+func (c *DataPlaneClient) dialect(ctx context.Context) (dialect, error) {
+	c.dialectMu.Lock()
+	defer c.dialectMu.Unlock()
+
+	if c.negotiated != "" {
+		return dialect{version: c.negotiated}, nil
+	}
+	if c.apiVersion != APIVersionAuto {
+		c.negotiated = c.apiVersion
+		return dialect{version: c.negotiated}, nil
+	}
+
+	for _, version := range []APIVersion{APIVersionV3, APIVersionV2} {
+		err := c.doRequest(ctx, http.MethodGet, dialect{version: version}.path("info"), nil, nil, nil)
+		var apiErr *apiStatusError
+		switch {
+		case err == nil:
+			log.Printf("using Data Plane API %s", version)
+			c.negotiated = version
+			return dialect{version: version}, nil
+		case errors.As(err, &apiErr) && apiErr.statusCode == http.StatusNotFound:
+			continue
+		default:
+			return dialect{}, fmt.Errorf("probe Data Plane API %s: %w", version, err)
+		}
+	}
+	return dialect{}, fmt.Errorf("neither /v3/info nor /v2/info found, is this a Data Plane API?")
+}
+
+// v2HostHeader joins the Host header to the HTTP version in v2 httpchk_params, which has no
+// host field; HAProxy sends the escaped version string as the rest of the request line.
+const v2HostHeader = `\r\nHost:\ `
+
+// encodeBackend translates a backend in the v3 schema the models follow to the dialect's schema.
+func (d dialect) encodeBackend(b backendModel) backendModel {
+	if d.version != APIVersionV2 || b.HTTPChkParams == nil || b.HTTPChkParams.Host == "" {
+		return b
+	}
+	params := *b.HTTPChkParams
+	params.Version += v2HostHeader + params.Host
+	params.Host = ""
+	b.HTTPChkParams = &params
+	return b
+}
+
+// decodeBackend translates a backend read in the dialect's schema back to the v3 schema.
+func (d dialect) decodeBackend(b backendModel) backendModel {
+	if d.version != APIVersionV2 || b.HTTPChkParams == nil {
+		return b
+	}
+	version, host, ok := strings.Cut(b.HTTPChkParams.Version, v2HostHeader)
+	if !ok {
+		return b
+	}
+	params := *b.HTTPChkParams
+	params.Version, params.Host = version, host
+	b.HTTPChkParams = &params
+	return b
+}
+
+// encodeServer translates a server to the dialect's schema. The v2 server and default_server
+// parameters the controller writes, including check, inter, rise, fall, health_check_port,
+// check-ssl and check-send-proxy, are spelled as in v3, so servers pass through unchanged.
+func (d dialect) encodeServer(s serverModel) serverModel {
+	return s
+}
+
+// replaceList makes the list at coll equal to items.
+func (c *DataPlaneClient) replaceList(ctx context.Context, d dialect, coll collection, transactionID string, items []any) error {
+	values := coll.values(transactionID)
+	if d.version != APIVersionV2 {
+		return c.doRequest(ctx, http.MethodPut, coll.path, values, items, nil)
+	}
+
+	var existing []json.RawMessage
+	if err := c.getConfig(ctx, d, coll.path, values, &existing); err != nil {
+		return fmt.Errorf("list: %w", err)
+	}
+	// Delete from the end so the remaining indexes stay valid.
+	for i := len(existing) - 1; i >= 0; i-- {
+		if err := c.doRequest(ctx, http.MethodDelete, coll.item(strconv.Itoa(i)), values, nil, nil); err != nil {
+			return fmt.Errorf("delete entry %d: %w", i, err)
+		}
+	}
+	for i, item := range items {
+		entry, err := withFields(item, map[string]any{"index": i})
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", i, err)
+		}
+		if err := c.doRequest(ctx, http.MethodPost, coll.path, values, entry, nil); err != nil {
+			return fmt.Errorf("create entry %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// getConfig reads a configuration object or collection into out, unwrapping the v2 envelope.
+func (c *DataPlaneClient) getConfig(ctx context.Context, d dialect, p string, values url.Values, out any) error {
+	if d.version != APIVersionV2 {
+		return c.doRequest(ctx, http.MethodGet, p, values, nil, out)
+	}
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := c.doRequest(ctx, http.MethodGet, p, values, nil, &envelope); err != nil {
+		return err
+	}
+	if len(envelope.Data) == 0 {
+		return fmt.Errorf("decode response: no data field")
+	}
+	if err := json.Unmarshal(envelope.Data, out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package haproxy
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDataPlaneClientNegotiatesAPIVersion(t *testing.T) {
+	testCases := []struct {
+		name     string
+		info     []string
+		expected APIVersion
+		wantErr  bool
+	}{
+		{name: "v3", info: []string{"GET /v3/info", "GET /v2/info"}, expected: APIVersionV3},
+		{name: "v2", info: []string{"GET /v2/info"}, expected: APIVersionV2},
+		{name: "neither", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake, srv := newFakeDataPlane(t)
+			for _, route := range tc.info {
+				fake.respond(route, http.StatusOK, `{"api":{"version":"x"}}`)
+			}
+
+			c, err := NewDataPlaneClientWithOptions(srv.URL, "be_ingress", DataPlaneOptions{})
+			if err != nil {
+				t.Fatalf("new client: %v", err)
+			}
+			d, err := c.dialect(context.Background())
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected negotiation to fail")
+				}
+				return
+			}
+			if err != nil || d.version != tc.expected {
+				t.Fatalf("got %q, %v; want %q", d.version, err, tc.expected)
+			}
+
+			// The result is cached, so no further probes are sent.
+			probes := len(fake.calls())
+			if _, err := c.dialect(context.Background()); err != nil || len(fake.calls()) != probes {
+				t.Fatalf("expected cached dialect, calls: %v", fake.calls())
+			}
+		})
+	}
+}
+
+func TestDataPlaneClientV2Servers(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("GET /v2/services/haproxy/configuration/servers", http.StatusOK,
+		`{"_version":7,"data":[{"name":"worker-1-443","port":443},{"name":"worker-2-443","port":443}]}`)
+	fake.respond("PUT /v2/services/haproxy/configuration/servers/worker-1-443", http.StatusOK, `{}`)
+	fake.respond("DELETE /v2/services/haproxy/configuration/servers/worker-2-443", http.StatusNoContent, ``)
+
+	c, err := NewDataPlaneClientWithOptions(srv.URL, "be_ingress", DataPlaneOptions{APIVersion: APIVersionV2})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	c.OwnServers([]string{"worker-2-443"})
+	servers := []BackendServer{{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 1, Check: true}}
+	if err := c.UpdateBackendsInTransaction(context.Background(), "tx1", servers); err != nil {
+		t.Fatalf("update backends: %v", err)
+	}
+
+	put, ok := fake.last("PUT /v2/services/haproxy/configuration/servers/worker-1-443")
+	if !ok || put.Query != "backend=be_ingress&transaction_id=tx1" || put.Body["address"] != "192.168.0.1" {
+		t.Fatalf("unexpected server update %+v, calls: %v", put, fake.calls())
+	}
+	del, ok := fake.last("DELETE /v2/services/haproxy/configuration/servers/worker-2-443")
+	if !ok || del.Query != "backend=be_ingress&transaction_id=tx1" {
+		t.Fatalf("expected stale server deleted from the backend, calls: %v", fake.calls())
+	}
+}
+
+func TestDataPlaneClientV2BackendSettings(t *testing.T) {
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("GET /v2/services/haproxy/configuration/backends/be_ingress", http.StatusOK,
+		`{"_version":7,"data":{"name":"be_ingress","mode":"tcp"}}`)
+	fake.respond("PUT /v2/services/haproxy/configuration/backends/be_ingress", http.StatusOK, `{}`)
+	fake.respond("GET /v2/services/haproxy/configuration/stick_rules", http.StatusOK,
+		`{"_version":7,"data":[{"index":0,"type":"on","pattern":"src"},{"index":1,"type":"match","pattern":"dst"}]}`)
+	fake.respond("DELETE /v2/services/haproxy/configuration/stick_rules/1", http.StatusNoContent, ``)
+	fake.respond("DELETE /v2/services/haproxy/configuration/stick_rules/0", http.StatusNoContent, ``)
+	fake.respond("POST /v2/services/haproxy/configuration/stick_rules", http.StatusCreated, `{}`)
+	fake.respond("GET /v2/services/haproxy/configuration/http_checks", http.StatusOK, `{"_version":7,"data":[]}`)
+	fake.respond("POST /v2/services/haproxy/configuration/http_checks", http.StatusCreated, `{}`)
+
+	settings := BackendSettings{HealthCheck: DefaultHealthCheckConfig()}
+	settings.HealthCheck.Type = CheckHTTP
+	settings.Persistence = PersistenceConfig{StickOnSource: true, StickTableSize: DefaultStickTableSize, StickTableExpire: DefaultStickTableExpire}
+
+	c, err := NewDataPlaneClientWithOptions(srv.URL, "be_ingress", DataPlaneOptions{APIVersion: APIVersionV2})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	if err := c.UpdateBackendSettingsInTransaction(context.Background(), "tx1", settings); err != nil {
+		t.Fatalf("update backend settings: %v, calls: %v", err, fake.calls())
+	}
+
+	backend, _ := fake.last("PUT /v2/services/haproxy/configuration/backends/be_ingress")
+	if backend.Body["mode"] != "tcp" || backend.Body["adv_check"] != "httpchk" {
+		t.Fatalf("expected unwrapped backend merged with owned fields, got %v", backend.Body)
+	}
+
+	expected := []string{
+		"GET /v2/services/haproxy/configuration/backends/be_ingress",
+		"PUT /v2/services/haproxy/configuration/backends/be_ingress",
+		"GET /v2/services/haproxy/configuration/stick_rules",
+		"DELETE /v2/services/haproxy/configuration/stick_rules/1",
+		"DELETE /v2/services/haproxy/configuration/stick_rules/0",
+		"POST /v2/services/haproxy/configuration/stick_rules",
+		"GET /v2/services/haproxy/configuration/http_checks",
+		"POST /v2/services/haproxy/configuration/http_checks",
+	}
+	if !reflect.DeepEqual(fake.calls(), expected) {
+		t.Fatalf("unexpected calls:\n%v\nwant:\n%v", fake.calls(), expected)
+	}
+
+	rule, _ := fake.last("POST /v2/services/haproxy/configuration/stick_rules")
+	if rule.Query != "backend=be_ingress&transaction_id=tx1" || rule.Body["index"] != float64(0) || rule.Body["pattern"] != "src" {
+		t.Fatalf("unexpected stick rule %+v", rule)
+	}
+	check, _ := fake.last("POST /v2/services/haproxy/configuration/http_checks")
+	if check.Query != "parent_name=be_ingress&parent_type=backend&transaction_id=tx1" || check.Body["type"] != "expect" {
+		t.Fatalf("unexpected http check %+v", check)
+	}
+}
+
+func TestDataPlaneClientTranslatesCheckFields(t *testing.T) {
+	testCases := []struct {
+		version APIVersion
+		read    string
+		params  map[string]any
+	}{
+		{
+			version: APIVersionV3,
+			read:    `{"name":"be_ingress","httpchk_params":{"method":"GET","uri":"/healthz","version":"HTTP/1.1","host":"ingress.local"}}`,
+			params:  map[string]any{"method": "GET", "uri": "/healthz", "version": "HTTP/1.1", "host": "ingress.local"},
+		},
+		{
+			// v2 has no host field; the header is carried in the version, and reading it back
+			// does not append it a second time.
+			version: APIVersionV2,
+			read:    `{"_version":7,"data":{"name":"be_ingress","httpchk_params":{"method":"GET","uri":"/healthz","version":"HTTP/1.1\\r\\nHost:\\ ingress.local"}}}`,
+			params:  map[string]any{"method": "GET", "uri": "/healthz", "version": `HTTP/1.1\r\nHost:\ ingress.local`},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(string(tc.version), func(t *testing.T) {
+			fake, srv := newFakeDataPlane(t)
+			base := "/" + string(tc.version) + "/services/haproxy/configuration/"
+			fake.respond("GET "+base+"backends/be_ingress", http.StatusOK, tc.read)
+			fake.respond("PUT "+base+"backends/be_ingress", http.StatusOK, `{}`)
+			fake.respond("GET "+base+"servers", http.StatusOK, `{"_version":7,"data":[]}`)
+			fake.respond("PUT "+base+"servers/worker-1-443", http.StatusOK, `{}`)
+			fake.respond("GET "+base+"stick_rules", http.StatusOK, `{"_version":7,"data":[]}`)
+			fake.respond("GET "+base+"http_checks", http.StatusOK, `{"_version":7,"data":[]}`)
+			fake.respond("POST "+base+"http_checks", http.StatusCreated, `{}`)
+			fake.respond("PUT "+base+"backends/be_ingress/stick_rules", http.StatusOK, `[]`)
+			fake.respond("PUT "+base+"backends/be_ingress/http_checks", http.StatusOK, `[]`)
+			fake.respond("GET "+base+"backends/be_ingress/servers", http.StatusOK, `[]`)
+			fake.respond("PUT "+base+"backends/be_ingress/servers", http.StatusOK, `[]`)
+
+			c, err := NewDataPlaneClientWithOptions(srv.URL, "be_ingress", DataPlaneOptions{APIVersion: tc.version})
+			if err != nil {
+				t.Fatalf("new client: %v", err)
+			}
+			settings := BackendSettings{HealthCheck: DefaultHealthCheckConfig()}
+			settings.HealthCheck.Type = CheckHTTP
+			settings.HealthCheck.HTTPPath = "/healthz"
+			settings.HealthCheck.HTTPHost = "ingress.local"
+			settings.HealthCheck.Port = 10254
+			settings.HealthCheck.SSL = true
+			if err := c.UpdateBackendSettingsInTransaction(context.Background(), "tx1", settings); err != nil {
+				t.Fatalf("update backend settings: %v, calls: %v", err, fake.calls())
+			}
+			backend, _ := fake.last("PUT " + base + "backends/be_ingress")
+			if !reflect.DeepEqual(backend.Body["httpchk_params"], tc.params) {
+				t.Fatalf("unexpected httpchk_params %v", backend.Body["httpchk_params"])
+			}
+			ds, _ := backend.Body["default_server"].(map[string]any)
+			if ds["check"] != "enabled" || ds["health_check_port"] != float64(10254) || ds["check-ssl"] != "enabled" || ds["inter"] != float64(5000) {
+				t.Fatalf("unexpected default_server %v", ds)
+			}
+
+			servers := []BackendServer{{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 1, Check: true}}
+			if err := c.UpdateBackendsInTransaction(context.Background(), "tx1", servers); err != nil {
+				t.Fatalf("update backends: %v", err)
+			}
+			route := "PUT " + base + "servers/worker-1-443"
+			if tc.version == APIVersionV3 {
+				route = "PUT " + base + "backends/be_ingress/servers"
+			}
+			raw, ok := fake.lastRaw(route)
+			if !ok {
+				t.Fatalf("expected %s, calls: %v", route, fake.calls())
+			}
+			if !strings.Contains(raw, `"check":"enabled"`) || !strings.Contains(raw, `"weight":1`) {
+				t.Fatalf("unexpected server body %s", raw)
+			}
+		})
+	}
+}
+
+func TestParseAPIVersion(t *testing.T) {
+	for in, expected := range map[string]APIVersion{"": APIVersionAuto, "auto": APIVersionAuto, "v2": APIVersionV2, "v3": APIVersionV3} {
+		if got, err := ParseAPIVersion(in); err != nil || got != expected {
+			t.Fatalf("%q: got %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseAPIVersion("v1"); err == nil {
+		t.Fatalf("expected v1 to be rejected")
+	}
+}
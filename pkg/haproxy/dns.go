@@ -0,0 +1,70 @@
+package haproxy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Defaults for DNS address mode.
+const (
+	DefaultResolversName    = "k8s"
+	DefaultResolvePrefer    = "ipv4"
+	DefaultInitAddr         = "last,libc,none"
+	DefaultHoldValidSeconds = 10
+)
+
+// ApplyDNS rewrites servers that belong to a node to the FQDN built from cfg.Template
+// and attaches the resolver options. Servers without a node keep their literal address.
+func ApplyDNS(servers []BackendServer, cfg DNSConfig) []BackendServer {
+	if !cfg.Enabled {
+		return servers
+	}
+	for i := range servers {
+		if servers[i].NodeName == "" {
+			continue
+		}
+		servers[i].FQDN = strings.ReplaceAll(cfg.Template, "{node}", servers[i].NodeName)
+		servers[i].Resolvers = cfg.Resolvers.Name
+		servers[i].ResolvePrefer = cfg.ResolvePrefer
+		servers[i].InitAddr = cfg.InitAddr
+	}
+	return servers
+}
+
+// Validate checks the template, resolver name and nameserver addresses.
+func (d DNSConfig) Validate() error {
+	if !d.Enabled {
+		return nil
+	}
+	if !strings.Contains(d.Template, "{node}") {
+		return fmt.Errorf("server FQDN template %q must contain {node}", d.Template)
+	}
+	if d.Resolvers.Name == "" {
+		return fmt.Errorf("resolvers name is required")
+	}
+	switch d.ResolvePrefer {
+	case "", "ipv4", "ipv6":
+	default:
+		return fmt.Errorf("resolve-prefer must be ipv4 or ipv6, got %q", d.ResolvePrefer)
+	}
+	for _, ns := range d.Resolvers.Nameservers {
+		if _, _, err := splitNameserver(ns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitNameserver(ns string) (string, int, error) {
+	host, port, err := net.SplitHostPort(ns)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid nameserver %q: %w", ns, err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		return "", 0, fmt.Errorf("invalid nameserver port in %q", ns)
+	}
+	return host, p, nil
+}
@@ -0,0 +1,662 @@
+package haproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileOptions configures a FileClient.
+type FileOptions struct {
+	// Path is the configuration snippet to write, e.g. /etc/haproxy/conf.d/50-ingress.cfg.
+	Path string
+	// HAProxyBin validates the snippet with "haproxy -c" before it is installed. Empty looks up
+	// haproxy in PATH and skips validation when it is not installed.
+	HAProxyBin string
+	// ValidateWith lists configuration files loaded before the snippet during validation,
+	// typically the main haproxy.cfg with the global and defaults sections.
+	ValidateWith []string
+	// MasterSocket is the master CLI socket used to reload HAProxy; empty skips the reload.
+	MasterSocket string
+	// Timeout bounds validation and the reload; 0 means 30s.
+	Timeout time.Duration
+}
+
+const defaultFileTimeout = 30 * time.Second
+
+// fileState is everything the rendered snippet is built from.
+type fileState struct {
+	servers   []BackendServer
+	settings  BackendSettings
+	resolvers *ResolversConfig
+	// raw is installed instead of a rendering when a snapshot is restored.
+	raw []byte
+}
+
+// FileClient implements Client by rendering the backend into a standalone configuration
+// snippet, for HAProxy hosts whose configuration is managed as files. A commit validates and
+// atomically replaces the file, then reloads HAProxy through the master CLI.
+type FileClient struct {
+	backendName string
+	opts        FileOptions
+	haproxyBin  string
+
+	masterNetwork string
+	masterAddress string
+
+	mu      sync.Mutex
+	nextID  int
+	current fileState
+	pending map[string]*fileState
+	// needsReload is set while an installed file has not been reloaded successfully.
+	needsReload bool
+}
+
+// NewFileClient creates a FileClient writing the backend backendName to opts.Path.
+func NewFileClient(backendName string, opts FileOptions) (*FileClient, error) {
+	if opts.Path == "" {
+		return nil, fmt.Errorf("file path is required")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultFileTimeout
+	}
+	c := &FileClient{backendName: backendName, opts: opts, pending: make(map[string]*fileState)}
+
+	if opts.HAProxyBin != "" {
+		bin, err := exec.LookPath(opts.HAProxyBin)
+		if err != nil {
+			return nil, fmt.Errorf("haproxy binary: %w", err)
+		}
+		c.haproxyBin = bin
+	} else if bin, err := exec.LookPath("haproxy"); err == nil {
+		c.haproxyBin = bin
+	} else {
+		log.Printf("haproxy binary not found, %s is installed without validation", opts.Path)
+	}
+
+	if opts.MasterSocket != "" {
+		network, address, err := parseSocketAddr(opts.MasterSocket)
+		if err != nil {
+			return nil, err
+		}
+		c.masterNetwork, c.masterAddress = network, address
+	}
+	return c, nil
+}
+
+// BeginTransaction starts a change set based on the last committed state.
+func (c *FileClient) BeginTransaction(_ context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	id := fmt.Sprintf("file-%d", c.nextID)
+	state := c.current
+	c.pending[id] = &state
+	return id, nil
+}
+
+// CommitTransaction renders the snippet and installs it when it changed.
+func (c *FileClient) CommitTransaction(ctx context.Context, transactionID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, ok := c.pending[transactionID]
+	delete(c.pending, transactionID)
+	if !ok {
+		return fmt.Errorf("commit transaction: unknown transaction %q", transactionID)
+	}
+
+	rendered := state.raw
+	if rendered == nil {
+		var err error
+		if rendered, err = renderBackend(c.backendName, *state); err != nil {
+			return err
+		}
+	}
+	if existing, err := os.ReadFile(c.opts.Path); err != nil || !bytes.Equal(existing, rendered) {
+		if err := c.install(ctx, rendered); err != nil {
+			return err
+		}
+		c.needsReload = true
+	}
+	c.current = *state
+	if !c.needsReload {
+		return nil
+	}
+	// A failed reload is retried by the next commit even if the file does not change again.
+	if err := c.reload(ctx); err != nil {
+		return err
+	}
+	c.needsReload = false
+	return nil
+}
+
+// AbortTransaction discards the change set.
+func (c *FileClient) AbortTransaction(_ context.Context, transactionID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, transactionID)
+	return nil
+}
+
+// UpdateBackendsInTransaction records the desired servers.
+func (c *FileClient) UpdateBackendsInTransaction(_ context.Context, transactionID string, backends []BackendServer) error {
+	return c.update(transactionID, func(s *fileState) { s.servers = append([]BackendServer{}, backends...) })
+}
+
+// UpdateBackendSettingsInTransaction records the backend settings.
+func (c *FileClient) UpdateBackendSettingsInTransaction(_ context.Context, transactionID string, settings BackendSettings) error {
+	return c.update(transactionID, func(s *fileState) { s.settings = settings })
+}
+
+// EnsureResolversInTransaction renders a resolvers section into the snippet.
+func (c *FileClient) EnsureResolversInTransaction(_ context.Context, transactionID string, config ResolversConfig) error {
+	return c.update(transactionID, func(s *fileState) { s.resolvers = &config })
+}
+
+func (c *FileClient) update(transactionID string, fn func(*fileState)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, ok := c.pending[transactionID]
+	if !ok {
+		return fmt.Errorf("unknown transaction %q", transactionID)
+	}
+	state.raw = nil
+	fn(state)
+	return nil
+}
+
+// SnapshotBackend reads the installed snippet, which holds the whole managed backend; a
+// missing file is saved as empty. The servers are parsed from its server lines.
+func (c *FileClient) SnapshotBackend(_ context.Context, _ []string, _ string) (*BackendSnapshot, error) {
+	data, err := os.ReadFile(c.opts.Path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read %s: %w", c.opts.Path, err)
+	}
+	raw, err := json.Marshal(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return &BackendSnapshot{Servers: parseFileServers(data), Data: raw}, nil
+}
+
+// RestoreBackendInTransaction installs the saved snippet on commit.
+func (c *FileClient) RestoreBackendInTransaction(_ context.Context, transactionID string, snapshot *BackendSnapshot) error {
+	var data string
+	if err := json.Unmarshal(snapshot.Data, &data); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	return c.update(transactionID, func(s *fileState) {
+		*s = fileState{servers: parseFileServers([]byte(data)), raw: []byte(data)}
+	})
+}
+
+// parseFileServers reads the name, address, weight and maintenance state back from the
+// server lines of a rendered snippet.
+func parseFileServers(data []byte) []BackendServer {
+	var servers []BackendServer
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "server" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(fields[2])
+		if err != nil {
+			continue
+		}
+		srv := BackendServer{Name: fields[1], Address: host}
+		if net.ParseIP(host) == nil {
+			srv.Address, srv.FQDN = "", host
+		}
+		if p, err := strconv.Atoi(port); err == nil {
+			srv.Port = int32(p)
+		}
+		for i, field := range fields[3:] {
+			switch {
+			case field == "disabled":
+				srv.State = ServerStateMaint
+			case field == "check":
+				srv.Check = true
+			case field == "weight" && i+4 < len(fields):
+				srv.Weight, _ = strconv.Atoi(fields[i+4])
+			}
+		}
+		servers = append(servers, srv)
+	}
+	return servers
+}
+
+// install validates data in a temporary file next to the target and renames it into place.
+func (c *FileClient) install(ctx context.Context, data []byte) error {
+	dir := filepath.Dir(c.opts.Path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(c.opts.Path)+".*")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write %s: %w", tmp.Name(), err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("chmod %s: %w", tmp.Name(), err)
+	}
+
+	if c.haproxyBin != "" {
+		ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+		args := []string{"-c", "-q"}
+		for _, f := range c.opts.ValidateWith {
+			args = append(args, "-f", f)
+		}
+		args = append(args, "-f", tmp.Name())
+		if out, err := exec.CommandContext(ctx, c.haproxyBin, args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("validate rendered configuration: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), c.opts.Path); err != nil {
+		return fmt.Errorf("replace %s: %w", c.opts.Path, err)
+	}
+	return nil
+}
+
+// reload asks the master process to reload. HAProxy 2.7+ answers "Success=0|1" followed by
+// the startup logs; older versions close the connection without a reply.
+func (c *FileClient) reload(ctx context.Context) error {
+	if c.masterAddress == "" {
+		return nil
+	}
+	out, err := socketCommand(ctx, c.masterNetwork, c.masterAddress, c.opts.Timeout, "reload")
+	if err != nil {
+		return fmt.Errorf("reload: %w", err)
+	}
+	if strings.HasPrefix(out, "Success=0") {
+		return &ReloadError{ID: "master", Response: strings.TrimSpace(strings.TrimPrefix(out, "Success=0"))}
+	}
+	log.Printf("reloaded HAProxy after writing %s", c.opts.Path)
+	return nil
+}
+
+// ServerStats reads server statistics from the current worker through the master CLI.
+func (c *FileClient) ServerStats(ctx context.Context) ([]ServerStats, error) {
+	if c.masterAddress == "" {
+		return nil, fmt.Errorf("server statistics need the master socket")
+	}
+	out, err := socketCommand(ctx, c.masterNetwork, c.masterAddress, c.opts.Timeout, "@1 show stat")
+	if err != nil {
+		return nil, err
+	}
+	return parseShowStat(out, c.backendName, func(name string) (string, bool) { return name, true })
+}
+
+// renderBackend writes the resolvers and backend sections in haproxy.cfg syntax.
+func renderBackend(name string, state fileState) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("# Generated by haproxy-k8s-sync; changes are overwritten on the next sync.\n")
+
+	if r := state.resolvers; r != nil {
+		fmt.Fprintf(&b, "\nresolvers %s\n", r.Name)
+		for i, ns := range r.Nameservers {
+			host, port, err := splitNameserver(ns)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, "    nameserver ns%d %s\n", i+1, net.JoinHostPort(host, strconv.Itoa(port)))
+		}
+		b.WriteString("    accepted_payload_size 8192\n")
+		fmt.Fprintf(&b, "    hold valid %ds\n", r.HoldValidSeconds)
+	}
+
+	settings := state.settings
+	hc := settings.HealthCheck
+	fmt.Fprintf(&b, "\nbackend %s\n", name)
+	// Template fields come first so the controller-owned keywords below win, as in mergeBackend.
+	lines, err := templateKeywords(settings.Template, backendKeywords)
+	if err != nil {
+		return nil, fmt.Errorf("backend template: %w", err)
+	}
+	for _, line := range lines {
+		fmt.Fprintf(&b, "    %s\n", line)
+	}
+	algorithm := strings.TrimSpace(settings.Balance.Algorithm)
+	if algorithm == "" {
+		algorithm = DefaultBalanceAlgorithm
+	}
+	fmt.Fprintf(&b, "    balance %s\n", algorithm)
+	if settings.Balance.HashType != "" {
+		fmt.Fprintf(&b, "    hash-type %s\n", settings.Balance.HashType)
+	}
+
+	if hc.Type == CheckHTTP {
+		match, pattern, err := expectStatusRule(hc.HTTPExpectStatus)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString("    option httpchk\n")
+		send := fmt.Sprintf("http-check send meth %s uri %s ver HTTP/1.1", hc.HTTPMethod, hc.HTTPPath)
+		if hc.HTTPHost != "" {
+			send += " hdr Host " + hc.HTTPHost
+		}
+		fmt.Fprintf(&b, "    %s\n", send)
+		fmt.Fprintf(&b, "    http-check expect %s %s\n", match, pattern)
+	} else {
+		b.WriteString("    option tcp-check\n")
+	}
+	if hc.Interval > 0 {
+		fmt.Fprintf(&b, "    timeout check %dms\n", durationMillis(hc.Interval))
+	}
+
+	p := settings.Persistence
+	if p.CookieName != "" {
+		cookie := fmt.Sprintf("cookie %s %s", p.CookieName, p.CookieMode)
+		if p.CookieMode == "insert" {
+			cookie += " indirect nocache"
+		}
+		fmt.Fprintf(&b, "    %s\n", cookie)
+	}
+	if p.StickOnSource {
+		fmt.Fprintf(&b, "    stick-table type ip size %d expire %dms\n", p.StickTableSize, durationMillis(p.StickTableExpire))
+		b.WriteString("    stick on src\n")
+	}
+
+	defaults, err := templateKeywords(settings.ServerTemplate, serverKeywords)
+	if err != nil {
+		return nil, fmt.Errorf("server template: %w", err)
+	}
+	fmt.Fprintf(&b, "    default-server %s\n", strings.Join(append(defaultServerArgs(settings), defaults...), " "))
+
+	servers := append([]BackendServer{}, state.servers...)
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+	for _, srv := range servers {
+		options := srv.Options
+		if srv.MaxConn > 0 && options["maxconn"] != nil {
+			// The node annotation takes precedence, as in serverBody.
+			options = withoutKey(options, "maxconn")
+		}
+		extra, err := templateKeywords(options, serverKeywords)
+		if err != nil {
+			return nil, fmt.Errorf("server %s: %w", srv.Name, err)
+		}
+		fmt.Fprintf(&b, "    server %s\n", strings.Join(append(serverArgs(srv), extra...), " "))
+	}
+	return []byte(b.String()), nil
+}
+
+func defaultServerArgs(settings BackendSettings) []string {
+	hc := settings.HealthCheck
+	args := []string{
+		"check",
+		fmt.Sprintf("inter %dms", durationMillis(hc.Interval)),
+		fmt.Sprintf("rise %d", hc.RiseCount),
+		fmt.Sprintf("fall %d", hc.FallCount),
+	}
+	if hc.FastInterval > 0 {
+		args = append(args, fmt.Sprintf("fastinter %dms", durationMillis(hc.FastInterval)))
+	}
+	if hc.DownInterval > 0 {
+		args = append(args, fmt.Sprintf("downinter %dms", durationMillis(hc.DownInterval)))
+	}
+	if hc.Port > 0 {
+		args = append(args, fmt.Sprintf("port %d", hc.Port))
+	}
+	if hc.SSL {
+		args = append(args, "check-ssl")
+	}
+	pp := settings.ProxyProtocol
+	switch pp.Version {
+	case ProxyV1:
+		args = append(args, "send-proxy")
+	case ProxyV2:
+		args = append(args, "send-proxy-v2")
+		if len(pp.V2Options) > 0 {
+			args = append(args, "proxy-v2-options "+strings.Join(pp.V2Options, ","))
+		}
+	}
+	if pp.CheckSendProxy && pp.enabled() {
+		args = append(args, "check-send-proxy")
+	}
+	return args
+}
+
+func serverArgs(srv BackendServer) []string {
+	args := []string{srv.Name}
+	weight := srv.Weight
+	if srv.FQDN != "" {
+		args = append(args, net.JoinHostPort(srv.FQDN, strconv.Itoa(int(srv.Port))))
+	} else {
+		args = append(args, net.JoinHostPort(srv.Address, strconv.Itoa(int(srv.Port))))
+	}
+	switch srv.State {
+	case ServerStateMaint:
+		args = append(args, "disabled")
+	case ServerStateDrain:
+		// As with the Data Plane API, a zero weight keeps sessions but sends no new traffic.
+		weight = 0
+	}
+	args = append(args, fmt.Sprintf("weight %d", weight))
+	if srv.Check {
+		args = append(args, "check")
+	} else {
+		args = append(args, "no-check")
+	}
+	if srv.Backup {
+		args = append(args, "backup")
+	}
+	if srv.MaxConn > 0 {
+		args = append(args, fmt.Sprintf("maxconn %d", srv.MaxConn))
+	}
+	if srv.Cookie != "" {
+		args = append(args, "cookie "+srv.Cookie)
+	}
+	if srv.FQDN != "" {
+		args = append(args, "resolvers "+srv.Resolvers, "resolve-prefer "+srv.ResolvePrefer, "init-addr "+srv.InitAddr)
+	}
+	if a := srv.Agent; a != nil {
+		args = append(args, "agent-check")
+		if a.Addr != "" {
+			args = append(args, "agent-addr "+a.Addr)
+		}
+		args = append(args, fmt.Sprintf("agent-port %d", a.Port), fmt.Sprintf("agent-inter %dms", durationMillis(a.Interval)))
+		args = append(args, fmt.Sprintf(`agent-send "%s\n"`, srv.Name))
+	}
+	return args
+}
+
+// keyword renders a template value as haproxy.cfg keywords; an empty result writes nothing.
+type keyword func(v any) (string, error)
+
+// backendKeywords maps the backendTemplateFields to backend section lines.
+var backendKeywords = map[string]keyword{
+	"mode":                    word("mode"),
+	"description":             word("description"),
+	"connect_timeout":         millis("timeout connect"),
+	"server_timeout":          millis("timeout server"),
+	"queue_timeout":           millis("timeout queue"),
+	"tunnel_timeout":          millis("timeout tunnel"),
+	"server_fin_timeout":      millis("timeout server-fin"),
+	"http_keep_alive_timeout": millis("timeout http-keep-alive"),
+	"http_request_timeout":    millis("timeout http-request"),
+	"retries":                 number("retries"),
+	"fullconn":                number("fullconn"),
+	"redispatch":              redispatchKeyword,
+	"http_reuse":              word("http-reuse"),
+	"http_connection_mode":    word("option"),
+	"forwardfor":              forwardforKeyword,
+	"abortonclose":            toggle("option abortonclose", "no option abortonclose"),
+	"allbackups":              toggle("option allbackups", "no option allbackups"),
+	"prefer_last_server":      toggle("option prefer-last-server", "no option prefer-last-server"),
+	"log_tag":                 word("log-tag"),
+}
+
+// serverKeywords maps the serverTemplateFields to server and default-server arguments.
+var serverKeywords = map[string]keyword{
+	"ssl":              toggle("ssl", "no-ssl"),
+	"verify":           word("verify"),
+	"ssl_cafile":       word("ca-file"),
+	"ssl_certificate":  word("crt"),
+	"sni":              word("sni"),
+	"alpn":             word("alpn"),
+	"proto":            word("proto"),
+	"maxconn":          number("maxconn"),
+	"maxqueue":         number("maxqueue"),
+	"minconn":          number("minconn"),
+	"slowstart":        millis("slowstart"),
+	"on-marked-down":   word("on-marked-down"),
+	"on-marked-up":     word("on-marked-up"),
+	"on-error":         word("on-error"),
+	"observe":          word("observe"),
+	"error_limit":      number("error-limit"),
+	"check-sni":        word("check-sni"),
+	"check_alpn":       word("check-alpn"),
+	"pool_max_conn":    number("pool-max-conn"),
+	"pool_purge_delay": millis("pool-purge-delay"),
+	"tfo":              toggle("tfo", "no-tfo"),
+	"ws":               word("ws"),
+}
+
+// templateKeywords renders the template fields in key order.
+func templateKeywords(tmpl map[string]any, keywords map[string]keyword) ([]string, error) {
+	keys := make([]string, 0, len(tmpl))
+	for k := range tmpl {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var out []string
+	for _, k := range keys {
+		render, ok := keywords[k]
+		if !ok {
+			return nil, fmt.Errorf("field %s has no haproxy.cfg keyword", k)
+		}
+		kw, err := render(tmpl[k])
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", k, err)
+		}
+		if kw != "" {
+			out = append(out, kw)
+		}
+	}
+	return out, nil
+}
+
+func word(name string) keyword {
+	return func(v any) (string, error) {
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("expected a string, got %T", v)
+		}
+		return name + " " + s, nil
+	}
+}
+
+func number(name string) keyword {
+	return func(v any) (string, error) {
+		n, err := templateInt(v)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %d", name, n), nil
+	}
+}
+
+// millis renders a Data Plane duration, which is in milliseconds.
+func millis(name string) keyword {
+	return func(v any) (string, error) {
+		n, err := templateInt(v)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %dms", name, n), nil
+	}
+}
+
+// toggle renders a Data Plane "enabled"/"disabled" field.
+func toggle(on, off string) keyword {
+	return func(v any) (string, error) {
+		switch v {
+		case "enabled":
+			return on, nil
+		case "disabled":
+			return off, nil
+		}
+		return "", fmt.Errorf("expected enabled or disabled, got %v", v)
+	}
+}
+
+func redispatchKeyword(v any) (string, error) {
+	var r redispatchModel
+	if err := remarshal(v, &r); err != nil {
+		return "", err
+	}
+	if r.Enabled == "disabled" {
+		return "no option redispatch", nil
+	}
+	if r.Interval != nil {
+		return fmt.Sprintf("option redispatch %d", *r.Interval), nil
+	}
+	return "option redispatch", nil
+}
+
+func forwardforKeyword(v any) (string, error) {
+	var f forwardforModel
+	if err := remarshal(v, &f); err != nil {
+		return "", err
+	}
+	if f.Enabled != "enabled" {
+		return "", nil
+	}
+	kw := "option forwardfor"
+	if f.Except != "" {
+		kw += " except " + f.Except
+	}
+	if f.Header != "" {
+		kw += " header " + f.Header
+	}
+	if f.Ifnone {
+		kw += " if-none"
+	}
+	return kw, nil
+}
+
+func templateInt(v any) (int64, error) {
+	f, ok := v.(float64)
+	if !ok || f != float64(int64(f)) {
+		return 0, fmt.Errorf("expected an integer, got %v", v)
+	}
+	return int64(f), nil
+}
+
+func remarshal(v any, out any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func withoutKey(m map[string]any, key string) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		if k != key {
+			out[k] = v
+		}
+	}
+	return out
+}
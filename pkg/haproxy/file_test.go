@@ -0,0 +1,284 @@
+package haproxy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderBackend(t *testing.T) {
+	hc := DefaultHealthCheckConfig()
+	hc.Type = CheckHTTP
+	hc.HTTPPath = "/healthz"
+	hc.HTTPHost = "ingress.local"
+	hc.HTTPExpectStatus = "^2"
+	hc.Port = 10254
+
+	state := fileState{
+		resolvers: &ResolversConfig{Name: "k8s", Nameservers: []string{"10.96.0.10:53"}, HoldValidSeconds: 10},
+		settings: BackendSettings{
+			HealthCheck:   hc,
+			Balance:       BalanceConfig{Algorithm: "hdr(Host)", HashType: "consistent sdbm"},
+			Persistence:   PersistenceConfig{CookieName: "SRVID", CookieMode: "insert", StickOnSource: true, StickTableSize: 1000, StickTableExpire: time.Minute},
+			ProxyProtocol: ProxyProtocolConfig{Version: ProxyV2, V2Options: []string{"ssl", "authority"}, CheckSendProxy: true},
+		},
+		servers: []BackendServer{
+			{Name: "worker-2-443", Address: "192.168.0.2", Port: 443, Weight: 3, Check: true, State: ServerStateDrain, MaxConn: 100, Cookie: "worker-2-443"},
+			{Name: "worker-1-443", FQDN: "worker-1.nodes.example.com", Port: 443, Weight: 1, Check: true, Resolvers: "k8s", ResolvePrefer: "ipv4", InitAddr: "last,libc,none",
+				Agent: &AgentConfig{Port: 8081, Interval: 2 * time.Second}},
+			{Name: "worker-3-443", Address: "fd00::3", Port: 443, Weight: 1, State: ServerStateMaint, Backup: true},
+		},
+	}
+
+	got, err := renderBackend("be_ingress", state)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	expected := `# Generated by haproxy-k8s-sync; changes are overwritten on the next sync.
+
+resolvers k8s
+    nameserver ns1 10.96.0.10:53
+    accepted_payload_size 8192
+    hold valid 10s
+
+backend be_ingress
+    balance hdr(Host)
+    hash-type consistent sdbm
+    option httpchk
+    http-check send meth GET uri /healthz ver HTTP/1.1 hdr Host ingress.local
+    http-check expect rstatus ^2
+    timeout check 5000ms
+    cookie SRVID insert indirect nocache
+    stick-table type ip size 1000 expire 60000ms
+    stick on src
+    default-server check inter 5000ms rise 2 fall 2 port 10254 send-proxy-v2 proxy-v2-options ssl,authority check-send-proxy
+    server worker-1-443 worker-1.nodes.example.com:443 weight 1 check resolvers k8s resolve-prefer ipv4 init-addr last,libc,none agent-check agent-port 8081 agent-inter 2000ms agent-send "worker-1-443\n"
+    server worker-2-443 192.168.0.2:443 weight 0 check maxconn 100 cookie worker-2-443
+    server worker-3-443 [fd00::3]:443 disabled weight 1 no-check backup
+`
+	if string(got) != expected {
+		t.Fatalf("unexpected rendering:\n%s", got)
+	}
+}
+
+func TestRenderBackendTemplates(t *testing.T) {
+	backendTemplate, err := ParseBackendTemplate([]byte(`
+mode: http
+server_timeout: 30000
+retries: 3
+redispatch: {enabled: enabled, interval: 2}
+forwardfor: {enabled: enabled, except: 127.0.0.0/8, ifnone: true}
+abortonclose: disabled
+`))
+	if err != nil {
+		t.Fatalf("backend template: %v", err)
+	}
+	serverTemplate, err := ParseServerTemplate([]byte(`
+ssl: enabled
+verify: required
+ssl_cafile: /etc/haproxy/ca.pem
+maxconn: 500
+slowstart: 10000
+`))
+	if err != nil {
+		t.Fatalf("server template: %v", err)
+	}
+
+	state := fileState{
+		settings: BackendSettings{HealthCheck: DefaultHealthCheckConfig(), Template: backendTemplate, ServerTemplate: serverTemplate},
+		servers: []BackendServer{
+			{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 1, Check: true, Options: serverTemplate},
+			{Name: "worker-2-443", Address: "192.168.0.2", Port: 443, Weight: 1, Check: true, MaxConn: 100, Options: serverTemplate},
+		},
+	}
+	got, err := renderBackend("be_ingress", state)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	for _, line := range []string{
+		"    option forwardfor except 127.0.0.0/8 if-none\n",
+		"    mode http\n",
+		"    no option abortonclose\n",
+		"    option redispatch 2\n",
+		"    retries 3\n",
+		"    timeout server 30000ms\n",
+		"    default-server check inter 5000ms rise 2 fall 2 maxconn 500 slowstart 10000ms ssl ca-file /etc/haproxy/ca.pem verify required\n",
+		"    server worker-1-443 192.168.0.1:443 weight 1 check maxconn 500 slowstart 10000ms ssl ca-file /etc/haproxy/ca.pem verify required\n",
+		// The node annotation's maxconn wins over the template.
+		"    server worker-2-443 192.168.0.2:443 weight 1 check maxconn 100 slowstart 10000ms ssl ca-file /etc/haproxy/ca.pem verify required\n",
+	} {
+		if !strings.Contains(string(got), line) {
+			t.Fatalf("expected %q in rendering:\n%s", line, got)
+		}
+	}
+}
+
+func TestTemplateFieldsHaveKeywords(t *testing.T) {
+	for field := range backendTemplateFields {
+		if backendKeywords[field] == nil {
+			t.Errorf("backend template field %s has no keyword", field)
+		}
+	}
+	for field := range serverTemplateFields {
+		if serverKeywords[field] == nil {
+			t.Errorf("server template field %s has no keyword", field)
+		}
+	}
+}
+
+func writeScript(t *testing.T, dir, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, "haproxy")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+	return path
+}
+
+func commitFile(c *FileClient, servers []BackendServer) error {
+	ctx := context.Background()
+	tx, _ := c.BeginTransaction(ctx)
+	if err := c.UpdateBackendsInTransaction(ctx, tx, servers); err != nil {
+		return err
+	}
+	if err := c.UpdateBackendSettingsInTransaction(ctx, tx, BackendSettings{HealthCheck: DefaultHealthCheckConfig()}); err != nil {
+		return err
+	}
+	return c.CommitTransaction(ctx, tx)
+}
+
+func TestFileClientInstallsAndReloads(t *testing.T) {
+	dir := t.TempDir()
+	master, addr := newFakeRuntime(t)
+	master.reply("reload", "Success=1\n--\n[NOTICE] Loading success.")
+	args := filepath.Join(dir, "args")
+	bin := writeScript(t, dir, `echo "$@" > `+args)
+	target := filepath.Join(dir, "ingress.cfg")
+
+	c, err := NewFileClient("be_ingress", FileOptions{Path: target, HAProxyBin: bin, ValidateWith: []string{"/etc/haproxy/haproxy.cfg"}, MasterSocket: addr})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	servers := []BackendServer{{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 1, Check: true}}
+	for i := 0; i < 2; i++ {
+		if err := commitFile(c, servers); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(target)
+	if err != nil || !strings.Contains(string(data), "server worker-1-443 192.168.0.1:443 weight 1 check") {
+		t.Fatalf("unexpected file %q: %v", data, err)
+	}
+	validated, _ := os.ReadFile(args)
+	if !strings.HasPrefix(string(validated), "-c -q -f /etc/haproxy/haproxy.cfg -f "+filepath.Join(dir, ".ingress.cfg.")) {
+		t.Fatalf("unexpected validation arguments %q", validated)
+	}
+	// The unchanged second commit neither rewrites the file nor reloads.
+	if got := master.recorded(); len(got) != 1 || got[0] != "reload" {
+		t.Fatalf("expected exactly one reload, got %v", got)
+	}
+}
+
+func TestFileClientRejectsInvalidConfiguration(t *testing.T) {
+	dir := t.TempDir()
+	bin := writeScript(t, dir, `echo "[ALERT] parsing [ingress.cfg:9]: unknown keyword" >&2; exit 1`)
+	target := filepath.Join(dir, "ingress.cfg")
+	writeFile(t, target, []byte("previous\n"), time.Now())
+
+	c, err := NewFileClient("be_ingress", FileOptions{Path: target, HAProxyBin: bin})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	err = commitFile(c, []BackendServer{{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 1}})
+	if err == nil || !strings.Contains(err.Error(), "unknown keyword") {
+		t.Fatalf("expected validation error with haproxy output, got %v", err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "previous\n" {
+		t.Fatalf("expected previous file to be kept, got %q", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Fatalf("expected temporary file to be removed, got %d entries", len(entries))
+	}
+}
+
+func TestFileClientReportsFailedReload(t *testing.T) {
+	dir := t.TempDir()
+	master, addr := newFakeRuntime(t)
+	master.reply("reload", "Success=0\n--\n[ALERT] config: cannot bind socket")
+
+	c, err := NewFileClient("be_ingress", FileOptions{Path: filepath.Join(dir, "ingress.cfg"), HAProxyBin: writeScript(t, dir, "exit 0"), MasterSocket: addr})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	err = commitFile(c, []BackendServer{{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 1}})
+	var reloadErr *ReloadError
+	if !errors.As(err, &reloadErr) || !strings.Contains(reloadErr.Response, "cannot bind socket") {
+		t.Fatalf("expected reload error, got %v", err)
+	}
+
+	master.reply("reload", "Success=1")
+	if err := commitFile(c, []BackendServer{{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 1}}); err != nil {
+		t.Fatalf("expected retried reload to succeed: %v", err)
+	}
+	if got := master.recorded(); len(got) != 2 {
+		t.Fatalf("expected the reload to be retried for the unchanged file, got %v", got)
+	}
+}
+
+func TestFileClientSnapshotAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "ingress.cfg")
+	c, err := NewFileClient("be_ingress", FileOptions{Path: target, HAProxyBin: writeScript(t, dir, "exit 0")})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	ctx := context.Background()
+	before := []BackendServer{
+		{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 2, Check: true},
+		{Name: "worker-2-443", FQDN: "worker-2.nodes.example.com", Port: 443, Weight: 1, State: ServerStateMaint, Resolvers: "k8s"},
+	}
+	if err := commitFile(c, before); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	saved, _ := os.ReadFile(target)
+
+	snapshot, err := c.SnapshotBackend(ctx, nil, "")
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	want := []BackendServer{
+		{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 2, Check: true},
+		{Name: "worker-2-443", FQDN: "worker-2.nodes.example.com", Port: 443, Weight: 1, State: ServerStateMaint},
+	}
+	if !reflect.DeepEqual(snapshot.Servers, want) {
+		t.Fatalf("unexpected snapshot servers %+v", snapshot.Servers)
+	}
+
+	if err := commitFile(c, []BackendServer{{Name: "worker-3-443", Address: "192.168.0.3", Port: 443, Weight: 1}}); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	tx, _ := c.BeginTransaction(ctx)
+	if err := c.RestoreBackendInTransaction(ctx, tx, snapshot); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if err := c.CommitTransaction(ctx, tx); err != nil {
+		t.Fatalf("commit restore: %v", err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != string(saved) {
+		t.Fatalf("expected the saved file back, got %q", data)
+	}
+
+	// The next sync renders again.
+	if err := commitFile(c, []BackendServer{{Name: "worker-3-443", Address: "192.168.0.3", Port: 443, Weight: 1}}); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if data, _ := os.ReadFile(target); !strings.Contains(string(data), "server worker-3-443") {
+		t.Fatalf("expected a rendering after the restore, got %q", data)
+	}
+}
@@ -0,0 +1,79 @@
+package haproxy
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHealthCheckConfig returns the tcp-check settings used when nothing is configured.
+func DefaultHealthCheckConfig() HealthCheckConfig {
+	return HealthCheckConfig{
+		Type:             CheckTCP,
+		HTTPMethod:       "GET",
+		HTTPPath:         "/",
+		HTTPExpectStatus: "200",
+		Interval:         5 * time.Second,
+		RiseCount:        2,
+		FallCount:        2,
+	}
+}
+
+// Validate checks that the configuration can be expressed as HAProxy check settings.
+func (h HealthCheckConfig) Validate() error {
+	switch h.Type {
+	case CheckTCP:
+	case CheckHTTP:
+		if h.HTTPMethod == "" || strings.ContainsAny(h.HTTPMethod, " \t\r\n") {
+			return fmt.Errorf("invalid HTTP check method %q", h.HTTPMethod)
+		}
+		if !strings.HasPrefix(h.HTTPPath, "/") || strings.ContainsAny(h.HTTPPath, " \r\n") {
+			return fmt.Errorf("HTTP check path %q must start with / and contain no spaces", h.HTTPPath)
+		}
+		if strings.ContainsAny(h.HTTPHost, " \r\n") {
+			return fmt.Errorf("invalid HTTP check host %q", h.HTTPHost)
+		}
+		if _, _, err := expectStatusRule(h.HTTPExpectStatus); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown check type %q", h.Type)
+	}
+
+	if h.Interval < time.Millisecond {
+		return fmt.Errorf("check interval must be at least 1ms, got %s", h.Interval)
+	}
+	if h.FastInterval < 0 || h.DownInterval < 0 {
+		return fmt.Errorf("check fastinter/downinter must not be negative")
+	}
+	if h.RiseCount < 1 || h.FallCount < 1 {
+		return fmt.Errorf("check rise and fall must be at least 1")
+	}
+	if h.Port < 0 || h.Port > 65535 {
+		return fmt.Errorf("invalid check port %d", h.Port)
+	}
+	return nil
+}
+
+// expectStatusRule maps HTTPExpectStatus to an http-check expect match and pattern.
+func expectStatusRule(status string) (string, string, error) {
+	if n, err := strconv.Atoi(status); err == nil {
+		if n < 100 || n > 599 {
+			return "", "", fmt.Errorf("HTTP check expected status %d out of range", n)
+		}
+		return "status", status, nil
+	}
+	if status == "" {
+		return "", "", fmt.Errorf("HTTP check expected status is required")
+	}
+	if _, err := regexp.Compile(status); err != nil {
+		return "", "", fmt.Errorf("invalid HTTP check expected status pattern %q: %w", status, err)
+	}
+	return "rstatus", status, nil
+}
+
+func durationMillis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
@@ -0,0 +1,326 @@
+package haproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultHistoryLimit is the number of applied configurations kept by default.
+const DefaultHistoryLimit = 10
+
+// HistoryEntry is the managed backend as read before a commit, with the change the commit made.
+type HistoryEntry struct {
+	// ID numbers the entries in the order they were recorded, starting at 1.
+	ID   int64
+	Time time.Time
+	Diff HistoryDiff
+	// SettingsKey identifies the backend settings the commit applied, so the next entry can
+	// tell whether it changed them.
+	SettingsKey string `json:",omitempty"`
+	// BackendSnapshot is what rollback writes back. Its Version is HAProxy's configuration
+	// version before the commit, omitted when the client has none or reading it failed.
+	// The servers are stored without runtime fields, the server template and agent.
+	BackendSnapshot
+}
+
+// HistoryDiff summarizes what changed compared to the previous entry.
+type HistoryDiff struct {
+	Added    []string `json:",omitempty"`
+	Removed  []string `json:",omitempty"`
+	Changed  []string `json:",omitempty"`
+	Settings bool     `json:",omitempty"`
+}
+
+// Empty reports whether nothing changed.
+func (d HistoryDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 && !d.Settings
+}
+
+// String renders the diff for logs and the rollback command.
+func (d HistoryDiff) String() string {
+	if d.Empty() {
+		return "no changes"
+	}
+	out := fmt.Sprintf("+%d -%d ~%d servers", len(d.Added), len(d.Removed), len(d.Changed))
+	if d.Settings {
+		out += ", backend settings"
+	}
+	return out
+}
+
+// HistoryStore persists history entries, oldest first.
+type HistoryStore interface {
+	Load(ctx context.Context) ([]HistoryEntry, error)
+	Save(ctx context.Context, entries []HistoryEntry) error
+}
+
+// History records applied configurations in a store, keeping the newest Limit entries.
+type History struct {
+	store HistoryStore
+	limit int
+	mu    sync.Mutex
+}
+
+// NewHistory keeps up to limit entries in store; limit <= 0 means DefaultHistoryLimit.
+func NewHistory(store HistoryStore, limit int) *History {
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	return &History{store: store, limit: limit}
+}
+
+// Entries returns the stored entries, oldest first.
+func (h *History) Entries(ctx context.Context) ([]HistoryEntry, error) {
+	return h.store.Load(ctx)
+}
+
+// Find returns the entry with the given ID.
+func (h *History) Find(ctx context.Context, id int64) (HistoryEntry, error) {
+	entries, err := h.store.Load(ctx)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	for _, e := range entries {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return HistoryEntry{}, fmt.Errorf("entry %d is not in the history", id)
+}
+
+// Record appends before, the backend as read before a commit, with the change to servers
+// and the settings identified by settingsKey. Nothing is recorded when the commit changed
+// nothing.
+func (h *History) Record(ctx context.Context, before *BackendSnapshot, servers []BackendServer, settingsKey string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries, err := h.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	entry := HistoryEntry{ID: 1, Time: time.Now().UTC(), SettingsKey: settingsKey, BackendSnapshot: *before}
+	entry.Servers = make([]BackendServer, len(before.Servers))
+	for i, srv := range before.Servers {
+		srv = historyServer(srv)
+		srv.Options, srv.Agent = nil, nil
+		entry.Servers[i] = srv
+	}
+	entry.Diff = diffServers(entry.Servers, servers)
+	if n := len(entries); n > 0 {
+		last := entries[n-1]
+		entry.ID = last.ID + 1
+		entry.Diff.Settings = last.SettingsKey != settingsKey
+	}
+	if entry.Diff.Empty() {
+		return nil
+	}
+
+	entries = append(entries, entry)
+	if len(entries) > h.limit {
+		entries = entries[len(entries)-h.limit:]
+	}
+	return h.store.Save(ctx, entries)
+}
+
+// Paused reports whether syncs are paused, e.g. while an operator rolls back. Stores that
+// cannot record a pause are never paused.
+func (h *History) Paused(ctx context.Context) (bool, error) {
+	ps, ok := h.store.(PauseStore)
+	if !ok {
+		return false, nil
+	}
+	return ps.Paused(ctx)
+}
+
+// SetPaused pauses or resumes the syncs of every controller sharing the store.
+func (h *History) SetPaused(ctx context.Context, paused bool) error {
+	ps, ok := h.store.(PauseStore)
+	if !ok {
+		return errors.New("the history store cannot pause syncs")
+	}
+	return ps.SetPaused(ctx, paused)
+}
+
+// PauseStore is implemented by history stores that also hold the pause switch.
+type PauseStore interface {
+	Paused(ctx context.Context) (bool, error)
+	SetPaused(ctx context.Context, paused bool) error
+}
+
+// diffServers compares the servers by name on the fields every client reads back, so
+// servers written from a snapshot compare equal to it.
+func diffServers(prev, next []BackendServer) HistoryDiff {
+	old := make(map[string]BackendServer, len(prev))
+	for _, s := range prev {
+		old[s.Name] = s
+	}
+
+	var d HistoryDiff
+	for _, s := range next {
+		o, ok := old[s.Name]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, s.Name)
+		case diffServer(o) != diffServer(s):
+			d.Changed = append(d.Changed, s.Name)
+		}
+		delete(old, s.Name)
+	}
+	for name := range old {
+		d.Removed = append(d.Removed, name)
+	}
+	sort.Strings(d.Removed)
+	return d
+}
+
+// diffFields are the fields of a server the diff compares.
+type diffFields struct {
+	target string
+	weight int
+	maint  bool
+}
+
+// diffServer keeps the address, port, weight and maintenance state of a server.
+func diffServer(s BackendServer) diffFields {
+	// Clients report the resolved address, or none, for FQDN servers.
+	return diffFields{target: slotTarget(s.FQDN, s.Address, s.Port), weight: s.Weight, maint: s.State == ServerStateMaint}
+}
+
+func historyServer(s BackendServer) BackendServer {
+	s.NodeName = ""
+	s.ReadyEndpoints, s.TerminatingEndpoints, s.NotReadyEndpoints = 0, 0, 0
+	return s
+}
+
+// Restore writes the backend of a history entry back through a transaction. With a History
+// configured the restore is recorded as a new entry.
+func (s *Syncer) Restore(ctx context.Context, entry HistoryEntry) error {
+	snapshots, ok := s.client.(SnapshotClient)
+	if !ok || len(entry.Data) == 0 {
+		return fmt.Errorf("entry %d has no backend snapshot to restore", entry.ID)
+	}
+	s.seedOwnership(ctx)
+
+	var before *BackendSnapshot
+	if s.opts.History != nil {
+		var err error
+		if before, err = snapshots.SnapshotBackend(ctx, serverNames(entry.Servers), entry.Resolvers); err != nil {
+			log.Printf("saving backend for history: %v", err)
+		}
+	}
+	if err := s.restoreSnapshot(ctx, &entry.BackendSnapshot); err != nil {
+		return err
+	}
+	if before != nil {
+		s.record(ctx, before, entry.Servers, "")
+	}
+	return nil
+}
+
+// record adds a commit to the history; failures are only logged so a broken store does not
+// block syncs.
+func (s *Syncer) record(ctx context.Context, before *BackendSnapshot, servers []BackendServer, settingsKey string) {
+	if err := s.opts.History.Record(ctx, before, servers, settingsKey); err != nil {
+		log.Printf("recording configuration history: %v", err)
+	}
+}
+
+func serverNames(servers []BackendServer) []string {
+	names := make([]string, len(servers))
+	for i, srv := range servers {
+		names[i] = srv.Name
+	}
+	return names
+}
+
+// FileHistoryStore keeps the history as JSON in a local file.
+type FileHistoryStore struct {
+	Path string
+}
+
+// Load reads the entries; a missing file is an empty history.
+func (f FileHistoryStore) Load(_ context.Context) ([]HistoryEntry, error) {
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	return DecodeHistory(data)
+}
+
+// Save replaces the file atomically.
+func (f FileHistoryStore) Save(_ context.Context, entries []HistoryEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode history: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), "."+filepath.Base(f.Path)+".")
+	if err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write history: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.Path); err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	return nil
+}
+
+// Paused reports whether the pause marker next to the history file exists.
+func (f FileHistoryStore) Paused(_ context.Context) (bool, error) {
+	_, err := os.Stat(f.pauseMarker())
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("read pause marker: %w", err)
+	}
+	return true, nil
+}
+
+// SetPaused creates or removes the pause marker.
+func (f FileHistoryStore) SetPaused(_ context.Context, paused bool) error {
+	if !paused {
+		if err := os.Remove(f.pauseMarker()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove pause marker: %w", err)
+		}
+		return nil
+	}
+	if err := os.WriteFile(f.pauseMarker(), nil, 0o644); err != nil {
+		return fmt.Errorf("write pause marker: %w", err)
+	}
+	return nil
+}
+
+func (f FileHistoryStore) pauseMarker() string {
+	return f.Path + ".paused"
+}
+
+// DecodeHistory parses entries as written by the history stores.
+func DecodeHistory(data []byte) ([]HistoryEntry, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var entries []HistoryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("decode history: %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,133 @@
+package haproxy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHistoryRecord(t *testing.T) {
+	ctx := context.Background()
+	store := FileHistoryStore{Path: filepath.Join(t.TempDir(), "history.json")}
+	h := NewHistory(store, 3)
+
+	a := BackendServer{Name: "a", Address: "10.0.0.1", Port: 443, Weight: 1, ReadyEndpoints: 1}
+	b := BackendServer{Name: "b", Address: "10.0.0.2", Port: 443, Weight: 1}
+	steps := []struct {
+		servers  []BackendServer
+		settings string
+	}{
+		{[]BackendServer{a, b}, "s1"},
+		// Only runtime counters differ: not recorded.
+		{[]BackendServer{{Name: "a", Address: "10.0.0.1", Port: 443, Weight: 1, ReadyEndpoints: 3}, b}, "s1"},
+		{[]BackendServer{{Name: "a", Address: "10.0.0.1", Port: 443, Weight: 2}}, "s1"},
+		{[]BackendServer{a}, "s2"},
+		{[]BackendServer{a, b}, "s2"},
+	}
+	// Each entry saves the servers as they were before its commit.
+	var before []BackendServer
+	for i, step := range steps {
+		snapshot := &BackendSnapshot{Version: int64(10 + i), Servers: before, Data: []byte(`{}`)}
+		if err := h.Record(ctx, snapshot, step.servers, step.settings); err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		before = step.servers
+	}
+
+	entries, err := h.Entries(ctx)
+	if err != nil {
+		t.Fatalf("entries: %v", err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Diff.String())
+	}
+	// Four distinct commits, the oldest dropped by the limit. IDs count recorded entries,
+	// versions are the ones the snapshots were read at.
+	expected := []string{"+0 -1 ~1 servers", "+0 -0 ~1 servers, backend settings", "+1 -0 ~0 servers"}
+	if len(entries) != 3 || entries[0].ID != 2 || entries[2].ID != 4 || entries[0].Version != 12 || strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected history %v: %+v", got, entries)
+	}
+	if entries[0].Diff.Removed[0] != "b" || entries[2].Diff.Added[0] != "b" {
+		t.Fatalf("unexpected diff names: %+v", entries)
+	}
+
+	if _, err := h.Find(ctx, 1); err == nil {
+		t.Fatalf("expected trimmed entry to be missing")
+	}
+	// Entry 3 saved the backend before the weight of a went back to 1.
+	entry, err := h.Find(ctx, 3)
+	if err != nil || len(entry.Servers) != 1 || entry.Servers[0].Weight != 2 {
+		t.Fatalf("unexpected entry %+v: %v", entry, err)
+	}
+
+	data, err := os.ReadFile(store.Path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if strings.Contains(string(data), `"ReadyEndpoints"`) {
+		t.Fatalf("stored history contains runtime fields: %s", data)
+	}
+}
+
+func TestSyncerRecordsAndRestoresHistory(t *testing.T) {
+	ctx := context.Background()
+	client := &snapshotStub{}
+	h := NewHistory(FileHistoryStore{Path: filepath.Join(t.TempDir(), "history.json")}, 0)
+	s := NewSyncerWithOptions(client, SyncerOptions{History: h})
+
+	good := []BackendServer{{Name: "a", Address: "10.0.0.1", Port: 443, Weight: 1}}
+	if err := s.SyncBackends(ctx, good, BackendSettings{}); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if err := s.SyncBackends(ctx, []BackendServer{{Name: "b", Address: "10.0.0.2", Port: 443, Weight: 1}}, BackendSettings{}); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	// Entry 2 holds the backend as it was before the second sync replaced a with b.
+	entry, err := h.Find(ctx, 2)
+	if err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	if len(entry.Servers) != 1 || entry.Servers[0].Name != "a" || entry.Diff.String() != "+1 -1 ~0 servers" {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+	if err := s.Restore(ctx, entry); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if client.restored == nil || len(client.backends) != 1 || client.backends[0].Name != "a" || client.committed != 3 {
+		t.Fatalf("expected entry 2 to be restored, got %+v", client.backends)
+	}
+	if entries, _ := h.Entries(ctx); len(entries) != 3 || entries[2].Servers[0].Name != "b" || entries[2].Diff.Added[0] != "a" {
+		t.Fatalf("expected the restore to be recorded, got %+v", entries)
+	}
+}
+
+func TestSyncerHonoursPause(t *testing.T) {
+	ctx := context.Background()
+	client := &snapshotStub{}
+	h := NewHistory(FileHistoryStore{Path: filepath.Join(t.TempDir(), "history.json")}, 0)
+	s := NewSyncerWithOptions(client, SyncerOptions{History: h})
+
+	if err := h.SetPaused(ctx, true); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	if err := s.SyncBackends(ctx, []BackendServer{{Name: "a"}}, BackendSettings{}); err != nil || client.committed != 0 {
+		t.Fatalf("expected a paused sync to commit nothing, got %v after %d commits", err, client.committed)
+	}
+	if next, ok := s.NextSync(); !ok || next != pausePoll {
+		t.Fatalf("expected a paused syncer to check the switch again, got %v %v", next, ok)
+	}
+
+	if err := h.SetPaused(ctx, false); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if err := s.SyncBackends(ctx, []BackendServer{{Name: "a"}}, BackendSettings{}); err != nil || client.committed != 1 {
+		t.Fatalf("expected a resumed sync to commit, got %v after %d commits", err, client.committed)
+	}
+	if _, ok := s.NextSync(); ok {
+		t.Fatalf("expected no further sync after resuming")
+	}
+}
@@ -1,18 +1,98 @@
 package haproxy
 
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+)
+
 // BackendServer represents a single HAProxy backend server entry.
 type BackendServer struct {
 	Name    string
-	Address string
-	Port    int32
-	Weight  int
-	Check   bool
+	Address string `json:",omitempty"`
+	Port    int32  `json:",omitempty"`
+	Weight  int    `json:",omitempty"`
+	Check   bool   `json:",omitempty"`
+	// NodeName is the Kubernetes Node the server address belongs to, if known.
+	NodeName string `json:",omitempty"`
+	// ReadyEndpoints counts the ready endpoints collapsed into this server.
+	ReadyEndpoints int `json:",omitempty"`
+	// TerminatingEndpoints and NotReadyEndpoints are only counted when agent checks are enabled,
+	// which keeps servers without ready endpoints so the agent can report them.
+	TerminatingEndpoints int `json:",omitempty"`
+	NotReadyEndpoints    int `json:",omitempty"`
+	// State is the requested administrative state; empty means ready.
+	State   ServerState `json:",omitempty"`
+	Backup  bool        `json:",omitempty"`
+	MaxConn int         `json:",omitempty"`
+	// Cookie is the persistence cookie value identifying this server.
+	Cookie string `json:",omitempty"`
+	// FQDN, when set, replaces Address and makes HAProxy resolve the server through Resolvers.
+	FQDN          string `json:",omitempty"`
+	Resolvers     string `json:",omitempty"`
+	ResolvePrefer string `json:",omitempty"`
+	InitAddr      string `json:",omitempty"`
+	// Options are extra server parameters from the server template; typed fields above take precedence.
+	Options map[string]any `json:",omitempty"`
+	// Agent, when set, points the server's agent-check at the embedded agent server.
+	Agent *AgentConfig `json:",omitempty"`
 }
 
-// HealthCheckConfig holds basic health check configuration for HAProxy backends.
+// CheckType selects the health check protocol.
+type CheckType string
+
+const (
+	// CheckTCP performs a plain connect check (tcp-check).
+	CheckTCP CheckType = "tcp"
+	// CheckHTTP sends an HTTP request (option httpchk) and matches the response status.
+	CheckHTTP CheckType = "http"
+)
+
+// HealthCheckConfig holds health check configuration for HAProxy backends.
 type HealthCheckConfig struct {
-	IntervalSeconds int
-	RiseCount       int
-	FallCount       int
-	SendProxyV2     bool
+	Type CheckType
+	// HTTPMethod, HTTPPath, HTTPHost and HTTPExpectStatus apply to CheckHTTP only.
+	// HTTPExpectStatus is a status code, or a regular expression matched against it.
+	HTTPMethod       string
+	HTTPPath         string
+	HTTPHost         string
+	HTTPExpectStatus string
+	Interval         time.Duration
+	FastInterval     time.Duration
+	DownInterval     time.Duration
+	RiseCount        int
+	FallCount        int
+	// Port sends checks to a different port than traffic if > 0.
+	Port int32
+	SSL  bool
+}
+
+// ResolversConfig describes a HAProxy resolvers section managed by the controller.
+type ResolversConfig struct {
+	Name string
+	// Nameservers are host:port pairs; the section is left untouched when empty.
+	Nameservers []string
+	// HoldValidSeconds is how long a valid answer is kept.
+	HoldValidSeconds int
+}
+
+// DNSConfig switches servers from literal node IPs to DNS names resolved by HAProxy.
+type DNSConfig struct {
+	Enabled bool
+	// Template builds a server FQDN; "{node}" is replaced by the Kubernetes node name.
+	Template      string
+	Resolvers     ResolversConfig
+	ResolvePrefer string
+	InitAddr      string
+}
+
+// ClusterState is the Kubernetes view a single reconcile is computed from.
+// Only the sources relevant to the configured ingress mode are populated.
+type ClusterState struct {
+	EndpointSlices []*discoveryv1.EndpointSlice
+	Endpoints      []*corev1.Endpoints
+	Pods           []*corev1.Pod
+	Nodes          []*corev1.Node
+	NodeIPs        map[string]string
 }
@@ -0,0 +1,128 @@
+package haproxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Node annotations operators can set to override the servers generated for that node.
+const (
+	AnnotationState   = "haproxy-sync/state"
+	AnnotationBackup  = "haproxy-sync/backup"
+	AnnotationWeight  = "haproxy-sync/weight"
+	AnnotationMaxConn = "haproxy-sync/maxconn"
+)
+
+// ServerState is the administrative state requested for a server.
+type ServerState string
+
+const (
+	// ServerStateReady leaves the server in normal rotation.
+	ServerStateReady ServerState = "ready"
+	// ServerStateDrain stops new traffic while existing sessions finish.
+	ServerStateDrain ServerState = "drain"
+	// ServerStateMaint takes the server out of rotation.
+	ServerStateMaint ServerState = "maint"
+)
+
+// NodeAnnotationError reports an override annotation on a Node that could not be applied.
+type NodeAnnotationError struct {
+	Node  *corev1.Node
+	Key   string
+	Value string
+	Err   error
+}
+
+func (e NodeAnnotationError) Error() string {
+	return fmt.Sprintf("node %s annotation %s=%q: %v", e.Node.Name, e.Key, e.Value, e.Err)
+}
+
+// ApplyNodeOverrides applies the haproxy-sync/* annotations of each node to the servers
+// on that node. Invalid values are skipped and returned so they can be surfaced to operators.
+func ApplyNodeOverrides(servers []BackendServer, nodes []*corev1.Node) ([]BackendServer, []NodeAnnotationError) {
+	var problems []NodeAnnotationError
+
+	byName := make(map[string]nodeOverride, len(nodes))
+	for _, n := range nodes {
+		o, errs := parseNodeOverride(n)
+		problems = append(problems, errs...)
+		byName[n.Name] = o
+	}
+
+	for i := range servers {
+		o, ok := byName[servers[i].NodeName]
+		if !ok || servers[i].NodeName == "" {
+			continue
+		}
+		if o.state != "" {
+			servers[i].State = o.state
+		}
+		if o.backup != nil {
+			servers[i].Backup = *o.backup
+		}
+		if o.weight != nil {
+			servers[i].Weight = *o.weight
+		}
+		if o.maxConn != nil {
+			servers[i].MaxConn = *o.maxConn
+		}
+	}
+
+	return servers, problems
+}
+
+type nodeOverride struct {
+	state   ServerState
+	backup  *bool
+	weight  *int
+	maxConn *int
+}
+
+func parseNodeOverride(n *corev1.Node) (nodeOverride, []NodeAnnotationError) {
+	var o nodeOverride
+	var problems []NodeAnnotationError
+	fail := func(key, value string, err error) {
+		problems = append(problems, NodeAnnotationError{Node: n, Key: key, Value: value, Err: err})
+	}
+
+	if v, ok := n.Annotations[AnnotationState]; ok {
+		switch s := ServerState(strings.ToLower(strings.TrimSpace(v))); s {
+		case ServerStateReady, ServerStateDrain, ServerStateMaint:
+			o.state = s
+		default:
+			fail(AnnotationState, v, fmt.Errorf("expected one of %s, %s, %s", ServerStateReady, ServerStateDrain, ServerStateMaint))
+		}
+	}
+
+	if v, ok := n.Annotations[AnnotationBackup]; ok {
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			fail(AnnotationBackup, v, fmt.Errorf("expected a boolean"))
+		} else {
+			o.backup = &b
+		}
+	}
+
+	if v, ok := n.Annotations[AnnotationWeight]; ok {
+		w, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || w < 0 || w > maxHAProxyWeight {
+			fail(AnnotationWeight, v, fmt.Errorf("expected an integer between 0 and %d", maxHAProxyWeight))
+		} else {
+			o.weight = &w
+		}
+	}
+
+	if v, ok := n.Annotations[AnnotationMaxConn]; ok {
+		m, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || m < 0 {
+			fail(AnnotationMaxConn, v, fmt.Errorf("expected a non-negative integer"))
+		} else {
+			o.maxConn = &m
+		}
+	}
+
+	return o, problems
+}
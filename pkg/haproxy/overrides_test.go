@@ -0,0 +1,77 @@
+package haproxy
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestApplyNodeOverrides(t *testing.T) {
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "spot", Annotations: map[string]string{
+			AnnotationState:   "drain",
+			AnnotationBackup:  "true",
+			AnnotationWeight:  "5",
+			AnnotationMaxConn: "200",
+		}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "broken", Annotations: map[string]string{
+			AnnotationState:  "sleeping",
+			AnnotationWeight: "1000",
+		}}},
+	}
+	servers := []BackendServer{
+		{Name: "spot-443", NodeName: "spot", Weight: 1},
+		{Name: "broken-443", NodeName: "broken", Weight: 1},
+		{Name: "10.0.0.1-443", Weight: 1},
+	}
+
+	servers, problems := ApplyNodeOverrides(servers, nodes)
+
+	spot := servers[0]
+	if spot.State != ServerStateDrain || !spot.Backup || spot.Weight != 5 || spot.MaxConn != 200 {
+		t.Fatalf("overrides not applied to spot server: %+v", spot)
+	}
+	if servers[1].State != "" || servers[1].Weight != 1 {
+		t.Fatalf("invalid annotations must not change server: %+v", servers[1])
+	}
+	if len(problems) != 2 {
+		t.Fatalf("expected 2 annotation problems, got %d: %v", len(problems), problems)
+	}
+}
+
+func TestServerPayloadReflectsState(t *testing.T) {
+	drain := newServerModel(BackendServer{Name: "a", Weight: 10, State: ServerStateDrain})
+	if *drain.Weight != 0 || drain.Maintenance != "" {
+		t.Fatalf("expected drain to zero the weight, got %+v", drain)
+	}
+	maint := newServerModel(BackendServer{Name: "b", Weight: 10, State: ServerStateMaint, Backup: true})
+	if maint.Maintenance != "enabled" || maint.Backup != "enabled" || *maint.Weight != 10 {
+		t.Fatalf("unexpected maint payload: %+v", maint)
+	}
+}
+
+func TestSyncRecordsInvalidAnnotationEvents(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	client := &stubClient{}
+	s := NewSyncerWithOptions(client, SyncerOptions{Recorder: recorder})
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Annotations: map[string]string{AnnotationMaxConn: "-1"}}}
+	state := singleEndpointState("worker-1")
+	state.Nodes = []*corev1.Node{node}
+	if err := s.Sync(context.Background(), state); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+
+	select {
+	case ev := <-recorder.Events:
+		if !strings.Contains(ev, "InvalidAnnotation") || !strings.Contains(ev, AnnotationMaxConn) {
+			t.Fatalf("unexpected event: %s", ev)
+		}
+	default:
+		t.Fatalf("expected an event for the invalid annotation")
+	}
+}
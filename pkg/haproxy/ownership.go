@@ -0,0 +1,111 @@
+package haproxy
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// ServerOwner is implemented by clients that delete only the servers the controller created.
+// Other servers in the backend, such as hand-added backup or sorry servers, are kept.
+type ServerOwner interface {
+	// OwnServers marks names as created by the controller, e.g. from the history after a restart.
+	OwnServers(names []string)
+}
+
+// serverOwnership records the names of the servers the controller created. Changes made in a
+// transaction take effect when it commits.
+type serverOwnership struct {
+	mu      sync.Mutex
+	owned   map[string]struct{}
+	pending map[string]ownershipChange
+}
+
+type ownershipChange struct {
+	added, deleted []string
+}
+
+func (o *serverOwnership) own(names []string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.ownLocked(names)
+}
+
+func (o *serverOwnership) ownLocked(names []string) {
+	if o.owned == nil {
+		o.owned = make(map[string]struct{}, len(names))
+	}
+	for _, name := range names {
+		o.owned[name] = struct{}{}
+	}
+}
+
+func (o *serverOwnership) release(names []string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, name := range names {
+		delete(o.owned, name)
+	}
+}
+
+func (o *serverOwnership) owns(name string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, ok := o.owned[name]
+	return ok
+}
+
+// stage records the servers a transaction writes and deletes.
+func (o *serverOwnership) stage(transactionID string, added, deleted []string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.pending == nil {
+		o.pending = make(map[string]ownershipChange)
+	}
+	o.pending[transactionID] = ownershipChange{added: added, deleted: deleted}
+}
+
+// settle applies the changes staged in a transaction once it committed, or drops them.
+func (o *serverOwnership) settle(transactionID string, committed bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	change, ok := o.pending[transactionID]
+	delete(o.pending, transactionID)
+	if !ok || !committed {
+		return
+	}
+	for _, name := range change.deleted {
+		delete(o.owned, name)
+	}
+	o.ownLocked(change.added)
+}
+
+// seedOwnership hands the servers recorded in the history to a ServerOwner client once, so
+// servers created before a restart are still deleted when they are no longer desired: the
+// managed servers of each snapshot and the ones the commit after it added. A failing store
+// is retried on the next sync.
+func (s *Syncer) seedOwnership(ctx context.Context) {
+	owner, ok := s.client.(ServerOwner)
+	if !ok || s.opts.History == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ownershipSeeded {
+		return
+	}
+	entries, err := s.opts.History.Entries(ctx)
+	if err != nil {
+		log.Printf("reading history for server ownership: %v", err)
+		return
+	}
+	var names []string
+	for _, e := range entries {
+		for _, srv := range e.Servers {
+			names = append(names, srv.Name)
+		}
+		names = append(names, e.Diff.Added...)
+	}
+	owner.OwnServers(names)
+	s.ownershipSeeded = true
+}
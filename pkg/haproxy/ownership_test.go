@@ -0,0 +1,60 @@
+package haproxy
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestServerOwnershipFollowsCommits(t *testing.T) {
+	var o serverOwnership
+	o.own([]string{"worker-1-443", "worker-2-443"})
+
+	o.stage("tx1", []string{"worker-3-443"}, []string{"worker-2-443"})
+	o.settle("tx1", false)
+	if !o.owns("worker-2-443") || o.owns("worker-3-443") {
+		t.Fatalf("expected an aborted transaction to change nothing")
+	}
+
+	o.stage("tx2", []string{"worker-3-443"}, []string{"worker-2-443"})
+	o.settle("tx2", true)
+	if !o.owns("worker-1-443") || o.owns("worker-2-443") || !o.owns("worker-3-443") {
+		t.Fatalf("expected the committed changes applied, got %v", o.owned)
+	}
+}
+
+type ownerStub struct {
+	stubClient
+	owned []string
+}
+
+func (c *ownerStub) OwnServers(names []string) { c.owned = append(c.owned, names...) }
+
+func TestSyncSeedsOwnershipFromHistory(t *testing.T) {
+	ctx := context.Background()
+	history := NewHistory(FileHistoryStore{Path: filepath.Join(t.TempDir(), "history.json")}, 0)
+	first := []BackendServer{{Name: "worker-1-443"}, {Name: "worker-2-443"}}
+	if err := history.Record(ctx, &BackendSnapshot{}, first, ""); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if err := history.Record(ctx, &BackendSnapshot{Servers: first}, []BackendServer{{Name: "worker-1-443"}, {Name: "worker-3-443"}}, ""); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+
+	client := &ownerStub{}
+	s := NewSyncerWithOptions(client, SyncerOptions{History: history})
+	for i := 0; i < 2; i++ {
+		if err := s.Sync(ctx, singleEndpointState("worker-1")); err != nil {
+			t.Fatalf("sync: %v", err)
+		}
+	}
+
+	// Seeded once, with every server the snapshots saved or the commits added.
+	sort.Strings(client.owned)
+	expected := []string{"worker-1-443", "worker-1-443", "worker-2-443", "worker-2-443", "worker-3-443"}
+	if !reflect.DeepEqual(client.owned, expected) {
+		t.Fatalf("unexpected owned servers %v", client.owned)
+	}
+}
@@ -0,0 +1,84 @@
+package haproxy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ProxyProtocolVersion selects the PROXY protocol header sent to backend servers.
+type ProxyProtocolVersion string
+
+const (
+	// ProxyNone sends no PROXY protocol header.
+	ProxyNone ProxyProtocolVersion = "none"
+	// ProxyV1 sends the text header (send-proxy).
+	ProxyV1 ProxyProtocolVersion = "v1"
+	// ProxyV2 sends the binary header (send-proxy-v2), optionally with TLVs from V2Options.
+	ProxyV2 ProxyProtocolVersion = "v2"
+)
+
+// proxyV2Options are the TLVs HAProxy can add to a PROXY protocol v2 header.
+var proxyV2Options = map[string]struct{}{
+	"ssl": {}, "cert-cn": {}, "ssl-cipher": {}, "cert-sig": {}, "cert-key": {},
+	"authority": {}, "crc32c": {}, "unique-id": {},
+}
+
+// ProxyProtocolConfig configures PROXY protocol towards the ingress servers.
+type ProxyProtocolConfig struct {
+	Version ProxyProtocolVersion
+	// V2Options lists proxy-v2-options TLVs such as ssl, authority or unique-id; v2 only.
+	V2Options []string
+	// CheckSendProxy also sends the header on health checks (check-send-proxy).
+	CheckSendProxy bool
+}
+
+// ParseProxyProtocolVersion maps a configuration string to a ProxyProtocolVersion; empty input means none.
+func ParseProxyProtocolVersion(v string) (ProxyProtocolVersion, error) {
+	switch version := ProxyProtocolVersion(strings.ToLower(strings.TrimSpace(v))); version {
+	case "":
+		return ProxyNone, nil
+	case ProxyNone, ProxyV1, ProxyV2:
+		return version, nil
+	default:
+		return "", fmt.Errorf("unknown PROXY protocol version %q: expected none, v1 or v2", v)
+	}
+}
+
+// Validate checks that options are only used with a version that supports them.
+func (p ProxyProtocolConfig) Validate() error {
+	if _, err := ParseProxyProtocolVersion(string(p.Version)); err != nil {
+		return err
+	}
+	if len(p.V2Options) > 0 && p.Version != ProxyV2 {
+		return fmt.Errorf("proxy-v2-options require PROXY protocol v2")
+	}
+	for _, opt := range p.V2Options {
+		if _, ok := proxyV2Options[opt]; !ok {
+			return fmt.Errorf("unknown proxy-v2-options value %q", opt)
+		}
+	}
+	if p.CheckSendProxy && !p.enabled() {
+		return fmt.Errorf("check-send-proxy requires PROXY protocol v1 or v2")
+	}
+	return nil
+}
+
+func (p ProxyProtocolConfig) enabled() bool {
+	return p.Version == ProxyV1 || p.Version == ProxyV2
+}
+
+// applyProxyProtocol sets the Data Plane v3 server parameters for p on ds.
+func applyProxyProtocol(ds *serverParams, p ProxyProtocolConfig) {
+	switch p.Version {
+	case ProxyV1:
+		ds.SendProxy = enabled
+	case ProxyV2:
+		ds.SendProxyV2 = enabled
+		if len(p.V2Options) > 0 {
+			ds.ProxyV2Options = append([]string(nil), p.V2Options...)
+		}
+	}
+	if p.CheckSendProxy && p.enabled() {
+		ds.CheckSendProxy = enabled
+	}
+}
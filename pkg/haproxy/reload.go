@@ -0,0 +1,80 @@
+package haproxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// DefaultReloadTimeout bounds the wait for an HAProxy reload after a commit.
+const DefaultReloadTimeout = 2 * time.Minute
+
+const defaultReloadPollInterval = time.Second
+
+// Reload states reported by the Data Plane API.
+const (
+	reloadInProgress = "in_progress"
+	reloadSucceeded  = "succeeded"
+	reloadFailed     = "failed"
+)
+
+// ReloadError reports an HAProxy reload that failed after the transaction was committed.
+// The configuration on disk already contains the change, but the running HAProxy does not.
+type ReloadError struct {
+	ID string
+	// Response is the reload output from the Data Plane API, usually HAProxy's error message.
+	Response string
+}
+
+func (e *ReloadError) Error() string {
+	return fmt.Sprintf("HAProxy reload %s failed: %s", e.ID, e.Response)
+}
+
+type reloadPayload struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Response string `json:"response"`
+}
+
+// waitForReload polls the reload until it succeeded or failed. Without a reloads endpoint the
+// commit is trusted.
+func (c *DataPlaneClient) waitForReload(ctx context.Context, d dialect, id string) error {
+	if c.noReloadTracking.Load() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.reloadTimeout)
+	defer cancel()
+	ticker := time.NewTicker(c.reloadPollInterval)
+	defer ticker.Stop()
+
+	for {
+		var reload reloadPayload
+		if err := c.doRequest(ctx, http.MethodGet, d.path("services/haproxy/reloads", id), nil, nil, &reload); err != nil {
+			return fmt.Errorf("reload %s: %w", id, err)
+		}
+		switch reload.Status {
+		case reloadSucceeded:
+			return nil
+		case reloadFailed:
+			c.reloadFailures.Add(1)
+			log.Printf("HAProxy reload %s failed: %s", id, reload.Response)
+			return &ReloadError{ID: id, Response: reload.Response}
+		case reloadInProgress:
+		default:
+			log.Printf("HAProxy reload %s has unknown status %q, waiting", id, reload.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("reload %s still %s: %w", id, reload.Status, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReloadFailures returns how many HAProxy reloads failed after a commit.
+func (c *DataPlaneClient) ReloadFailures() int64 {
+	return c.reloadFailures.Load()
+}
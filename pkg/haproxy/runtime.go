@@ -0,0 +1,615 @@
+package haproxy
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultRuntimeTimeout = 5 * time.Second
+
+// RuntimeOptions configures a RuntimeClient.
+type RuntimeOptions struct {
+	// SlotPrefix selects slot mode: servers whose name starts with it were declared with
+	// server-template in haproxy.cfg and are assigned to endpoints instead of being added
+	// and deleted. Empty uses dynamic servers (add/del server, HAProxy 2.6+).
+	SlotPrefix string
+	// Timeout bounds each command; 0 means 5s.
+	Timeout time.Duration
+}
+
+// RuntimeClient implements Client over the HAProxy Runtime API (stats socket) for hosts
+// without the Data Plane API. The backend must be declared in haproxy.cfg; only its servers
+// are managed. Changes are buffered per transaction and sent as runtime commands on commit.
+type RuntimeClient struct {
+	network     string
+	address     string
+	backendName string
+	opts        RuntimeOptions
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[string][]BackendServer
+	// slots maps slot names to the desired server names assigned to them in slot mode.
+	slots map[string]string
+
+	// owned records the dynamic servers the controller added, the only ones it deletes.
+	owned serverOwnership
+
+	settingsOnce  sync.Once
+	resolversOnce sync.Once
+}
+
+// NewRuntimeClient creates a RuntimeClient for the socket at addr: "unix:///path", a plain
+// path, "tcp://host:port" or "host:port".
+func NewRuntimeClient(addr, backendName string, opts RuntimeOptions) (*RuntimeClient, error) {
+	network, address, err := parseSocketAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultRuntimeTimeout
+	}
+	return &RuntimeClient{
+		network:     network,
+		address:     address,
+		backendName: backendName,
+		opts:        opts,
+		pending:     make(map[string][]BackendServer),
+		slots:       make(map[string]string),
+	}, nil
+}
+
+func parseSocketAddr(addr string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		return "unix", strings.TrimPrefix(addr, "unix://"), nil
+	case strings.HasPrefix(addr, "/"):
+		return "unix", addr, nil
+	case strings.HasPrefix(addr, "tcp://"):
+		addr = strings.TrimPrefix(addr, "tcp://")
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", "", fmt.Errorf("invalid runtime socket address %q: %w", addr, err)
+	}
+	return "tcp", addr, nil
+}
+
+// BeginTransaction starts buffering changes.
+func (c *RuntimeClient) BeginTransaction(_ context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	id := fmt.Sprintf("runtime-%d", c.nextID)
+	c.pending[id] = nil
+	return id, nil
+}
+
+// CommitTransaction sends the buffered server changes to HAProxy. The Runtime API has no
+// transactions, so a failure part-way leaves the earlier commands applied; the next sync
+// converges from the state HAProxy reports.
+func (c *RuntimeClient) CommitTransaction(ctx context.Context, transactionID string) error {
+	c.mu.Lock()
+	servers, ok := c.pending[transactionID]
+	delete(c.pending, transactionID)
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("commit transaction: unknown transaction %q", transactionID)
+	}
+	if servers == nil {
+		return nil
+	}
+	if c.opts.SlotPrefix != "" {
+		return c.applySlots(ctx, servers)
+	}
+	return c.applyDynamic(ctx, servers)
+}
+
+// AbortTransaction discards the buffered changes.
+func (c *RuntimeClient) AbortTransaction(_ context.Context, transactionID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, transactionID)
+	return nil
+}
+
+// UpdateBackendsInTransaction records the desired servers.
+func (c *RuntimeClient) UpdateBackendsInTransaction(_ context.Context, transactionID string, backends []BackendServer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.pending[transactionID]; !ok {
+		return fmt.Errorf("unknown transaction %q", transactionID)
+	}
+	c.pending[transactionID] = append([]BackendServer{}, backends...)
+	return nil
+}
+
+// UpdateBackendSettingsInTransaction is a no-op: balance, checks, persistence and
+// default-server options cannot be changed through the Runtime API.
+func (c *RuntimeClient) UpdateBackendSettingsInTransaction(_ context.Context, _ string, _ BackendSettings) error {
+	c.settingsOnce.Do(func() {
+		log.Printf("runtime API: backend settings are not managed, declare them for backend %s in haproxy.cfg", c.backendName)
+	})
+	return nil
+}
+
+// EnsureResolversInTransaction is a no-op: the resolvers section must be declared in haproxy.cfg.
+func (c *RuntimeClient) EnsureResolversInTransaction(_ context.Context, _ string, config ResolversConfig) error {
+	c.resolversOnce.Do(func() {
+		log.Printf("runtime API: resolvers are not managed, declare resolvers %s in haproxy.cfg", config.Name)
+	})
+	return nil
+}
+
+// runtimeServer is one line of "show servers state".
+type runtimeServer struct {
+	ID         int
+	Name       string
+	Addr       string
+	Port       int32
+	FQDN       string
+	AdminState int
+	CheckState int
+	Weight     int
+}
+
+// Bits of srv_admin_state and srv_check_state in "show servers state".
+const (
+	adminForcedMaint  = 0x01
+	adminForcedDrain  = 0x08
+	checkStateEnabled = 0x04
+)
+
+func (c *RuntimeClient) applyDynamic(ctx context.Context, servers []BackendServer) error {
+	current, err := c.serversState(ctx)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]runtimeServer, len(current))
+	for _, s := range current {
+		existing[s.Name] = s
+	}
+
+	desired := make(map[string]struct{}, len(servers))
+	for _, srv := range servers {
+		desired[srv.Name] = struct{}{}
+		if cur, ok := existing[srv.Name]; ok {
+			if err := c.updateServer(ctx, srv.Name, srv, cur); err != nil {
+				return err
+			}
+		} else if err := c.addServer(ctx, srv); err != nil {
+			return err
+		}
+		c.owned.own([]string{srv.Name})
+	}
+
+	for _, cur := range current {
+		if _, ok := desired[cur.Name]; ok || !c.owned.owns(cur.Name) {
+			continue
+		}
+		if err := c.deleteServer(ctx, cur.Name); err != nil {
+			return err
+		}
+		c.owned.release([]string{cur.Name})
+	}
+	return nil
+}
+
+// OwnServers marks names as dynamic servers the controller added, which it deletes once they
+// are no longer desired. Servers it adds are owned automatically.
+func (c *RuntimeClient) OwnServers(names []string) {
+	c.owned.own(names)
+}
+
+// SnapshotBackend reads the managed servers from "show servers state": the dynamic servers
+// the controller owns or is about to write, or the slots in use in slot mode, under the name
+// of the server assigned to them when known. The backend settings are declared in haproxy.cfg
+// and not saved; FQDN servers are saved with resolvers.
+func (c *RuntimeClient) SnapshotBackend(ctx context.Context, servers []string, resolvers string) (*BackendSnapshot, error) {
+	current, err := c.serversState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	managed := make(map[string]bool, len(servers))
+	for _, name := range servers {
+		managed[name] = true
+	}
+	c.mu.Lock()
+	slots := maps.Clone(c.slots)
+	c.mu.Unlock()
+
+	var saved []BackendServer
+	for _, cur := range current {
+		name := cur.Name
+		if c.opts.SlotPrefix != "" {
+			if !strings.HasPrefix(cur.Name, c.opts.SlotPrefix) || (cur.AdminState&adminForcedMaint != 0 && cur.Addr == "0.0.0.0") {
+				continue
+			}
+			if assigned, ok := slots[cur.Name]; ok {
+				name = assigned
+			}
+		} else if !managed[name] && !c.owned.owns(name) {
+			continue
+		}
+		srv := BackendServer{
+			Name:    name,
+			Address: cur.Addr,
+			Port:    cur.Port,
+			Weight:  cur.Weight,
+			Check:   cur.CheckState&checkStateEnabled != 0,
+			State:   adminState(cur.AdminState),
+		}
+		if cur.FQDN != "" {
+			srv.FQDN, srv.Resolvers = cur.FQDN, resolvers
+		}
+		saved = append(saved, srv)
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return nil, err
+	}
+	return &BackendSnapshot{Servers: saved, Data: data}, nil
+}
+
+// RestoreBackendInTransaction buffers the saved servers, which the commit applies like a sync.
+func (c *RuntimeClient) RestoreBackendInTransaction(ctx context.Context, transactionID string, snapshot *BackendSnapshot) error {
+	var servers []BackendServer
+	if err := json.Unmarshal(snapshot.Data, &servers); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	return c.UpdateBackendsInTransaction(ctx, transactionID, servers)
+}
+
+// applySlots assigns servers to slots, keeping a server on the slot that already points at it.
+func (c *RuntimeClient) applySlots(ctx context.Context, servers []BackendServer) error {
+	current, err := c.serversState(ctx)
+	if err != nil {
+		return err
+	}
+	var slots []runtimeServer
+	for _, s := range current {
+		if strings.HasPrefix(s.Name, c.opts.SlotPrefix) {
+			slots = append(slots, s)
+		}
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].ID < slots[j].ID })
+	if len(servers) > len(slots) {
+		return fmt.Errorf("backend %s has %d server slots with prefix %q, %d servers needed", c.backendName, len(slots), c.opts.SlotPrefix, len(servers))
+	}
+
+	byTarget := make(map[string]string, len(slots))
+	for _, s := range slots {
+		if s.AdminState&adminForcedMaint == 0 || s.Addr != "0.0.0.0" {
+			byTarget[slotTarget(s.FQDN, s.Addr, s.Port)] = s.Name
+		}
+	}
+	assigned := make(map[string]BackendServer, len(servers))
+	var unplaced []BackendServer
+	for _, srv := range servers {
+		slot, ok := byTarget[slotTarget(srv.FQDN, srv.Address, srv.Port)]
+		if _, taken := assigned[slot]; ok && !taken {
+			assigned[slot] = srv
+			continue
+		}
+		unplaced = append(unplaced, srv)
+	}
+	for _, s := range slots {
+		if len(unplaced) == 0 {
+			break
+		}
+		if _, taken := assigned[s.Name]; taken {
+			continue
+		}
+		log.Printf("runtime API: assigning server %s to slot %s/%s", unplaced[0].Name, c.backendName, s.Name)
+		assigned[s.Name] = unplaced[0]
+		unplaced = unplaced[1:]
+	}
+
+	names := make(map[string]string, len(assigned))
+	for _, s := range slots {
+		srv, ok := assigned[s.Name]
+		if !ok {
+			if s.AdminState&adminForcedMaint == 0 {
+				if err := c.run(ctx, fmt.Sprintf("set server %s/%s state maint", c.backendName, s.Name), emptyReply); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		names[s.Name] = srv.Name
+		if err := c.updateServer(ctx, s.Name, srv, s); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	c.slots = names
+	c.mu.Unlock()
+	return nil
+}
+
+func slotTarget(fqdn, addr string, port int32) string {
+	if fqdn != "" {
+		addr = fqdn
+	}
+	return net.JoinHostPort(addr, strconv.Itoa(int(port)))
+}
+
+// updateServer points an existing server at srv and applies its weight, limits and state.
+func (c *RuntimeClient) updateServer(ctx context.Context, name string, srv BackendServer, cur runtimeServer) error {
+	target := c.backendName + "/" + name
+	var cmds []runtimeCommand
+	if srv.FQDN != "" {
+		if cur.FQDN != srv.FQDN {
+			cmds = append(cmds, runtimeCommand{fmt.Sprintf("set server %s fqdn %s", target, srv.FQDN), addrReply})
+		}
+	} else if cur.Addr != srv.Address || cur.Port != srv.Port {
+		cmds = append(cmds, runtimeCommand{fmt.Sprintf("set server %s addr %s port %d", target, srv.Address, srv.Port), addrReply})
+	}
+	if cur.Weight != srv.Weight {
+		cmds = append(cmds, runtimeCommand{fmt.Sprintf("set server %s weight %d", target, srv.Weight), emptyReply})
+	}
+	cmds = append(cmds, c.stateCommands(target, srv, cur)...)
+	return c.runAll(ctx, cmds)
+}
+
+func (c *RuntimeClient) addServer(ctx context.Context, srv BackendServer) error {
+	target := c.backendName + "/" + srv.Name
+	addr := srv.Address
+	if srv.FQDN != "" {
+		addr = srv.FQDN
+	}
+	args := []string{"add server", target, net.JoinHostPort(addr, strconv.Itoa(int(srv.Port))), "weight", strconv.Itoa(srv.Weight)}
+	if srv.Check {
+		args = append(args, "check")
+	}
+	if srv.Backup {
+		args = append(args, "backup")
+	}
+	if srv.Cookie != "" {
+		args = append(args, "cookie", srv.Cookie)
+	}
+	if srv.FQDN != "" {
+		for _, kv := range [][2]string{{"resolvers", srv.Resolvers}, {"resolve-prefer", srv.ResolvePrefer}, {"init-addr", srv.InitAddr}} {
+			// Restored snapshots only know the resolvers section.
+			if kv[1] != "" {
+				args = append(args, kv[0], kv[1])
+			}
+		}
+	}
+
+	// Dynamic servers start in maintenance with checks stopped.
+	cmds := []runtimeCommand{{strings.Join(args, " "), prefixReply("New server registered")}}
+	cmds = append(cmds, c.stateCommands(target, srv, runtimeServer{AdminState: adminForcedMaint})...)
+	return c.runAll(ctx, cmds)
+}
+
+// stateCommands enables checks and sets the administrative state where cur differs from srv.
+// maxconn is not part of "show servers state" and is always set.
+func (c *RuntimeClient) stateCommands(target string, srv BackendServer, cur runtimeServer) []runtimeCommand {
+	var cmds []runtimeCommand
+	if srv.MaxConn > 0 {
+		cmds = append(cmds, runtimeCommand{fmt.Sprintf("set maxconn server %s %d", target, srv.MaxConn), emptyReply})
+	}
+	if srv.Check && cur.CheckState&checkStateEnabled == 0 {
+		cmds = append(cmds, runtimeCommand{"enable health " + target, emptyReply})
+	}
+	state := srv.State
+	if state == "" {
+		state = ServerStateReady
+	}
+	if state != adminState(cur.AdminState) {
+		cmds = append(cmds, runtimeCommand{fmt.Sprintf("set server %s state %s", target, state), emptyReply})
+	}
+	return cmds
+}
+
+// adminState is the state "set server ... state" last forced, ignoring states inherited
+// from tracked servers or DNS resolution.
+func adminState(admin int) ServerState {
+	switch {
+	case admin&adminForcedMaint != 0:
+		return ServerStateMaint
+	case admin&adminForcedDrain != 0:
+		return ServerStateDrain
+	}
+	return ServerStateReady
+}
+
+// deleteServer drains a server out of the backend; HAProxy only deletes servers in
+// maintenance without sessions.
+func (c *RuntimeClient) deleteServer(ctx context.Context, name string) error {
+	target := c.backendName + "/" + name
+	return c.runAll(ctx, []runtimeCommand{
+		{"set server " + target + " state maint", emptyReply},
+		{"shutdown sessions server " + target, emptyReply},
+		{"del server " + target, prefixReply("Server deleted")},
+	})
+}
+
+func (c *RuntimeClient) serversState(ctx context.Context) ([]runtimeServer, error) {
+	out, err := c.command(ctx, "show servers state "+c.backendName)
+	if err != nil {
+		return nil, err
+	}
+	var columns map[string]int
+	var servers []runtimeServer
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "# ") {
+			columns = make(map[string]int)
+			for i, name := range strings.Fields(strings.TrimPrefix(line, "# ")) {
+				columns[name] = i
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if columns == nil || len(fields) < len(columns) {
+			continue
+		}
+		get := func(name string) string { return fields[columns[name]] }
+		id, _ := strconv.Atoi(get("srv_id"))
+		port, _ := strconv.Atoi(get("srv_port"))
+		admin, _ := strconv.Atoi(get("srv_admin_state"))
+		check, _ := strconv.Atoi(get("srv_check_state"))
+		weight, _ := strconv.Atoi(get("srv_uweight"))
+		fqdn := get("srv_fqdn")
+		if fqdn == "-" {
+			fqdn = ""
+		}
+		servers = append(servers, runtimeServer{
+			ID:         id,
+			Name:       get("srv_name"),
+			Addr:       get("srv_addr"),
+			Port:       int32(port),
+			FQDN:       fqdn,
+			AdminState: admin,
+			CheckState: check,
+			Weight:     weight,
+		})
+	}
+	if columns == nil {
+		return nil, fmt.Errorf("show servers state %s: %s", c.backendName, out)
+	}
+	return servers, nil
+}
+
+// ServerStats reads server statistics from "show stat". In slot mode the slots are reported
+// under the name of the server assigned to them.
+func (c *RuntimeClient) ServerStats(ctx context.Context) ([]ServerStats, error) {
+	out, err := c.command(ctx, "show stat")
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return parseShowStat(out, c.backendName, func(name string) (string, bool) {
+		if c.opts.SlotPrefix == "" {
+			return name, true
+		}
+		assigned, ok := c.slots[name]
+		return assigned, ok
+	})
+}
+
+// parseShowStat reads the servers of backend from "show stat" CSV output. rename maps the
+// HAProxy server name to the reported one; servers it rejects are skipped.
+func parseShowStat(out, backend string, rename func(string) (string, bool)) ([]ServerStats, error) {
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(out, "# "))).ReadAll()
+	if err != nil || len(records) == 0 {
+		return nil, fmt.Errorf("decode stats: %v", err)
+	}
+	columns := make(map[string]int, len(records[0]))
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	get := func(rec []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(rec) {
+			return rec[i]
+		}
+		return ""
+	}
+	num := func(rec []string, name string) int64 {
+		n, _ := strconv.ParseInt(get(rec, name), 10, 64)
+		return n
+	}
+
+	var stats []ServerStats
+	for _, rec := range records[1:] {
+		name := get(rec, "svname")
+		if get(rec, "pxname") != backend || name == "FRONTEND" || name == "BACKEND" {
+			continue
+		}
+		name, ok := rename(name)
+		if !ok {
+			continue
+		}
+		stats = append(stats, ServerStats{
+			Name:             name,
+			CurrentSessions:  num(rec, "scur"),
+			TotalSessions:    num(rec, "stot"),
+			ResponseTime:     time.Duration(num(rec, "rtime")) * time.Millisecond,
+			ResponseErrors:   num(rec, "eresp"),
+			ConnectionErrors: num(rec, "econ"),
+			CheckStatus:      get(rec, "check_status"),
+			Status:           get(rec, "status"),
+		})
+	}
+	return stats, nil
+}
+
+// runtimeCommand is a command with the check for a successful reply. The Runtime API answers
+// errors in plain text, so anything else than the expected reply is an error.
+type runtimeCommand struct {
+	cmd string
+	ok  func(reply string) bool
+}
+
+func emptyReply(reply string) bool { return reply == "" }
+
+func addrReply(reply string) bool {
+	return reply == "" || strings.Contains(reply, "changed from") || strings.Contains(reply, "no need to change")
+}
+
+func prefixReply(prefix string) func(string) bool {
+	return func(reply string) bool { return strings.HasPrefix(reply, prefix) }
+}
+
+func (c *RuntimeClient) runAll(ctx context.Context, cmds []runtimeCommand) error {
+	for _, cmd := range cmds {
+		if err := c.run(ctx, cmd.cmd, cmd.ok); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *RuntimeClient) run(ctx context.Context, cmd string, ok func(string) bool) error {
+	out, err := c.command(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	if !ok(out) {
+		return fmt.Errorf("%s: %s", cmd, out)
+	}
+	return nil
+}
+
+func (c *RuntimeClient) command(ctx context.Context, cmd string) (string, error) {
+	return socketCommand(ctx, c.network, c.address, c.opts.Timeout, cmd)
+}
+
+// socketCommand sends one command on a new connection to a Runtime API or master CLI socket;
+// HAProxy closes the connection after the reply.
+func socketCommand(ctx context.Context, network, address string, timeout time.Duration, cmd string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return "", fmt.Errorf("connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, err := io.WriteString(conn, cmd+"\n"); err != nil {
+		return "", fmt.Errorf("%s: %w", cmd, err)
+	}
+	out, err := io.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", cmd, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,255 @@
+package haproxy
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRuntime answers Runtime API commands by prefix and records them.
+type fakeRuntime struct {
+	mu       sync.Mutex
+	replies  map[string]string
+	commands []string
+}
+
+func newFakeRuntime(t *testing.T) (*fakeRuntime, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	f := &fakeRuntime{replies: map[string]string{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			cmd := strings.TrimSpace(line)
+			f.mu.Lock()
+			f.commands = append(f.commands, cmd)
+			reply := ""
+			for prefix, r := range f.replies {
+				if strings.HasPrefix(cmd, prefix) {
+					reply = r
+				}
+			}
+			f.mu.Unlock()
+			_, _ = conn.Write([]byte(reply + "\n"))
+			_ = conn.Close()
+		}
+	}()
+	return f, ln.Addr().String()
+}
+
+func (f *fakeRuntime) reply(prefix, reply string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies[prefix] = reply
+}
+
+func (f *fakeRuntime) recorded() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.commands...)
+}
+
+const serversStateHeader = "1\n# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight srv_time_since_last_change srv_check_status srv_check_result srv_check_health srv_check_state srv_agent_state bk_f_forced_id srv_f_forced_id srv_fqdn srv_port srvrecord\n"
+
+func commitRuntime(t *testing.T, c *RuntimeClient, servers []BackendServer) error {
+	t.Helper()
+	ctx := context.Background()
+	tx, err := c.BeginTransaction(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if err := c.UpdateBackendsInTransaction(ctx, tx, servers); err != nil {
+		t.Fatalf("update backends: %v", err)
+	}
+	if err := c.UpdateBackendSettingsInTransaction(ctx, tx, BackendSettings{}); err != nil {
+		t.Fatalf("update settings: %v", err)
+	}
+	return c.CommitTransaction(ctx, tx)
+}
+
+func TestRuntimeClientDynamicServers(t *testing.T) {
+	fake, addr := newFakeRuntime(t)
+	fake.reply("show servers state be_ingress", serversStateHeader+
+		"3 be_ingress 1 worker-1-443 192.168.0.9 2 8 1 1 10 6 3 4 2 0 0 0 - 443 -\n"+
+		"3 be_ingress 2 worker-3-443 192.168.0.3 2 0 1 1 10 6 3 4 6 0 0 0 - 443 -\n")
+	fake.reply("set server be_ingress/worker-1-443 addr", "IP changed from '192.168.0.9' to '192.168.0.1' by 'stats socket command'")
+	fake.reply("add server", "New server registered.")
+	fake.reply("del server", "Server deleted.")
+
+	c, err := NewRuntimeClient("tcp://"+addr, "be_ingress", RuntimeOptions{})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	c.OwnServers([]string{"worker-3-443"})
+	servers := []BackendServer{
+		{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 2, Check: true},
+		{Name: "worker-2-443", Address: "192.168.0.2", Port: 443, Weight: 1, Check: true, MaxConn: 100, State: ServerStateDrain},
+	}
+	if err := commitRuntime(t, c, servers); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	// worker-1 is drained with checks disabled, so both are reset.
+	expected := []string{
+		"show servers state be_ingress",
+		"set server be_ingress/worker-1-443 addr 192.168.0.1 port 443",
+		"set server be_ingress/worker-1-443 weight 2",
+		"enable health be_ingress/worker-1-443",
+		"set server be_ingress/worker-1-443 state ready",
+		"add server be_ingress/worker-2-443 192.168.0.2:443 weight 1 check",
+		"set maxconn server be_ingress/worker-2-443 100",
+		"enable health be_ingress/worker-2-443",
+		"set server be_ingress/worker-2-443 state drain",
+		"set server be_ingress/worker-3-443 state maint",
+		"shutdown sessions server be_ingress/worker-3-443",
+		"del server be_ingress/worker-3-443",
+	}
+	if got := fake.recorded(); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected commands:\n%s", strings.Join(got, "\n"))
+	}
+}
+
+func TestRuntimeClientSlots(t *testing.T) {
+	fake, addr := newFakeRuntime(t)
+	fake.reply("show servers state be_ingress", serversStateHeader+
+		"3 be_ingress 1 slot1 192.168.0.2 2 0 1 1 10 6 3 4 6 0 0 0 - 443 -\n"+
+		"3 be_ingress 2 slot2 0.0.0.0 0 1 1 1 10 6 3 4 6 0 0 0 - 0 -\n"+
+		"3 be_ingress 3 slot3 192.168.0.7 2 0 1 1 10 6 3 4 6 0 0 0 - 443 -\n")
+	fake.reply("show stat", "# pxname,svname,scur,stot,eresp,econ,rtime,check_status,status\n"+
+		"be_ingress,slot1,3,100,0,0,12,L4OK,UP\nbe_ingress,slot2,0,0,0,0,0,L4OK,UP\nbe_ingress,slot3,0,9,0,0,0,,MAINT\nbe_ingress,BACKEND,3,109,0,0,12,,UP\n")
+
+	c, err := NewRuntimeClient(addr, "be_ingress", RuntimeOptions{SlotPrefix: "slot"})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	servers := []BackendServer{
+		{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 1, Check: true},
+		{Name: "worker-2-443", Address: "192.168.0.2", Port: 443, Weight: 1, Check: true},
+	}
+	if err := commitRuntime(t, c, servers); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	// worker-2 stays on slot1, which is already ready with checks enabled, worker-1 takes
+	// the empty slot2, slot3 is no longer needed.
+	expected := []string{
+		"show servers state be_ingress",
+		"set server be_ingress/slot2 addr 192.168.0.1 port 443",
+		"set server be_ingress/slot2 state ready",
+		"set server be_ingress/slot3 state maint",
+	}
+	if got := fake.recorded(); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected commands:\n%s", strings.Join(got, "\n"))
+	}
+
+	stats, err := c.ServerStats(context.Background())
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if len(stats) != 2 || stats[0].Name != "worker-2-443" || stats[0].CurrentSessions != 3 || stats[1].Name != "worker-1-443" {
+		t.Fatalf("expected stats under the assigned server names, got %+v", stats)
+	}
+
+	servers = append(servers, BackendServer{Name: "worker-3-443", Address: "192.168.0.3", Port: 443}, BackendServer{Name: "worker-4-443", Address: "192.168.0.4", Port: 443})
+	if err := commitRuntime(t, c, servers); err == nil || !strings.Contains(err.Error(), "3 server slots") {
+		t.Fatalf("expected error when slots run out, got %v", err)
+	}
+}
+
+func TestRuntimeClientErrors(t *testing.T) {
+	fake, addr := newFakeRuntime(t)
+	fake.reply("show servers state be_ingress", serversStateHeader)
+	fake.reply("add server", "Backend is not using a dynamic load balancing algorithm.")
+
+	c, err := NewRuntimeClient(addr, "be_ingress", RuntimeOptions{})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	ctx := context.Background()
+	tx, _ := c.BeginTransaction(ctx)
+	_ = c.UpdateBackendsInTransaction(ctx, tx, []BackendServer{{Name: "a"}})
+	if err := c.AbortTransaction(ctx, tx); err != nil {
+		t.Fatalf("abort: %v", err)
+	}
+	if err := c.CommitTransaction(ctx, tx); err == nil || len(fake.recorded()) != 0 {
+		t.Fatalf("expected aborted transaction to send nothing, commands: %v", fake.recorded())
+	}
+
+	err = commitRuntime(t, c, []BackendServer{{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 1}})
+	if err == nil || !strings.Contains(err.Error(), "dynamic load balancing") {
+		t.Fatalf("expected runtime API reply in error, got %v", err)
+	}
+
+	if _, err := NewRuntimeClient("haproxy", "be_ingress", RuntimeOptions{}); err == nil {
+		t.Fatalf("expected address without port to be rejected")
+	}
+	if c, err := NewRuntimeClient("/var/run/haproxy.sock", "be_ingress", RuntimeOptions{}); err != nil || c.network != "unix" {
+		t.Fatalf("expected unix socket path, got %v", err)
+	}
+}
+
+func TestRuntimeClientSnapshotAndRestore(t *testing.T) {
+	fake, addr := newFakeRuntime(t)
+	fake.reply("show servers state be_ingress", serversStateHeader+
+		"3 be_ingress 1 worker-1-443 192.168.0.1 2 0 2 1 10 6 3 4 6 0 0 0 - 443 -\n"+
+		"3 be_ingress 2 sorry 192.168.0.9 2 0 1 1 10 6 3 4 6 0 0 0 - 80 -\n"+
+		"3 be_ingress 3 worker-2-443 192.168.0.2 2 1 1 1 10 6 3 4 2 0 0 0 - 443 -\n")
+	fake.reply("add server", "New server registered.")
+	fake.reply("del server", "Server deleted.")
+
+	c, err := NewRuntimeClient("tcp://"+addr, "be_ingress", RuntimeOptions{})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	c.OwnServers([]string{"worker-2-443"})
+	ctx := context.Background()
+	snapshot, err := c.SnapshotBackend(ctx, []string{"worker-1-443"}, "")
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	// The hand-added sorry server is not managed and not saved.
+	want := []BackendServer{
+		{Name: "worker-1-443", Address: "192.168.0.1", Port: 443, Weight: 2, Check: true, State: ServerStateReady},
+		{Name: "worker-2-443", Address: "192.168.0.2", Port: 443, Weight: 1, State: ServerStateMaint},
+	}
+	if !reflect.DeepEqual(snapshot.Servers, want) {
+		t.Fatalf("unexpected snapshot servers %+v", snapshot.Servers)
+	}
+
+	// Since the snapshot worker-1 was deleted and worker-3 added.
+	c.OwnServers([]string{"worker-3-443"})
+	fake.reply("show servers state be_ingress", serversStateHeader+
+		"3 be_ingress 2 sorry 192.168.0.9 2 0 1 1 10 6 3 4 6 0 0 0 - 80 -\n"+
+		"3 be_ingress 3 worker-2-443 192.168.0.2 2 1 1 1 10 6 3 4 2 0 0 0 - 443 -\n"+
+		"3 be_ingress 4 worker-3-443 192.168.0.3 2 0 1 1 10 6 3 4 6 0 0 0 - 443 -\n")
+	tx, _ := c.BeginTransaction(ctx)
+	if err := c.RestoreBackendInTransaction(ctx, tx, snapshot); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if err := c.CommitTransaction(ctx, tx); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	got := strings.Join(fake.recorded(), "\n")
+	for _, cmd := range []string{"add server be_ingress/worker-1-443 192.168.0.1:443 weight 2 check", "del server be_ingress/worker-3-443"} {
+		if !strings.Contains(got, cmd) {
+			t.Fatalf("expected %q, got:\n%s", cmd, got)
+		}
+	}
+	if strings.Contains(got, "del server be_ingress/sorry") {
+		t.Fatalf("hand-added server was deleted:\n%s", got)
+	}
+}
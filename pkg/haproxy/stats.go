@@ -0,0 +1,84 @@
+package haproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type nativeStatsCollection struct {
+	RuntimeAPI string       `json:"runtimeAPI"`
+	Error      string       `json:"error"`
+	Stats      []nativeStat `json:"stats"`
+}
+
+type nativeStat struct {
+	Name        string           `json:"name"`
+	Type        string           `json:"type"`
+	BackendName string           `json:"backend_name"`
+	Stats       nativeStatFields `json:"stats"`
+}
+
+type nativeStatFields struct {
+	Scur        int64  `json:"scur"`
+	Stot        int64  `json:"stot"`
+	Rtime       int64  `json:"rtime"`
+	Eresp       int64  `json:"eresp"`
+	Econ        int64  `json:"econ"`
+	CheckStatus string `json:"check_status"`
+	Status      string `json:"status"`
+}
+
+// ServerStats reads runtime statistics for the servers of the managed backend.
+func (c *DataPlaneClient) ServerStats(ctx context.Context) ([]ServerStats, error) {
+	d, err := c.dialect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	values := url.Values{}
+	values.Set("type", "server")
+	values.Set("parent", c.backendName)
+
+	var raw json.RawMessage
+	if err := c.doRequest(ctx, http.MethodGet, d.path("services/haproxy/stats/native"), values, nil, &raw); err != nil {
+		return nil, fmt.Errorf("read stats: %w", err)
+	}
+
+	// Depending on the Data Plane API version the collection is a single object or one entry per runtime socket.
+	var collections []nativeStatsCollection
+	if len(raw) > 0 && raw[0] == '{' {
+		var single nativeStatsCollection
+		if err := json.Unmarshal(raw, &single); err != nil {
+			return nil, fmt.Errorf("decode stats: %w", err)
+		}
+		collections = append(collections, single)
+	} else if err := json.Unmarshal(raw, &collections); err != nil {
+		return nil, fmt.Errorf("decode stats: %w", err)
+	}
+
+	var out []ServerStats
+	for _, col := range collections {
+		if col.Error != "" {
+			return nil, fmt.Errorf("read stats from %s: %s", col.RuntimeAPI, col.Error)
+		}
+		for _, st := range col.Stats {
+			if st.Type != "server" || st.BackendName != c.backendName {
+				continue
+			}
+			out = append(out, ServerStats{
+				Name:             st.Name,
+				CurrentSessions:  st.Stats.Scur,
+				TotalSessions:    st.Stats.Stot,
+				ResponseTime:     time.Duration(st.Stats.Rtime) * time.Millisecond,
+				ResponseErrors:   st.Stats.Eresp,
+				ConnectionErrors: st.Stats.Econ,
+				CheckStatus:      st.Stats.CheckStatus,
+				Status:           st.Stats.Status,
+			})
+		}
+	}
+	return out, nil
+}
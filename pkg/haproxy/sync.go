@@ -3,48 +3,272 @@ package haproxy
 import (
 	"context"
 	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 )
 
 // Syncer drives HAProxy updates using the Data Plane API client.
 type Syncer struct {
-	client      Client
-	port        int32
-	sendProxyV2 bool
+	client Client
+	opts   SyncerOptions
+
+	mu sync.Mutex
+	// verified holds the server names of the last sync that passed verification, lastGood
+	// its full state, and rejected the key of the state that was last rolled back.
+	verified  map[string]bool
+	lastGood  *appliedState
+	rejected  string
+	rollbacks atomic.Int64
+	// pending is the last commit, judged once its settle time has passed.
+	pending *pendingVerification
+	now     func() time.Time
+	// ownershipSeeded is set once the history's servers were handed to the client.
+	ownershipSeeded bool
+	// pausedSyncs is set while the history's pause switch is on.
+	pausedSyncs bool
+}
+
+// SyncerOptions tunes how a Syncer builds backend servers.
+type SyncerOptions struct {
+	// Port forces a specific backend port if > 0.
+	Port int32
+	// PodPort selects the container port of host-port ingress Pods by name or number; empty
+	// uses every TCP port, which CheckPodPorts only allows when there is one.
+	PodPort string
+	// SendProxyV2 is shorthand for ProxyProtocol.Version = ProxyV2 and is ignored when ProxyProtocol.Version is set.
+	SendProxyV2   bool
+	ProxyProtocol ProxyProtocolConfig
+	Weights       WeightConfig
+	DNS           DNSConfig
+	// HealthCheck overrides DefaultHealthCheckConfig when its Type is set.
+	HealthCheck HealthCheckConfig
+	Balance     BalanceConfig
+	Persistence PersistenceConfig
+	// BackendTemplate holds extra backend fields from ParseBackendTemplate.
+	BackendTemplate map[string]any
+	// ServerTemplate holds server parameters from ParseServerTemplate.
+	ServerTemplate map[string]any
+	// Adaptive scales weights from runtime statistics; optional.
+	Adaptive *AdaptiveWeights
+	// Dampener delays removal of vanished servers and holds flapping ones in maint; optional.
+	Dampener *Dampener
+	// Agent enables agent checks answered from Kubernetes state; optional.
+	Agent *AgentServer
+	// Recorder receives Events about invalid node override annotations; optional.
+	Recorder record.EventRecorder
+	// Verify checks server states after each commit and rolls the backend back when too few
+	// servers come UP, judging them on a later sync once Verify.Settle has passed. It needs
+	// Stats and a client implementing SnapshotClient.
+	Verify VerifyConfig
+	Stats  StatsClient
+	// EventObject is the object rollback Events are recorded on, usually the ingress Service.
+	EventObject runtime.Object
+	// History records the backend before each commit for the rollback command, and holds
+	// the switch that pauses syncs; optional.
+	History *History
 }
 
 // NewSyncer builds a new Syncer instance.
 func NewSyncer(client Client) *Syncer {
-	return &Syncer{client: client}
+	return NewSyncerWithOptions(client, SyncerOptions{})
 }
 
 // NewSyncerWithPort builds a Syncer that forces a specific backend port if port > 0.
 func NewSyncerWithPort(client Client, port int32) *Syncer {
-	return &Syncer{client: client, port: port}
+	return NewSyncerWithOptions(client, SyncerOptions{Port: port})
 }
 
 // NewSyncerWithPortAndProxy builds a Syncer with port override and send-proxy-v2 toggle.
 func NewSyncerWithPortAndProxy(client Client, port int32, sendProxyV2 bool) *Syncer {
-	return &Syncer{client: client, port: port, sendProxyV2: sendProxyV2}
+	return NewSyncerWithOptions(client, SyncerOptions{Port: port, SendProxyV2: sendProxyV2})
+}
+
+// NewSyncerWithOptions builds a Syncer from a full option set.
+func NewSyncerWithOptions(client Client, opts SyncerOptions) *Syncer {
+	return &Syncer{client: client, opts: opts, now: time.Now}
 }
 
-// Sync converts EndpointSlices or Endpoints to HAProxy backends and pushes them through a transaction.
-func (s *Syncer) Sync(ctx context.Context, slices []*discoveryv1.EndpointSlice, endpoints []*corev1.Endpoints, nodeIPs map[string]string) error {
-	overridePort := s.port
-	backends := BuildBackendsFromEndpointSlices(slices, nodeIPs, overridePort)
+// Sync converts EndpointSlices, Endpoints or host-port ingress Pods to HAProxy backends and pushes them through a transaction.
+func (s *Syncer) Sync(ctx context.Context, state ClusterState) error {
+	overridePort := s.opts.Port
+	withNotReady := s.opts.Agent != nil
+	backends := buildFromEndpointSlices(state.EndpointSlices, state.NodeIPs, overridePort, withNotReady)
+	if len(backends) == 0 {
+		backends = buildFromEndpoints(state.Endpoints, state.NodeIPs, overridePort, withNotReady)
+	}
 	if len(backends) == 0 {
-		backends = BuildBackendsFromEndpoints(endpoints, nodeIPs, overridePort)
+		if err := CheckPodPorts(state.Pods, s.opts.PodPort, overridePort); err != nil {
+			return err
+		}
+		backends = buildFromPods(state.Pods, s.opts.PodPort, overridePort, withNotReady)
+	}
+	backends = ApplyWeights(mergeServers(backends), state.Nodes, s.opts.Weights)
+	if s.opts.Adaptive != nil {
+		backends = s.opts.Adaptive.Apply(backends, max(s.opts.Weights.Min, 1))
+	}
+
+	backends, problems := ApplyNodeOverrides(backends, state.Nodes)
+	for _, p := range problems {
+		log.Printf("ignoring invalid override: %v", p)
+		if s.opts.Recorder != nil {
+			s.opts.Recorder.Eventf(p.Node, corev1.EventTypeWarning, "InvalidAnnotation", "Ignoring %s=%q: %v", p.Key, p.Value, p.Err)
+		}
+	}
+
+	backends = ApplyDNS(backends, s.opts.DNS)
+
+	for i := range backends {
+		if s.opts.Persistence.CookieName != "" {
+			backends[i].Cookie = backends[i].Name
+		}
+		backends[i].Options = s.opts.ServerTemplate
+	}
+
+	if s.opts.Dampener != nil {
+		backends = s.opts.Dampener.Apply(backends)
+	}
+
+	if s.opts.Agent != nil {
+		agent := s.opts.Agent.Config()
+		for i := range backends {
+			backends[i].Agent = &agent
+		}
+		// Publish before the transaction so state changes apply even while the Data Plane API is failing.
+		s.opts.Agent.Update(AgentStatuses(backends, state.Nodes))
 	}
 
-	healthChecks := HealthCheckConfig{IntervalSeconds: 5, RiseCount: 2, FallCount: 2}
-	healthChecks.SendProxyV2 = s.sendProxyV2
-	return s.SyncBackends(ctx, backends, healthChecks)
+	if s.opts.DNS.Enabled && len(s.opts.DNS.Resolvers.Nameservers) > 0 {
+		return s.syncBackends(ctx, backends, s.backendSettings(), &s.opts.DNS.Resolvers)
+	}
+	return s.SyncBackends(ctx, backends, s.backendSettings())
+}
+
+// NextSync returns when the Syncer needs another sync without a change in Kubernetes: when a
+// dampened server's hold-down or flap-stable period ends, the last commit is due for
+// verification, or, while paused, to see whether syncs were resumed.
+func (s *Syncer) NextSync() (time.Duration, bool) {
+	s.mu.Lock()
+	paused := s.pausedSyncs
+	s.mu.Unlock()
+	if paused {
+		return pausePoll, true
+	}
+	next, ok := s.verifyDelay()
+	if s.opts.Dampener != nil {
+		if d, dampened := s.opts.Dampener.NextChange(); dampened && (!ok || d < next) {
+			next, ok = d, true
+		}
+	}
+	return next, ok
+}
+
+func (s *Syncer) backendSettings() BackendSettings {
+	settings := BackendSettings{
+		HealthCheck:    s.opts.HealthCheck,
+		Balance:        s.opts.Balance,
+		Persistence:    s.opts.Persistence,
+		Template:       s.opts.BackendTemplate,
+		ServerTemplate: s.opts.ServerTemplate,
+	}
+	if settings.HealthCheck.Type == "" {
+		settings.HealthCheck = DefaultHealthCheckConfig()
+	}
+	settings.ProxyProtocol = s.opts.ProxyProtocol
+	if settings.ProxyProtocol.Version == "" && s.opts.SendProxyV2 {
+		settings.ProxyProtocol.Version = ProxyV2
+	}
+	return settings
+}
+
+// pausePoll is how often a paused Syncer checks whether syncs were resumed.
+const pausePoll = 30 * time.Second
+
+// paused reports whether the history's pause switch is on. A switch that cannot be read is
+// logged and does not stop syncs.
+func (s *Syncer) paused(ctx context.Context) bool {
+	if s.opts.History == nil {
+		return false
+	}
+	paused, err := s.opts.History.Paused(ctx)
+	if err != nil {
+		log.Printf("reading the pause switch: %v", err)
+		return false
+	}
+	s.mu.Lock()
+	changed := paused != s.pausedSyncs
+	s.pausedSyncs = paused
+	s.mu.Unlock()
+	switch {
+	case paused && changed:
+		log.Printf("syncs are paused, resume them with rollback --resume")
+	case changed:
+		log.Printf("syncs resumed")
+	}
+	return paused
 }
 
 // SyncBackends updates HAProxy backends using a transaction pattern.
-func (s *Syncer) SyncBackends(ctx context.Context, backends []BackendServer, health HealthCheckConfig) error {
+func (s *Syncer) SyncBackends(ctx context.Context, backends []BackendServer, settings BackendSettings) error {
+	return s.syncBackends(ctx, backends, settings, nil)
+}
+
+func (s *Syncer) syncBackends(ctx context.Context, backends []BackendServer, settings BackendSettings, resolvers *ResolversConfig) error {
+	if s.paused(ctx) {
+		return nil
+	}
+	s.seedOwnership(ctx)
+	if stop, err := s.awaitVerification(ctx); stop {
+		return err
+	}
+	if s.heldBack(appliedState{servers: backends, settings: settings, resolvers: resolvers}) {
+		log.Printf("holding back %d servers that failed verification until the endpoints change", len(backends))
+		return nil
+	}
+	return s.apply(ctx, backends, settings, resolvers)
+}
+
+// apply commits the configuration. The managed backend is saved before the commit for the
+// history and for the verification, which is scheduled when enabled.
+func (s *Syncer) apply(ctx context.Context, backends []BackendServer, settings BackendSettings, resolvers *ResolversConfig) error {
+	state := appliedState{servers: backends, settings: settings, resolvers: resolvers}
+	snapshots, ok := s.client.(SnapshotClient)
+	verifying := ok && s.opts.Verify.Enabled() && s.opts.Stats != nil && !s.isLastGood(state)
+
+	var snapshot *BackendSnapshot
+	if ok && (verifying || s.opts.History != nil) {
+		var resolversName string
+		if resolvers != nil {
+			resolversName = resolvers.Name
+		}
+		var err error
+		if snapshot, err = snapshots.SnapshotBackend(ctx, serverNames(backends), resolversName); err != nil {
+			if verifying {
+				return fmt.Errorf("saving backend before change: %w", err)
+			}
+			log.Printf("saving backend for history: %v", err)
+		}
+	}
+	if err := s.commit(ctx, backends, settings, resolvers); err != nil {
+		return err
+	}
+	if s.opts.History != nil && snapshot != nil {
+		s.record(ctx, snapshot, backends, state.settingsKey())
+	}
+	if verifying {
+		s.startVerification(state, snapshot)
+	}
+	return nil
+}
+
+func (s *Syncer) commit(ctx context.Context, backends []BackendServer, settings BackendSettings, resolvers *ResolversConfig) error {
 	txID, err := s.client.BeginTransaction(ctx)
 	if err != nil {
 		return fmt.Errorf("beginning transaction: %w", err)
@@ -56,12 +280,19 @@ func (s *Syncer) SyncBackends(ctx context.Context, backends []BackendServer, hea
 		}
 	}()
 
+	// The resolvers section must exist before servers referencing it are written.
+	if resolvers != nil {
+		if err = s.client.EnsureResolversInTransaction(ctx, txID, *resolvers); err != nil {
+			return fmt.Errorf("updating resolvers: %w", err)
+		}
+	}
+
 	if err = s.client.UpdateBackendsInTransaction(ctx, txID, backends); err != nil {
 		return fmt.Errorf("updating backends: %w", err)
 	}
 
-	if err = s.client.UpdateHealthChecksInTransaction(ctx, txID, health); err != nil {
-		return fmt.Errorf("updating health checks: %w", err)
+	if err = s.client.UpdateBackendSettingsInTransaction(ctx, txID, settings); err != nil {
+		return fmt.Errorf("updating backend settings: %w", err)
 	}
 
 	if err = s.client.CommitTransaction(ctx, txID); err != nil {
@@ -73,6 +304,10 @@ func (s *Syncer) SyncBackends(ctx context.Context, backends []BackendServer, hea
 
 // BuildBackendsFromEndpointSlices maps EndpointSlices to HAProxy backend server definitions.
 func BuildBackendsFromEndpointSlices(slices []*discoveryv1.EndpointSlice, nodeIPs map[string]string, overridePort int32) []BackendServer {
+	return buildFromEndpointSlices(slices, nodeIPs, overridePort, false)
+}
+
+func buildFromEndpointSlices(slices []*discoveryv1.EndpointSlice, nodeIPs map[string]string, overridePort int32, withNotReady bool) []BackendServer {
 	var servers []BackendServer
 
 	for _, slice := range slices {
@@ -82,20 +317,31 @@ func BuildBackendsFromEndpointSlices(slices []*discoveryv1.EndpointSlice, nodeIP
 			}
 
 			for _, ep := range slice.Endpoints {
-				if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				ready := ep.Conditions.Ready == nil || *ep.Conditions.Ready
+				if !ready && !withNotReady {
 					continue
 				}
 
 				p := selectPort(port.Port, overridePort)
 				for _, addr := range ep.Addresses {
 					host := resolveAddress(addr, ep.NodeName, nodeIPs)
-					servers = append(servers, BackendServer{
-						Name:    serverName(addr, ep.NodeName, p),
-						Address: host,
-						Port:    p,
-						Weight:  1,
-						Check:   true,
-					})
+					srv := BackendServer{
+						Name:     serverName(addr, ep.NodeName, p),
+						Address:  host,
+						Port:     p,
+						Weight:   1,
+						Check:    true,
+						NodeName: stringValue(ep.NodeName),
+					}
+					switch {
+					case ready:
+						srv.ReadyEndpoints = 1
+					case ep.Conditions.Terminating != nil && *ep.Conditions.Terminating:
+						srv.TerminatingEndpoints = 1
+					default:
+						srv.NotReadyEndpoints = 1
+					}
+					servers = append(servers, srv)
 				}
 			}
 		}
@@ -106,6 +352,10 @@ func BuildBackendsFromEndpointSlices(slices []*discoveryv1.EndpointSlice, nodeIP
 
 // BuildBackendsFromEndpoints maps Endpoints resources to HAProxy backend server definitions.
 func BuildBackendsFromEndpoints(endpoints []*corev1.Endpoints, nodeIPs map[string]string, overridePort int32) []BackendServer {
+	return buildFromEndpoints(endpoints, nodeIPs, overridePort, false)
+}
+
+func buildFromEndpoints(endpoints []*corev1.Endpoints, nodeIPs map[string]string, overridePort int32, withNotReady bool) []BackendServer {
 	var servers []BackendServer
 
 	for _, ep := range endpoints {
@@ -115,20 +365,145 @@ func BuildBackendsFromEndpoints(endpoints []*corev1.Endpoints, nodeIPs map[strin
 				for _, addr := range subset.Addresses {
 					host := resolveAddress(addr.IP, addr.NodeName, nodeIPs)
 					servers = append(servers, BackendServer{
-						Name:    serverName(addr.IP, addr.NodeName, p),
-						Address: host,
-						Port:    p,
-						Weight:  1,
-						Check:   true,
+						Name:           serverName(addr.IP, addr.NodeName, p),
+						Address:        host,
+						Port:           p,
+						Weight:         1,
+						Check:          true,
+						NodeName:       stringValue(addr.NodeName),
+						ReadyEndpoints: 1,
 					})
 				}
+				if !withNotReady {
+					continue
+				}
+				for _, addr := range subset.NotReadyAddresses {
+					host := resolveAddress(addr.IP, addr.NodeName, nodeIPs)
+					servers = append(servers, BackendServer{
+						Name:              serverName(addr.IP, addr.NodeName, p),
+						Address:           host,
+						Port:              p,
+						Weight:            1,
+						Check:             true,
+						NodeName:          stringValue(addr.NodeName),
+						NotReadyEndpoints: 1,
+					})
+				}
+			}
+		}
+	}
+
+	return servers
+}
+
+// BuildBackendsFromPods maps Ready ingress Pods running with hostPort or hostNetwork to servers on their node IP.
+// Each declared hostPort (or container port under hostNetwork) becomes one server per node.
+func BuildBackendsFromPods(pods []*corev1.Pod, overridePort int32) []BackendServer {
+	return buildFromPods(pods, "", overridePort, false)
+}
+
+func buildFromPods(pods []*corev1.Pod, portSelector string, overridePort int32, withNotReady bool) []BackendServer {
+	var servers []BackendServer
+	seen := make(map[string]struct{})
+
+	for _, pod := range pods {
+		terminating := pod.DeletionTimestamp != nil
+		ready := !terminating && podReady(pod)
+		if pod.Status.HostIP == "" || (!ready && !withNotReady) {
+			continue
+		}
+
+		nodeName := pod.Spec.NodeName
+		for _, port := range hostPorts(pod, portSelector) {
+			p := selectPort(&port, overridePort)
+			name := serverName(pod.Status.HostIP, &nodeName, p)
+			if _, ok := seen[name]; ok {
+				continue
 			}
+			seen[name] = struct{}{}
+			srv := BackendServer{
+				Name:     name,
+				Address:  pod.Status.HostIP,
+				Port:     p,
+				Weight:   1,
+				Check:    true,
+				NodeName: nodeName,
+			}
+			switch {
+			case ready:
+				srv.ReadyEndpoints = 1
+			case terminating:
+				srv.TerminatingEndpoints = 1
+			default:
+				srv.NotReadyEndpoints = 1
+			}
+			servers = append(servers, srv)
 		}
 	}
 
 	return servers
 }
 
+func podReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// CheckPodPorts fails when a Pod serves more than one TCP port on its node and neither a
+// port selector nor an override picks one. Under hostNetwork every declared container port
+// is also a host port, so an ingress Pod's metrics or webhook port would become servers too.
+func CheckPodPorts(pods []*corev1.Pod, portSelector string, overridePort int32) error {
+	if portSelector != "" || overridePort > 0 {
+		return nil
+	}
+	for _, pod := range pods {
+		if ports := hostPorts(pod, ""); len(ports) > 1 {
+			return fmt.Errorf("pod %s/%s serves ports %v on its node: set INGRESS_POD_PORT to the name or number of the port to balance to, or HAPROXY_BACKEND_PORT", pod.Namespace, pod.Name, ports)
+		}
+	}
+	return nil
+}
+
+// hostPorts returns the TCP ports pod serves on its node, limited to the container port
+// named or numbered selector when it is set.
+func hostPorts(pod *corev1.Pod, selector string) []int32 {
+	var ports []int32
+	for _, c := range pod.Spec.Containers {
+		for _, cp := range c.Ports {
+			if cp.Protocol != "" && cp.Protocol != corev1.ProtocolTCP {
+				continue
+			}
+			var port int32
+			switch {
+			case cp.HostPort > 0:
+				port = cp.HostPort
+			case pod.Spec.HostNetwork && cp.ContainerPort > 0:
+				port = cp.ContainerPort
+			default:
+				continue
+			}
+			if selector != "" && !portSelected(cp, port, selector) {
+				continue
+			}
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// portSelected matches selector against the port name, or as a number against the container
+// or host port.
+func portSelected(cp corev1.ContainerPort, hostPort int32, selector string) bool {
+	if n, err := strconv.ParseInt(selector, 10, 32); err == nil {
+		return int32(n) == cp.ContainerPort || int32(n) == hostPort
+	}
+	return cp.Name == selector
+}
+
 func resolveAddress(original string, nodeName *string, nodeIPs map[string]string) string {
 	if nodeName != nil {
 		if ip, ok := nodeIPs[*nodeName]; ok && ip != "" {
@@ -148,6 +523,31 @@ func selectPort(found *int32, override int32) int32 {
 	return 0
 }
 
+// mergeServers collapses servers sharing a name, which happens when several ready
+// endpoints live on the same node, and sums their endpoint counts.
+func mergeServers(servers []BackendServer) []BackendServer {
+	merged := make([]BackendServer, 0, len(servers))
+	index := make(map[string]int, len(servers))
+	for _, srv := range servers {
+		if i, ok := index[srv.Name]; ok {
+			merged[i].ReadyEndpoints += srv.ReadyEndpoints
+			merged[i].TerminatingEndpoints += srv.TerminatingEndpoints
+			merged[i].NotReadyEndpoints += srv.NotReadyEndpoints
+			continue
+		}
+		index[srv.Name] = len(merged)
+		merged = append(merged, srv)
+	}
+	return merged
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
 func serverName(address string, nodeName *string, port int32) string {
 	name := address
 	if nodeName != nil && *nodeName != "" {
@@ -1,7 +1,12 @@
 package haproxy
 
 import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
@@ -134,6 +139,115 @@ func TestBuildBackendsFromEndpoints(t *testing.T) {
 	}
 }
 
+func TestBuildBackendsFromPods(t *testing.T) {
+	readyCond := []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	notReadyCond := []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse}}
+	now := metav1.Now()
+
+	hostPortPod := func(name, node, hostIP string, conds []corev1.PodCondition) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: corev1.PodSpec{
+				NodeName: node,
+				Containers: []corev1.Container{{
+					Ports: []corev1.ContainerPort{
+						{ContainerPort: 80, HostPort: 80},
+						{ContainerPort: 443, HostPort: 443},
+						{ContainerPort: 10254},
+					},
+				}},
+			},
+			Status: corev1.PodStatus{HostIP: hostIP, Conditions: conds},
+		}
+	}
+
+	terminating := hostPortPod("ingress-c", "worker-3", "192.168.0.3", readyCond)
+	terminating.DeletionTimestamp = &now
+
+	hostNetwork := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress-d"},
+		Spec: corev1.PodSpec{
+			NodeName:    "worker-4",
+			HostNetwork: true,
+			Containers:  []corev1.Container{{Ports: []corev1.ContainerPort{{ContainerPort: 443}}}},
+		},
+		Status: corev1.PodStatus{HostIP: "192.168.0.4", Conditions: readyCond},
+	}
+
+	pods := []*corev1.Pod{
+		hostPortPod("ingress-a", "worker-1", "192.168.0.1", readyCond),
+		hostPortPod("ingress-b", "worker-2", "192.168.0.2", notReadyCond),
+		terminating,
+		hostNetwork,
+	}
+
+	backends := BuildBackendsFromPods(pods, 0)
+	got := make(map[string]string, len(backends))
+	for _, b := range backends {
+		got[b.Name] = fmt.Sprintf("%s:%d", b.Address, b.Port)
+	}
+	expected := map[string]string{
+		"worker-1-80":  "192.168.0.1:80",
+		"worker-1-443": "192.168.0.1:443",
+		"worker-4-443": "192.168.0.4:443",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected servers: %v", got)
+	}
+
+	overridden := BuildBackendsFromPods(pods, 443)
+	if len(overridden) != 2 {
+		t.Fatalf("expected override to collapse ports to one server per node, got %d", len(overridden))
+	}
+}
+
+func TestPodPortSelection(t *testing.T) {
+	readyCond := []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	// Under hostNetwork the API server defaults hostPort to containerPort for every port.
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ingress-nginx", Name: "ingress-a"},
+		Spec: corev1.PodSpec{
+			NodeName:    "worker-1",
+			HostNetwork: true,
+			Containers: []corev1.Container{{Ports: []corev1.ContainerPort{
+				{Name: "http", ContainerPort: 80, HostPort: 80},
+				{Name: "https", ContainerPort: 443, HostPort: 443},
+				{Name: "webhook", ContainerPort: 8443, HostPort: 8443},
+				{Name: "metrics", ContainerPort: 10254, HostPort: 10254},
+			}}},
+		},
+		Status: corev1.PodStatus{HostIP: "192.168.0.1", Conditions: readyCond},
+	}
+	pods := []*corev1.Pod{pod}
+
+	if err := CheckPodPorts(pods, "", 0); err == nil || !strings.Contains(err.Error(), "INGRESS_POD_PORT") {
+		t.Fatalf("expected several ports without a selector to be rejected, got %v", err)
+	}
+	for _, ok := range []struct {
+		selector string
+		override int32
+	}{{selector: "https"}, {override: 443}} {
+		if err := CheckPodPorts(pods, ok.selector, ok.override); err != nil {
+			t.Fatalf("unexpected error for %+v: %v", ok, err)
+		}
+	}
+
+	for _, selector := range []string{"https", "443"} {
+		backends := buildFromPods(pods, selector, 0, false)
+		if len(backends) != 1 || backends[0].Name != "worker-1-443" || backends[0].Port != 443 {
+			t.Fatalf("selector %q: unexpected servers %+v", selector, backends)
+		}
+	}
+
+	client := &stubClient{}
+	if err := NewSyncer(client).Sync(context.Background(), ClusterState{Pods: pods}); err == nil || client.committed != 0 {
+		t.Fatalf("expected the sync to fail without a port selection, got %v", err)
+	}
+	if err := NewSyncerWithOptions(client, SyncerOptions{PodPort: "https"}).Sync(context.Background(), ClusterState{Pods: pods}); err != nil || len(client.backends) != 1 {
+		t.Fatalf("expected one server with the selected port, got %+v: %v", client.backends, err)
+	}
+}
+
 func TestResolveAddressPrefersNodeIP(t *testing.T) {
 	nodeName := "node1"
 	addr := resolveAddress("10.0.0.10", &nodeName, map[string]string{"node1": "192.168.0.5"})
@@ -167,3 +281,138 @@ func TestServerNamePrefersNode(t *testing.T) {
 		t.Fatalf("expected address fallback, got %s", got)
 	}
 }
+
+// stubClient records what the Syncer pushes without talking to HAProxy.
+type stubClient struct {
+	backends  []BackendServer
+	settings  BackendSettings
+	resolvers *ResolversConfig
+	committed int
+	aborted   int
+}
+
+func (c *stubClient) BeginTransaction(context.Context) (string, error) { return "tx", nil }
+
+func (c *stubClient) CommitTransaction(context.Context, string) error {
+	c.committed++
+	return nil
+}
+
+func (c *stubClient) AbortTransaction(context.Context, string) error {
+	c.aborted++
+	return nil
+}
+
+func (c *stubClient) UpdateBackendsInTransaction(_ context.Context, _ string, backends []BackendServer) error {
+	c.backends = backends
+	return nil
+}
+
+func (c *stubClient) EnsureResolversInTransaction(_ context.Context, _ string, config ResolversConfig) error {
+	c.resolvers = &config
+	return nil
+}
+
+func (c *stubClient) UpdateBackendSettingsInTransaction(_ context.Context, _ string, settings BackendSettings) error {
+	c.settings = settings
+	return nil
+}
+
+func TestSyncAssignsCookiesFromServerNames(t *testing.T) {
+	client := &stubClient{}
+	s := NewSyncerWithOptions(client, SyncerOptions{
+		Balance:     BalanceConfig{Algorithm: "leastconn"},
+		Persistence: PersistenceConfig{CookieName: "SRVID", CookieMode: "insert"},
+	})
+
+	node := "worker-1"
+	state := ClusterState{
+		Endpoints: []*corev1.Endpoints{{
+			Subsets: []corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1", NodeName: &node}},
+				Ports:     []corev1.EndpointPort{{Port: 443}},
+			}},
+		}},
+	}
+	if err := s.Sync(context.Background(), state); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+
+	if len(client.backends) != 1 || client.backends[0].Cookie != "worker-1-443" {
+		t.Fatalf("expected cookie derived from server name, got %+v", client.backends)
+	}
+	if client.settings.Balance.Algorithm != "leastconn" || client.settings.HealthCheck.Type != CheckTCP {
+		t.Fatalf("unexpected settings pushed: %+v", client.settings)
+	}
+}
+
+// singleEndpointState is a cluster with one ingress endpoint on node.
+func singleEndpointState(node string) ClusterState {
+	return ClusterState{
+		Endpoints: []*corev1.Endpoints{{
+			Subsets: []corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1", NodeName: &node}},
+				Ports:     []corev1.EndpointPort{{Port: 443}},
+			}},
+		}},
+	}
+}
+
+func TestSyncEmptyServerListIsDampened(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	dampener := NewDampener(DampeningConfig{HoldDown: 30 * time.Second})
+	dampener.now = clock.now
+	client := &stubClient{}
+	s := NewSyncerWithOptions(client, SyncerOptions{Dampener: dampener})
+	if err := s.Sync(context.Background(), singleEndpointState("worker-1")); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if _, ok := s.NextSync(); ok {
+		t.Fatalf("did not expect a delayed sync without dampened servers")
+	}
+
+	// All endpoints gone: the server stays in maint for the hold-down.
+	clock.advance(10 * time.Second)
+	if err := s.Sync(context.Background(), ClusterState{}); err != nil {
+		t.Fatalf("sync without servers: %v", err)
+	}
+	if len(client.backends) != 1 || client.backends[0].State != ServerStateMaint {
+		t.Fatalf("expected the vanished server held in maint, got %+v", client.backends)
+	}
+	if after, ok := s.NextSync(); !ok || after != 30*time.Second {
+		t.Fatalf("expected a sync when the hold-down ends, got %s, %t", after, ok)
+	}
+
+	clock.advance(30 * time.Second)
+	if err := s.Sync(context.Background(), ClusterState{}); err != nil {
+		t.Fatalf("sync after hold-down: %v", err)
+	}
+	if len(client.backends) != 0 || client.committed != 3 {
+		t.Fatalf("expected the backend emptied after the hold-down, got %+v after %d commits", client.backends, client.committed)
+	}
+	if _, ok := s.NextSync(); ok {
+		t.Fatalf("did not expect another delayed sync")
+	}
+}
+
+func TestSyncSendProxyV2Shorthand(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts SyncerOptions
+		want ProxyProtocolVersion
+	}{
+		{name: "shorthand", opts: SyncerOptions{SendProxyV2: true}, want: ProxyV2},
+		{name: "explicit version wins", opts: SyncerOptions{SendProxyV2: true, ProxyProtocol: ProxyProtocolConfig{Version: ProxyV1}}, want: ProxyV1},
+		{name: "disabled", opts: SyncerOptions{}, want: ""},
+	}
+
+	for _, tc := range testCases {
+		client := &stubClient{}
+		if err := NewSyncerWithOptions(client, tc.opts).Sync(context.Background(), singleEndpointState("worker-1")); err != nil {
+			t.Fatalf("%s: sync failed: %v", tc.name, err)
+		}
+		if client.settings.ProxyProtocol.Version != tc.want {
+			t.Fatalf("%s: expected PROXY protocol %q, got %q", tc.name, tc.want, client.settings.ProxyProtocol.Version)
+		}
+	}
+}
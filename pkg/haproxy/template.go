@@ -0,0 +1,158 @@
+package haproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindNumber
+	kindObject
+)
+
+// backendTemplateFields lists the Data Plane v3 backend fields a template may set, with their JSON kind.
+var backendTemplateFields = map[string]fieldKind{
+	"mode":                    kindString,
+	"description":             kindString,
+	"connect_timeout":         kindNumber,
+	"server_timeout":          kindNumber,
+	"queue_timeout":           kindNumber,
+	"tunnel_timeout":          kindNumber,
+	"server_fin_timeout":      kindNumber,
+	"http_keep_alive_timeout": kindNumber,
+	"http_request_timeout":    kindNumber,
+	"retries":                 kindNumber,
+	"fullconn":                kindNumber,
+	"redispatch":              kindObject,
+	"http_reuse":              kindString,
+	"http_connection_mode":    kindString,
+	"forwardfor":              kindObject,
+	"abortonclose":            kindString,
+	"allbackups":              kindString,
+	"prefer_last_server":      kindString,
+	"log_tag":                 kindString,
+}
+
+// serverTemplateFields lists the Data Plane v3 server parameters a server template may set.
+var serverTemplateFields = map[string]fieldKind{
+	"ssl":              kindString,
+	"verify":           kindString,
+	"ssl_cafile":       kindString,
+	"ssl_certificate":  kindString,
+	"sni":              kindString,
+	"alpn":             kindString,
+	"proto":            kindString,
+	"maxconn":          kindNumber,
+	"maxqueue":         kindNumber,
+	"minconn":          kindNumber,
+	"slowstart":        kindNumber,
+	"on-marked-down":   kindString,
+	"on-marked-up":     kindString,
+	"on-error":         kindString,
+	"observe":          kindString,
+	"error_limit":      kindNumber,
+	"check-sni":        kindString,
+	"check_alpn":       kindString,
+	"pool_max_conn":    kindNumber,
+	"pool_purge_delay": kindNumber,
+	"tfo":              kindString,
+	"ws":               kindString,
+}
+
+// ownedServerFields are the server parameters set from Kubernetes state or other controller settings.
+var ownedServerFields = []string{
+	"name", "address", "port", "weight", "check", "maintenance", "backup", "cookie",
+	"resolvers", "resolve-prefer", "init-addr",
+	"agent-check", "agent-addr", "agent-port", "agent-send", "agent-inter",
+}
+
+// ParseServerTemplate parses a YAML or JSON fragment of Data Plane v3 server parameters applied to
+// every generated server and to default_server. maxconn from a node annotation still wins per server.
+func ParseServerTemplate(data []byte) (map[string]any, error) {
+	owned := append(append([]string{}, ownedServerFields...), ownedDefaultServerFields...)
+	tmpl, err := parseTemplate(data, serverTemplateFields, owned, "server")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := withFields(serverModel{}, tmpl); err != nil {
+		return nil, fmt.Errorf("server template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// ParseBackendTemplate parses a YAML or JSON fragment in the Data Plane v3 backend schema
+// and validates it against the fields templates may set. Controller-owned fields are rejected
+// because they would be overridden on every reconcile anyway.
+func ParseBackendTemplate(data []byte) (map[string]any, error) {
+	owned := append([]string{"name", "default_server"}, ownedBackendFields...)
+	tmpl, err := parseTemplate(data, backendTemplateFields, owned, "backend")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := withFields(backendModel{}, tmpl); err != nil {
+		return nil, fmt.Errorf("backend template: %w", err)
+	}
+	return tmpl, nil
+}
+
+func parseTemplate(data []byte, fields map[string]fieldKind, owned []string, what string) (map[string]any, error) {
+	if strings.TrimSpace(string(data)) == "" {
+		return nil, nil
+	}
+
+	raw, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s template: %w", what, err)
+	}
+
+	var tmpl map[string]any
+	if err := json.Unmarshal(raw, &tmpl); err != nil {
+		return nil, fmt.Errorf("%s template must be an object: %w", what, err)
+	}
+
+	for _, k := range owned {
+		if _, ok := tmpl[k]; ok {
+			return nil, fmt.Errorf("%s template: field %s is managed by the controller", what, k)
+		}
+	}
+
+	var unknown []string
+	for k, v := range tmpl {
+		kind, ok := fields[k]
+		if !ok {
+			unknown = append(unknown, k)
+			continue
+		}
+		if err := checkKind(k, v, kind); err != nil {
+			return nil, fmt.Errorf("%s template: %w", what, err)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("%s template: unsupported fields %s", what, strings.Join(unknown, ", "))
+	}
+	return tmpl, nil
+}
+
+func checkKind(key string, v any, kind fieldKind) error {
+	var ok bool
+	switch kind {
+	case kindString:
+		_, ok = v.(string)
+	case kindNumber:
+		_, ok = v.(float64)
+	case kindObject:
+		_, ok = v.(map[string]any)
+	}
+	if !ok {
+		return fmt.Errorf("field %s has unexpected type %T", key, v)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package haproxy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseBackendTemplate(t *testing.T) {
+	tmpl, err := ParseBackendTemplate([]byte(`
+mode: tcp
+connect_timeout: 5000
+server_timeout: 60000
+retries: 3
+redispatch:
+  enabled: enabled
+http_reuse: safe
+fullconn: 1000
+`))
+	if err != nil {
+		t.Fatalf("parse template: %v", err)
+	}
+	if tmpl["mode"] != "tcp" || tmpl["retries"] != float64(3) {
+		t.Fatalf("unexpected template: %v", tmpl)
+	}
+
+	if tmpl, err := ParseBackendTemplate([]byte(`{"mode": "http"}`)); err != nil || tmpl["mode"] != "http" {
+		t.Fatalf("expected JSON template to parse, got %v, %v", tmpl, err)
+	}
+	if tmpl, err := ParseBackendTemplate(nil); err != nil || tmpl != nil {
+		t.Fatalf("expected empty template to be nil, got %v, %v", tmpl, err)
+	}
+
+	testCases := []struct {
+		name    string
+		input   string
+		errPart string
+	}{
+		{name: "unknown field", input: "mode: tcp\nbogus: 1\n", errPart: "unsupported fields bogus"},
+		{name: "owned field", input: "balance:\n  algorithm: leastconn\n", errPart: "managed by the controller"},
+		{name: "wrong type", input: "retries: three\n", errPart: "unexpected type"},
+		{name: "fraction for integer field", input: "retries: 1.5\n", errPart: "backend template"},
+		{name: "not an object", input: "- mode\n", errPart: "must be an object"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParseBackendTemplate([]byte(tc.input))
+			if err == nil || !strings.Contains(err.Error(), tc.errPart) {
+				t.Fatalf("expected error containing %q, got %v", tc.errPart, err)
+			}
+		})
+	}
+}
+
+func TestMergeBackendPrecedence(t *testing.T) {
+	var existing backendModel
+	if err := json.Unmarshal([]byte(`{"name":"be","mode":"http","server_timeout":1000,"log_tag":"keep","hash_balance_factor":150,
+		"cookie":{"name":"OLD"},"default_server":{"check":"disabled","resolve_opts":"allow-dup-ip"}}`), &existing); err != nil {
+		t.Fatalf("decode existing: %v", err)
+	}
+	template := map[string]any{"mode": "tcp", "server_timeout": float64(60000)}
+	serverTemplate := map[string]any{"maxconn": float64(500)}
+	desired, err := desiredBackend("be", BackendSettings{HealthCheck: DefaultHealthCheckConfig()})
+	if err != nil {
+		t.Fatalf("desired backend: %v", err)
+	}
+
+	merged, err := mergeBackend(existing, template, serverTemplate, nil, desired)
+	if err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if merged.Mode != "tcp" || *merged.ServerTimeout != 60000 {
+		t.Fatalf("template did not override existing fields: %+v", merged)
+	}
+	if merged.LogTag != "keep" || merged.AdvCheck != "tcp-check" || merged.Cookie != nil {
+		t.Fatalf("unexpected merge result: %+v", merged)
+	}
+	data, _ := json.Marshal(merged)
+	for _, part := range []string{`"hash_balance_factor":150`, `"resolve_opts":"allow-dup-ip"`, `"check":"enabled"`, `"maxconn":500`} {
+		if !strings.Contains(string(data), part) {
+			t.Fatalf("expected %s in merged backend %s", part, data)
+		}
+	}
+
+	if _, err := mergeBackend(existing, map[string]any{"retries": 1.5}, nil, nil, desired); err == nil {
+		t.Fatalf("expected a template value that does not fit the schema to be rejected")
+	}
+}
+
+func TestParseServerTemplate(t *testing.T) {
+	tmpl, err := ParseServerTemplate([]byte(`
+ssl: enabled
+verify: required
+ssl_cafile: /etc/haproxy/ca.pem
+sni: str(ingress.example.com)
+proto: h2
+maxconn: 500
+slowstart: 30000
+on-marked-down: shutdown-sessions
+observe: layer4
+error_limit: 10
+`))
+	if err != nil {
+		t.Fatalf("parse server template: %v", err)
+	}
+	if tmpl["proto"] != "h2" || tmpl["slowstart"] != float64(30000) {
+		t.Fatalf("unexpected template: %v", tmpl)
+	}
+
+	for _, input := range []string{"weight: 10\n", "inter: 1000\n", "send-proxy-v2: enabled\n", "bogus: x\n"} {
+		if _, err := ParseServerTemplate([]byte(input)); err == nil {
+			t.Fatalf("expected %q to be rejected", input)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package haproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSConfig configures TLS towards the Data Plane API. Files are re-read when they change,
+// so certificates mounted from Secrets can rotate without a restart.
+type TLSConfig struct {
+	// CAFile is a PEM bundle used instead of the system roots to verify the server.
+	CAFile string
+	// CertFile and KeyFile hold the client certificate for mutual TLS.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name used for SNI and certificate verification.
+	ServerName string
+	// MinVersion is a tls.VersionTLS* constant; 0 means TLS 1.2.
+	MinVersion uint16
+}
+
+// Enabled reports whether any TLS setting deviates from the defaults.
+func (t TLSConfig) Enabled() bool {
+	return t.CAFile != "" || t.CertFile != "" || t.KeyFile != "" || t.ServerName != "" || t.MinVersion != 0
+}
+
+// Validate checks that the client certificate and key are configured together.
+func (t TLSConfig) Validate() error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("client certificate and key must be set together")
+	}
+	switch t.MinVersion {
+	case 0, tls.VersionTLS12, tls.VersionTLS13:
+	default:
+		return fmt.Errorf("unsupported minimum TLS version %#x", t.MinVersion)
+	}
+	return nil
+}
+
+// ParseTLSVersion maps "1.2" or "1.3" to the tls package constant; empty input means TLS 1.2.
+func ParseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q: expected 1.2 or 1.3", v)
+	}
+}
+
+// reloadingTLS serves the CA pool and client certificate, reloading them when their files change.
+type reloadingTLS struct {
+	config TLSConfig
+
+	mu      sync.Mutex
+	pool    *x509.CertPool
+	cert    *tls.Certificate
+	modTime map[string]time.Time
+}
+
+func newReloadingTLS(config TLSConfig) (*reloadingTLS, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	r := &reloadingTLS{config: config, modTime: make(map[string]time.Time)}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// clientConfig returns a tls.Config that verifies against the current CA pool and presents
+// the current client certificate on every handshake. host is the Data Plane URL host, which
+// the certificate must match unless ServerName is set.
+func (r *reloadingTLS) clientConfig(host string) *tls.Config {
+	cfg := &tls.Config{
+		ServerName: r.config.ServerName,
+		MinVersion: r.config.MinVersion,
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	if r.config.CertFile != "" {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			r.refresh()
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			return r.cert, nil
+		}
+	}
+	if r.config.CAFile != "" {
+		// Standard verification would pin the pool at construction time; verify here against the current pool instead.
+		// The name is fixed here because the handshake's ServerName is empty for IP hosts.
+		name := r.config.ServerName
+		if name == "" {
+			name = host
+		}
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return r.verify(cs, name)
+		}
+	}
+	return cfg
+}
+
+// verify checks the server chain against the current pool and the certificate against name,
+// a DNS name or an IP address.
+func (r *reloadingTLS) verify(cs tls.ConnectionState, name string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	r.refresh()
+	r.mu.Lock()
+	pool := r.pool
+	r.mu.Unlock()
+
+	opts := x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         pool,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, c := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// refresh reloads the files if any of them changed; a failed reload keeps the previous material.
+func (r *reloadingTLS) refresh() {
+	if changed, err := r.reload(); err != nil {
+		log.Printf("reloading Data Plane TLS files failed, keeping previous: %v", err)
+	} else if changed {
+		log.Printf("reloaded Data Plane TLS files")
+	}
+}
+
+func (r *reloadingTLS) reload() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := false
+	for _, f := range []string{r.config.CAFile, r.config.CertFile, r.config.KeyFile} {
+		if f == "" {
+			continue
+		}
+		info, err := os.Stat(f)
+		if err != nil {
+			return false, err
+		}
+		if !info.ModTime().Equal(r.modTime[f]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	var pool *x509.CertPool
+	if r.config.CAFile != "" {
+		data, err := os.ReadFile(r.config.CAFile)
+		if err != nil {
+			return false, fmt.Errorf("read CA file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return false, fmt.Errorf("no certificates found in %s", r.config.CAFile)
+		}
+	}
+
+	var cert *tls.Certificate
+	if r.config.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+		if err != nil {
+			return false, fmt.Errorf("load client certificate: %w", err)
+		}
+		cert = &c
+	}
+
+	r.pool, r.cert = pool, cert
+	for _, f := range []string{r.config.CAFile, r.config.CertFile, r.config.KeyFile} {
+		if f == "" {
+			continue
+		}
+		if info, err := os.Stat(f); err == nil {
+			r.modTime[f] = info.ModTime()
+		}
+	}
+	return true, nil
+}
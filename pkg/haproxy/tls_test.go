@@ -0,0 +1,200 @@
+package haproxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key signed by the CA, for name as a DNS name or IP address.
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{name}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("issue certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte, mod time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatalf("chtimes %s: %v", path, err)
+	}
+}
+
+func TestDataPlaneClientMutualTLSWithReload(t *testing.T) {
+	serverCA := newTestCA(t, "server-ca")
+	clientCA := newTestCA(t, "client-ca")
+	otherCA := newTestCA(t, "other-ca")
+
+	serverCertPEM, serverKeyPEM := serverCA.issue(t, "dataplane.internal", x509.ExtKeyUsageServerAuth)
+	serverCert, err := tls.X509KeyPair(serverCertPEM, serverKeyPEM)
+	if err != nil {
+		t.Fatalf("server key pair: %v", err)
+	}
+	clientPool := x509.NewCertPool()
+	clientPool.AddCert(clientCA.cert)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientPool,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	caFile, certFile, keyFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	clientCertPEM, clientKeyPEM := clientCA.issue(t, "controller", x509.ExtKeyUsageClientAuth)
+	start := time.Now().Add(-time.Minute)
+	// Start with the wrong CA; the rotated bundle must be picked up without a new client.
+	writeFile(t, caFile, otherCA.pem, start)
+	writeFile(t, certFile, clientCertPEM, start)
+	writeFile(t, keyFile, clientKeyPEM, start)
+
+	c, err := NewDataPlaneClientWithOptions(srv.URL, "be_ingress", DataPlaneOptions{TLS: TLSConfig{
+		CAFile:     caFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		ServerName: "dataplane.internal",
+		MinVersion: tls.VersionTLS12,
+	}})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	if _, err := c.ServerStats(context.Background()); err == nil {
+		t.Fatalf("expected verification against the wrong CA to fail")
+	}
+
+	writeFile(t, caFile, serverCA.pem, start.Add(time.Second))
+	if _, err := c.ServerStats(context.Background()); err != nil {
+		t.Fatalf("expected request to succeed after CA rotation: %v", err)
+	}
+}
+
+// With an IP URL the handshake has no SNI, so the certificate must still be checked against the IP.
+func TestDataPlaneClientVerifiesIPHost(t *testing.T) {
+	ca := newTestCA(t, "server-ca")
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.pem, time.Now())
+
+	testCases := []struct {
+		name       string
+		certName   string
+		serverName string
+		wantErr    bool
+	}{
+		{name: "certificate for another name", certName: "dataplane.internal", wantErr: true},
+		{name: "certificate for the IP", certName: "127.0.0.1"},
+		{name: "server name override", certName: "dataplane.internal", serverName: "dataplane.internal"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			certPEM, keyPEM := ca.issue(t, tc.certName, x509.ExtKeyUsageServerAuth)
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				t.Fatalf("server key pair: %v", err)
+			}
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(`[]`))
+			}))
+			srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+			srv.StartTLS()
+			defer srv.Close()
+
+			c, err := NewDataPlaneClientWithOptions(srv.URL, "be_ingress", DataPlaneOptions{TLS: TLSConfig{CAFile: caFile, ServerName: tc.serverName}})
+			if err != nil {
+				t.Fatalf("new client: %v", err)
+			}
+			_, err = c.ServerStats(context.Background())
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("got error %v, want error: %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLSConfigValidate(t *testing.T) {
+	if err := (TLSConfig{CertFile: "tls.crt"}).Validate(); err == nil {
+		t.Fatalf("expected certificate without key to be rejected")
+	}
+	if _, err := ParseTLSVersion("1.1"); err == nil {
+		t.Fatalf("expected TLS 1.1 to be rejected")
+	}
+	if _, err := NewDataPlaneClientWithOptions("https://dataplane:5555", "be", DataPlaneOptions{TLS: TLSConfig{CAFile: "/does/not/exist"}}); err == nil {
+		t.Fatalf("expected missing CA file to fail")
+	}
+}
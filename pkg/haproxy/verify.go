@@ -0,0 +1,544 @@
+package haproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultVerifyMinUp is the share of added servers that must come UP when verification is enabled.
+const DefaultVerifyMinUp = 0.5
+
+// VerifyConfig enables checking HAProxy's view of the servers after each commit.
+type VerifyConfig struct {
+	// Settle is how long to wait for health checks after a commit; zero disables verification.
+	Settle time.Duration
+	// MinUpFraction is the share of added, non-maint servers that must be UP after Settle.
+	MinUpFraction float64
+}
+
+// Enabled reports whether post-commit verification is on.
+func (v VerifyConfig) Enabled() bool {
+	return v.Settle > 0
+}
+
+// Validate checks that the settings can be used.
+func (v VerifyConfig) Validate() error {
+	if v.Settle < 0 {
+		return fmt.Errorf("settle time must not be negative")
+	}
+	if v.MinUpFraction < 0 || v.MinUpFraction > 1 {
+		return fmt.Errorf("minimum UP fraction must be between 0 and 1, got %v", v.MinUpFraction)
+	}
+	return nil
+}
+
+// BackendSnapshot is the managed part of the configuration as read before a change: the
+// backend with its settings, the servers the controller manages and its resolvers section.
+type BackendSnapshot struct {
+	// Version is HAProxy's configuration version when the snapshot was read; 0 if unknown.
+	Version int64 `json:",omitempty"`
+	// Servers are the managed servers, for diffs and listings.
+	Servers []BackendServer `json:",omitempty"`
+	// Resolvers names the resolvers section saved with the backend.
+	Resolvers string `json:",omitempty"`
+	// Data is the client's encoding of the backend, written back as is by a restore.
+	Data json.RawMessage `json:",omitempty"`
+}
+
+// SnapshotClient saves the managed backend before a change and writes it back on rollback.
+// Other backends and sections are neither read nor written.
+type SnapshotClient interface {
+	// SnapshotBackend reads the backend; servers are the names about to be written, which
+	// are managed together with the servers the controller already owns, and resolvers is
+	// the resolvers section to save, if any.
+	SnapshotBackend(ctx context.Context, servers []string, resolvers string) (*BackendSnapshot, error)
+	// RestoreBackendInTransaction writes snapshot back within a transaction.
+	RestoreBackendInTransaction(ctx context.Context, transactionID string, snapshot *BackendSnapshot) error
+}
+
+// RollbackError reports a committed change that was reverted because HAProxy considered
+// too few of the servers healthy afterwards.
+type RollbackError struct {
+	Reason string
+	// Version is the configuration version before the change; the backend was restored to it.
+	Version int64
+	// RestoreErr is set when restoring the previous backend failed as well.
+	RestoreErr error
+}
+
+func (e *RollbackError) Error() string {
+	if e.RestoreErr != nil {
+		return fmt.Sprintf("verification failed (%s) and restoring the backend as of configuration version %d failed: %v", e.Reason, e.Version, e.RestoreErr)
+	}
+	return fmt.Sprintf("verification failed (%s), restored the backend as of configuration version %d", e.Reason, e.Version)
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.RestoreErr
+}
+
+// judgeServers returns why the committed servers look unhealthy, or "" if they are acceptable.
+// added holds the names of servers that were not part of the previous verified sync.
+func judgeServers(servers []BackendServer, added map[string]bool, stats []ServerStats, minUp float64) string {
+	up := make(map[string]bool, len(stats))
+	for _, s := range stats {
+		up[s.Name] = statusUp(s.Status)
+	}
+
+	var active, activeUp, newServers, newUp int
+	for _, s := range servers {
+		if s.State == ServerStateMaint {
+			continue
+		}
+		active++
+		if up[s.Name] {
+			activeUp++
+		}
+		if added[s.Name] {
+			newServers++
+			if up[s.Name] {
+				newUp++
+			}
+		}
+	}
+
+	if active > 0 && activeUp == 0 {
+		return fmt.Sprintf("all %d servers are DOWN", active)
+	}
+	if newServers > 0 && float64(newUp) < minUp*float64(newServers) {
+		return fmt.Sprintf("%d of %d new servers are UP, need %.0f%%", newUp, newServers, minUp*100)
+	}
+	return ""
+}
+
+// statusUp reports whether an HAProxy stat status counts as serving traffic.
+// "UP 1/2" is going down but still up; "DOWN 1/2" is rising but still down.
+func statusUp(status string) bool {
+	switch {
+	case strings.HasPrefix(status, "UP"), strings.HasPrefix(status, "DRAIN"), status == "no check":
+		return true
+	}
+	return false
+}
+
+// appliedState is a backend configuration committed by the Syncer.
+type appliedState struct {
+	servers   []BackendServer
+	settings  BackendSettings
+	resolvers *ResolversConfig
+}
+
+// key identifies the state while ignoring runtime-only server fields.
+func (a appliedState) key() string {
+	servers := make([]BackendServer, len(a.servers))
+	for i, srv := range a.servers {
+		servers[i] = historyServer(srv)
+	}
+	data, _ := json.Marshal(struct {
+		Servers   []BackendServer
+		Settings  BackendSettings
+		Resolvers *ResolversConfig
+	}{servers, a.settings, a.resolvers})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// settingsKey identifies the backend settings and resolvers.
+func (a appliedState) settingsKey() string {
+	data, _ := json.Marshal(struct {
+		Settings  BackendSettings
+		Resolvers *ResolversConfig
+	}{a.settings, a.resolvers})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// pendingVerification is a committed change whose servers are judged once due.
+type pendingVerification struct {
+	state    appliedState
+	snapshot *BackendSnapshot
+	due      time.Time
+}
+
+// startVerification judges state once the settle time has passed. The worker is not blocked
+// meanwhile: NextSync asks for a sync when it is due.
+func (s *Syncer) startVerification(state appliedState, snapshot *BackendSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = &pendingVerification{state: state, snapshot: snapshot, due: s.now().Add(s.opts.Verify.Settle)}
+}
+
+// awaitVerification judges a pending change that is due. It reports whether the sync has to
+// stop: the change is still settling, which holds back further changes, or it was rolled back.
+func (s *Syncer) awaitVerification(ctx context.Context) (bool, error) {
+	s.mu.Lock()
+	p := s.pending
+	if p == nil {
+		s.mu.Unlock()
+		return false, nil
+	}
+	if wait := p.due.Sub(s.now()); wait > 0 {
+		s.mu.Unlock()
+		log.Printf("verifying the last change in %s, holding back further changes", wait.Round(time.Second))
+		return true, nil
+	}
+	s.pending = nil
+	s.mu.Unlock()
+
+	if err := s.verify(ctx, p); err != nil {
+		return true, err
+	}
+	return false, nil
+}
+
+// verifyDelay returns how long until a pending verification is due.
+func (s *Syncer) verifyDelay() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == nil {
+		return 0, false
+	}
+	return max(s.pending.due.Sub(s.now()), 0), true
+}
+
+// verify rolls the backend back if the committed servers are unhealthy. The last verified
+// state is re-applied through a transaction; before the first verified sync the snapshot of
+// the backend taken before the commit is written back instead.
+func (s *Syncer) verify(ctx context.Context, p *pendingVerification) error {
+	state := p.state
+	stats, err := s.opts.Stats.ServerStats(ctx)
+	if err != nil {
+		// Judged again on the next sync rather than passed unseen.
+		s.mu.Lock()
+		s.pending = p
+		s.mu.Unlock()
+		return fmt.Errorf("reading server states for verification: %w", err)
+	}
+
+	s.mu.Lock()
+	added := make(map[string]bool, len(state.servers))
+	for _, srv := range state.servers {
+		if !s.verified[srv.Name] {
+			added[srv.Name] = true
+		}
+	}
+	s.mu.Unlock()
+
+	reason := judgeServers(state.servers, added, stats, s.opts.Verify.MinUpFraction)
+	if reason == "" {
+		s.markVerified(state)
+		return nil
+	}
+
+	s.rollbacks.Add(1)
+	s.mu.Lock()
+	s.rejected = state.key()
+	lastGood := s.lastGood
+	s.mu.Unlock()
+
+	rollback := &RollbackError{Reason: reason, Version: p.snapshot.Version}
+	if lastGood != nil {
+		log.Printf("verification failed: %s; restoring the last verified servers and settings", reason)
+		rollback.RestoreErr = s.commit(ctx, lastGood.servers, lastGood.settings, lastGood.resolvers)
+	} else {
+		log.Printf("verification failed: %s; restoring the backend as of configuration version %d", reason, p.snapshot.Version)
+		rollback.RestoreErr = s.restoreSnapshot(ctx, p.snapshot)
+	}
+	if s.opts.Recorder != nil && s.opts.EventObject != nil {
+		s.opts.Recorder.Event(s.opts.EventObject, corev1.EventTypeWarning, "RolledBack",
+			rollback.Error()+"; the change is held back until the endpoints change")
+	}
+	return rollback
+}
+
+// restoreSnapshot writes snapshot back through a transaction, so only the managed backend
+// changes and a concurrent change elsewhere in the configuration is kept.
+func (s *Syncer) restoreSnapshot(ctx context.Context, snapshot *BackendSnapshot) (err error) {
+	snapshots, ok := s.client.(SnapshotClient)
+	if !ok {
+		return fmt.Errorf("client cannot restore a backend snapshot")
+	}
+	txID, err := s.client.BeginTransaction(ctx)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = s.client.AbortTransaction(ctx, txID)
+		}
+	}()
+	if err = snapshots.RestoreBackendInTransaction(ctx, txID, snapshot); err != nil {
+		return fmt.Errorf("restoring backend: %w", err)
+	}
+	if err = s.client.CommitTransaction(ctx, txID); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
+
+// isLastGood reports whether state is the last verified one, which needs no new verification.
+func (s *Syncer) isLastGood(state appliedState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastGood != nil && s.lastGood.key() == state.key()
+}
+
+// heldBack reports whether state is the configuration that last failed verification.
+func (s *Syncer) heldBack(state appliedState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rejected != "" && s.rejected == state.key()
+}
+
+// Rollbacks returns how many committed changes were reverted after failing verification.
+func (s *Syncer) Rollbacks() int64 {
+	return s.rollbacks.Load()
+}
+
+func (s *Syncer) markVerified(state appliedState) {
+	verified := make(map[string]bool, len(state.servers))
+	for _, srv := range state.servers {
+		verified[srv.Name] = true
+	}
+	s.mu.Lock()
+	s.verified = verified
+	s.lastGood = &state
+	s.rejected = ""
+	s.mu.Unlock()
+}
+
+// dataPlaneSnapshot is the Data Plane encoding of a BackendSnapshot: the objects as read, in
+// the dialect they were read in, so fields the controller does not model are restored too.
+type dataPlaneSnapshot struct {
+	Backend     json.RawMessage   `json:"backend,omitempty"`
+	Servers     []serverModel     `json:"servers,omitempty"`
+	StickRules  []json.RawMessage `json:"stick_rules,omitempty"`
+	HTTPChecks  []json.RawMessage `json:"http_checks,omitempty"`
+	Resolvers   json.RawMessage   `json:"resolvers,omitempty"`
+	Nameservers []json.RawMessage `json:"nameservers,omitempty"`
+}
+
+// SnapshotBackend reads the backend object, its managed servers, stick rules and HTTP checks,
+// and the resolvers section. A missing backend is saved as absent.
+func (c *DataPlaneClient) SnapshotBackend(ctx context.Context, servers []string, resolvers string) (*BackendSnapshot, error) {
+	d, err := c.dialect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &BackendSnapshot{}
+	if snapshot.Version, err = c.fetchConfigurationVersion(ctx, d); err != nil {
+		log.Printf("reading configuration version for the backend snapshot: %v", err)
+	}
+
+	var data dataPlaneSnapshot
+	backendPath := d.path("services/haproxy/configuration/backends", c.backendName)
+	if err := c.readConfig(ctx, d, backendPath, nil, &data.Backend); err != nil {
+		return nil, fmt.Errorf("read backend: %w", err)
+	}
+	if len(data.Backend) > 0 {
+		var existing []serverModel
+		coll := d.servers(c.backendName)
+		if err := c.readConfig(ctx, d, coll.path, coll.query, &existing); err != nil {
+			return nil, fmt.Errorf("read servers: %w", err)
+		}
+		managed := make(map[string]bool, len(servers))
+		for _, name := range servers {
+			managed[name] = true
+		}
+		for _, srv := range existing {
+			if managed[srv.Name] || c.owned.owns(srv.Name) {
+				data.Servers = append(data.Servers, srv)
+				snapshot.Servers = append(snapshot.Servers, backendServerFromModel(srv))
+			}
+		}
+		for _, list := range []struct {
+			coll collection
+			out  *[]json.RawMessage
+		}{{d.stickRules(c.backendName), &data.StickRules}, {d.httpChecks(c.backendName), &data.HTTPChecks}} {
+			if err := c.readConfig(ctx, d, list.coll.path, list.coll.query, list.out); err != nil {
+				return nil, fmt.Errorf("read %s: %w", path.Base(list.coll.path), err)
+			}
+		}
+	}
+	if resolvers != "" {
+		snapshot.Resolvers = resolvers
+		if err := c.readConfig(ctx, d, d.path("services/haproxy/configuration/resolvers", resolvers), nil, &data.Resolvers); err != nil {
+			return nil, fmt.Errorf("read resolvers: %w", err)
+		}
+		coll := d.nameservers(resolvers)
+		if err := c.readConfig(ctx, d, coll.path, coll.query, &data.Nameservers); err != nil {
+			return nil, fmt.Errorf("read nameservers: %w", err)
+		}
+	}
+	if snapshot.Data, err = json.Marshal(data); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// readConfig is getConfig outside a transaction; a missing object leaves out unset.
+func (c *DataPlaneClient) readConfig(ctx context.Context, d dialect, p string, query url.Values, out any) error {
+	err := c.getConfig(ctx, d, p, query, out)
+	var apiErr *apiStatusError
+	if errors.As(err, &apiErr) && apiErr.statusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// RestoreBackendInTransaction writes a snapshot from SnapshotBackend back: the resolvers
+// section and backend object as saved, the saved servers, with managed servers created since
+// deleted, and the stick rules and HTTP checks. A backend that did not exist is deleted.
+// Servers the controller does not own and did not save are left alone.
+func (c *DataPlaneClient) RestoreBackendInTransaction(ctx context.Context, transactionID string, snapshot *BackendSnapshot) error {
+	var data dataPlaneSnapshot
+	if err := json.Unmarshal(snapshot.Data, &data); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	d, err := c.dialect(ctx)
+	if err != nil {
+		return err
+	}
+	values := url.Values{}
+	values.Set("transaction_id", transactionID)
+
+	if len(data.Resolvers) > 0 {
+		if err := c.restoreResolvers(ctx, d, transactionID, data); err != nil {
+			return err
+		}
+	}
+
+	backends := d.path("services/haproxy/configuration/backends")
+	if len(data.Backend) == 0 {
+		if err := c.doRequest(ctx, http.MethodDelete, path.Join(backends, c.backendName), values, nil, nil); err != nil {
+			return fmt.Errorf("delete backend: %w", err)
+		}
+		return nil
+	}
+	if err := c.upsert(ctx, backends, c.backendName, values, data.Backend); err != nil {
+		return fmt.Errorf("backend: %w", err)
+	}
+
+	servers := d.servers(c.backendName)
+	serverValues := servers.values(transactionID)
+	var existing []serverModel
+	if err := c.getConfig(ctx, d, servers.path, serverValues, &existing); err != nil {
+		return fmt.Errorf("list servers: %w", err)
+	}
+	saved := make(map[string]struct{}, len(data.Servers))
+	var added, deleted []string
+	for _, srv := range data.Servers {
+		saved[srv.Name] = struct{}{}
+		added = append(added, srv.Name)
+		if err := c.upsert(ctx, servers.path, srv.Name, serverValues, srv); err != nil {
+			return fmt.Errorf("server %s: %w", srv.Name, err)
+		}
+	}
+	for _, srv := range existing {
+		if _, ok := saved[srv.Name]; ok || !c.owned.owns(srv.Name) {
+			continue
+		}
+		if err := c.doRequest(ctx, http.MethodDelete, servers.item(srv.Name), serverValues, nil, nil); err != nil {
+			return fmt.Errorf("delete server %s: %w", srv.Name, err)
+		}
+		deleted = append(deleted, srv.Name)
+	}
+	c.owned.stage(transactionID, added, deleted)
+
+	if err := c.replaceList(ctx, d, d.stickRules(c.backendName), transactionID, rawItems(data.StickRules)); err != nil {
+		return fmt.Errorf("replace stick rules: %w", err)
+	}
+	if err := c.replaceList(ctx, d, d.httpChecks(c.backendName), transactionID, rawItems(data.HTTPChecks)); err != nil {
+		return fmt.Errorf("replace http checks: %w", err)
+	}
+	return nil
+}
+
+func (c *DataPlaneClient) restoreResolvers(ctx context.Context, d dialect, transactionID string, data dataPlaneSnapshot) error {
+	var section struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data.Resolvers, &section); err != nil {
+		return fmt.Errorf("decode resolvers: %w", err)
+	}
+	values := url.Values{}
+	values.Set("transaction_id", transactionID)
+	if err := c.upsert(ctx, d.path("services/haproxy/configuration/resolvers"), section.Name, values, data.Resolvers); err != nil {
+		return fmt.Errorf("resolvers %s: %w", section.Name, err)
+	}
+
+	nameservers := d.nameservers(section.Name)
+	nsValues := nameservers.values(transactionID)
+	var existing []nameserverModel
+	if err := c.getConfig(ctx, d, nameservers.path, nsValues, &existing); err != nil {
+		return fmt.Errorf("list nameservers: %w", err)
+	}
+	saved := make(map[string]struct{}, len(data.Nameservers))
+	for _, raw := range data.Nameservers {
+		var ns nameserverModel
+		if err := json.Unmarshal(raw, &ns); err != nil {
+			return fmt.Errorf("decode nameserver: %w", err)
+		}
+		saved[ns.Name] = struct{}{}
+		if err := c.upsert(ctx, nameservers.path, ns.Name, nsValues, raw); err != nil {
+			return fmt.Errorf("nameserver %s: %w", ns.Name, err)
+		}
+	}
+	for _, ns := range existing {
+		if _, ok := saved[ns.Name]; ok {
+			continue
+		}
+		if err := c.doRequest(ctx, http.MethodDelete, nameservers.item(ns.Name), nsValues, nil, nil); err != nil {
+			return fmt.Errorf("delete nameserver %s: %w", ns.Name, err)
+		}
+	}
+	return nil
+}
+
+func rawItems(items []json.RawMessage) []any {
+	out := make([]any, len(items))
+	for i, item := range items {
+		out[i] = item
+	}
+	return out
+}
+
+// backendServerFromModel is the inverse of newServerModel for the fields shown in diffs.
+func backendServerFromModel(m serverModel) BackendServer {
+	srv := BackendServer{
+		Name:    m.Name,
+		Address: m.Address,
+		Check:   m.Check == enabled,
+		Backup:  m.Backup == enabled,
+		Cookie:  m.Cookie,
+	}
+	if m.Port != nil {
+		srv.Port = int32(*m.Port)
+	}
+	if m.Weight != nil {
+		srv.Weight = int(*m.Weight)
+	}
+	if m.Maxconn != nil {
+		srv.MaxConn = int(*m.Maxconn)
+	}
+	if m.Resolvers != "" {
+		srv.FQDN, srv.Address = m.Address, ""
+		srv.Resolvers, srv.ResolvePrefer, srv.InitAddr = m.Resolvers, m.ResolvePrefer, m.InitAddr
+	}
+	if m.Maintenance == enabled {
+		srv.State = ServerStateMaint
+	}
+	return srv
+}
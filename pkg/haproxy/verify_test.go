@@ -0,0 +1,260 @@
+package haproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestJudgeServers(t *testing.T) {
+	servers := []BackendServer{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d", State: ServerStateMaint}}
+	testCases := []struct {
+		name   string
+		added  map[string]bool
+		status map[string]string
+		want   string
+	}{
+		{name: "healthy", added: map[string]bool{"b": true, "c": true}, status: map[string]string{"a": "UP", "b": "UP 1/2", "c": "DOWN"}},
+		{name: "new servers down", added: map[string]bool{"b": true, "c": true}, status: map[string]string{"a": "UP", "b": "DOWN 1/2", "c": "DOWN"}, want: "0 of 2 new servers are UP"},
+		{name: "missing stats count as down", added: map[string]bool{"c": true}, status: map[string]string{"a": "UP", "b": "DRAIN"}, want: "0 of 1 new servers are UP"},
+		{name: "backend down", status: map[string]string{"a": "DOWN", "b": "DOWN", "c": "MAINT", "d": "UP"}, want: "all 3 servers are DOWN"},
+		{name: "maint servers are not judged", added: map[string]bool{"d": true}, status: map[string]string{"a": "no check", "d": "MAINT"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stats []ServerStats
+			for name, status := range tc.status {
+				stats = append(stats, ServerStats{Name: name, Status: status})
+			}
+			got := judgeServers(servers, tc.added, stats, 0.5)
+			if tc.want == "" && got != "" || !strings.HasPrefix(got, tc.want) {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+// snapshotStub is a stubClient that also saves and restores backend snapshots, encoding
+// the servers as Data.
+// Each snapshot reports the next configuration version, starting at 7.
+type snapshotStub struct {
+	stubClient
+	snapshots int64
+	restored  *BackendSnapshot
+}
+
+func (c *snapshotStub) SnapshotBackend(context.Context, []string, string) (*BackendSnapshot, error) {
+	c.snapshots++
+	data, err := json.Marshal(c.backends)
+	return &BackendSnapshot{Version: 6 + c.snapshots, Servers: append([]BackendServer(nil), c.backends...), Data: data}, err
+}
+
+func (c *snapshotStub) RestoreBackendInTransaction(_ context.Context, _ string, snapshot *BackendSnapshot) error {
+	c.restored = snapshot
+	return json.Unmarshal(snapshot.Data, &c.backends)
+}
+
+type stubStats []ServerStats
+
+func (s stubStats) ServerStats(context.Context) ([]ServerStats, error) { return s, nil }
+
+// statusStats answers from a map that tests change between syncs.
+type statusStats map[string]string
+
+func (s statusStats) ServerStats(context.Context) ([]ServerStats, error) {
+	var stats []ServerStats
+	for name, status := range s {
+		stats = append(stats, ServerStats{Name: name, Status: status})
+	}
+	return stats, nil
+}
+
+func TestSyncVerifiesAndRollsBack(t *testing.T) {
+	servers := []BackendServer{{Name: "worker-1-443"}, {Name: "worker-2-443"}}
+	testCases := []struct {
+		name         string
+		stats        stubStats
+		wantRollback bool
+	}{
+		{name: "servers up", stats: stubStats{{Name: "worker-1-443", Status: "UP"}, {Name: "worker-2-443", Status: "DOWN"}}},
+		{name: "servers down", stats: stubStats{{Name: "worker-1-443", Status: "DOWN"}, {Name: "worker-2-443", Status: "DOWN"}}, wantRollback: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &snapshotStub{stubClient: stubClient{backends: []BackendServer{{Name: "old"}}}}
+			recorder := record.NewFakeRecorder(1)
+			clock := &fakeClock{t: time.Unix(0, 0)}
+			s := NewSyncerWithOptions(client, SyncerOptions{
+				Verify:      VerifyConfig{Settle: 10 * time.Second, MinUpFraction: 0.5},
+				Stats:       tc.stats,
+				Recorder:    recorder,
+				EventObject: &corev1.ObjectReference{Kind: "Service", Namespace: "ingress", Name: "ingress-nginx"},
+			})
+			s.now = clock.now
+			ctx := context.Background()
+
+			// The commit returns at once; the verification is asked for once the settle time passed.
+			if err := s.SyncBackends(ctx, servers, BackendSettings{}); err != nil || client.committed != 1 {
+				t.Fatalf("expected the change committed, got %v after %d commits", err, client.committed)
+			}
+			if next, ok := s.NextSync(); !ok || next != 10*time.Second {
+				t.Fatalf("expected a sync for the verification in 10s, got %v %v", next, ok)
+			}
+			clock.advance(5 * time.Second)
+			if err := s.SyncBackends(ctx, servers, BackendSettings{}); err != nil || client.committed != 1 {
+				t.Fatalf("expected nothing committed while settling, got %v after %d commits", err, client.committed)
+			}
+
+			clock.advance(5 * time.Second)
+			err := s.SyncBackends(ctx, servers, BackendSettings{})
+			if _, ok := s.NextSync(); ok {
+				t.Fatalf("expected no verification left pending")
+			}
+			var rollback *RollbackError
+			if !tc.wantRollback {
+				if err != nil || client.restored != nil {
+					t.Fatalf("expected verified sync, got %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &rollback) || rollback.Version != 7 || s.Rollbacks() != 1 || client.restored == nil || client.committed != 2 {
+				t.Fatalf("expected rollback through a transaction, got %v after %d commits", err, client.committed)
+			}
+			if len(client.backends) != 1 || client.backends[0].Name != "old" {
+				t.Fatalf("expected the saved servers restored, got %+v", client.backends)
+			}
+			if event := <-recorder.Events; !strings.HasPrefix(event, "Warning RolledBack verification failed (all 2 servers are DOWN)") {
+				t.Fatalf("unexpected event %q", event)
+			}
+		})
+	}
+}
+
+func TestSyncRollsBackToLastVerifiedStateAndHoldsBack(t *testing.T) {
+	client := &snapshotStub{}
+	stats := statusStats{"a": "UP"}
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	s := NewSyncerWithOptions(client, SyncerOptions{
+		Verify: VerifyConfig{Settle: time.Second, MinUpFraction: 0.5},
+		Stats:  stats,
+	})
+	s.now = clock.now
+	ctx := context.Background()
+	// sync commits servers and, once they settled, judges them; a state that passes is
+	// committed again by the judging sync.
+	sync := func(servers []BackendServer) error {
+		if err := s.SyncBackends(ctx, servers, BackendSettings{}); err != nil {
+			return err
+		}
+		clock.advance(time.Second)
+		return s.SyncBackends(ctx, servers, BackendSettings{})
+	}
+
+	good := []BackendServer{{Name: "a"}}
+	if err := sync(good); err != nil || client.committed != 2 {
+		t.Fatalf("first sync: %v after %d commits", err, client.committed)
+	}
+
+	stats["a"], stats["b"] = "DOWN", "DOWN"
+	bad := []BackendServer{{Name: "a"}, {Name: "b"}}
+	var rollback *RollbackError
+	if err := sync(bad); !errors.As(err, &rollback) || rollback.RestoreErr != nil {
+		t.Fatalf("expected a rollback, got %v", err)
+	}
+	if client.restored != nil || len(client.backends) != 1 || client.backends[0].Name != "a" || client.committed != 4 {
+		t.Fatalf("expected the last verified servers committed again, got %+v after %d commits", client.backends, client.committed)
+	}
+	if _, ok := s.NextSync(); ok {
+		t.Fatalf("expected the re-applied verified state not to be verified again")
+	}
+
+	// Retries and resyncs of the rejected state commit nothing, even with new endpoint counts.
+	bad[1].ReadyEndpoints = 2
+	if err := s.SyncBackends(ctx, bad, BackendSettings{}); err != nil || client.committed != 4 {
+		t.Fatalf("expected the rejected state to be held back, got %v after %d commits", err, client.committed)
+	}
+
+	stats["c"] = "UP"
+	stats["a"] = "UP"
+	if err := sync([]BackendServer{{Name: "a"}, {Name: "c"}}); err != nil || client.committed != 6 {
+		t.Fatalf("expected a changed state to be applied, got %v after %d commits", err, client.committed)
+	}
+}
+
+func TestDataPlaneSnapshotAndRestore(t *testing.T) {
+	const api = "/v3/services/haproxy/configuration"
+	fake, srv := newFakeDataPlane(t)
+	fake.respond("GET "+api+"/version", http.StatusOK, `7`)
+	fake.respond("GET "+api+"/backends/be_ingress", http.StatusOK, `{"name":"be_ingress","balance":{"algorithm":"roundrobin"},"description":"kept"}`)
+	fake.respond("GET "+api+"/backends/be_ingress/servers", http.StatusOK,
+		`[{"name":"worker-1-443","address":"10.0.0.1","port":443,"check":"enabled"},{"name":"sorry","address":"10.0.0.9","port":80}]`)
+	fake.respond("GET "+api+"/backends/be_ingress/stick_rules", http.StatusOK, `[]`)
+	fake.respond("GET "+api+"/backends/be_ingress/http_checks", http.StatusOK, `[{"type":"send","uri":"/healthz"}]`)
+	fake.respond("GET "+api+"/resolvers/k8s", http.StatusOK, `{"name":"k8s","hold_valid":10000}`)
+	fake.respond("GET "+api+"/resolvers/k8s/nameservers", http.StatusOK, `[{"name":"ns1","address":"10.96.0.10","port":53}]`)
+
+	c := NewDataPlaneClient(srv.URL, "", "", "", "be_ingress")
+	ctx := context.Background()
+	snapshot, err := c.SnapshotBackend(ctx, []string{"worker-1-443"}, "k8s")
+	if err != nil || snapshot.Version != 7 {
+		t.Fatalf("unexpected snapshot %+v: %v", snapshot, err)
+	}
+	// Hand-added servers are not saved, so a restore leaves them alone.
+	want := []BackendServer{{Name: "worker-1-443", Address: "10.0.0.1", Port: 443, Check: true}}
+	if !reflect.DeepEqual(snapshot.Servers, want) {
+		t.Fatalf("unexpected snapshot servers %+v", snapshot.Servers)
+	}
+
+	// Since the snapshot a server was added by the controller; the resolvers gained a nameserver.
+	c.OwnServers([]string{"worker-1-443", "worker-2-443"})
+	fake.respond("GET "+api+"/backends/be_ingress/servers", http.StatusOK,
+		`[{"name":"worker-1-443","address":"10.0.0.1","port":443},{"name":"worker-2-443","address":"10.0.0.2","port":443},{"name":"sorry","address":"10.0.0.9","port":80}]`)
+	fake.respond("GET "+api+"/resolvers/k8s/nameservers", http.StatusOK, `[{"name":"ns1"},{"name":"ns2"}]`)
+	for _, route := range []string{
+		"PUT " + api + "/resolvers/k8s",
+		"PUT " + api + "/resolvers/k8s/nameservers/ns1",
+		"DELETE " + api + "/resolvers/k8s/nameservers/ns2",
+		"PUT " + api + "/backends/be_ingress",
+		"PUT " + api + "/backends/be_ingress/servers/worker-1-443",
+		"DELETE " + api + "/backends/be_ingress/servers/worker-2-443",
+		"PUT " + api + "/backends/be_ingress/stick_rules",
+		"PUT " + api + "/backends/be_ingress/http_checks",
+	} {
+		fake.respond(route, http.StatusOK, `{}`)
+	}
+	if err := c.RestoreBackendInTransaction(ctx, "tx1", snapshot); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+
+	for _, route := range []string{"DELETE " + api + "/resolvers/k8s/nameservers/ns2", "DELETE " + api + "/backends/be_ingress/servers/worker-2-443"} {
+		if req, ok := fake.last(route); !ok || req.Query != "transaction_id=tx1" {
+			t.Fatalf("expected %s in the transaction, got %+v", route, req)
+		}
+	}
+	if _, ok := fake.last("DELETE " + api + "/backends/be_ingress/servers/sorry"); ok {
+		t.Fatalf("hand-added server was deleted")
+	}
+	backend, _ := fake.last("PUT " + api + "/backends/be_ingress")
+	if backend.Body["description"] != "kept" {
+		t.Fatalf("expected the backend restored as read, got %v", backend.Body)
+	}
+	checks, _ := fake.lastRaw("PUT " + api + "/backends/be_ingress/http_checks")
+	if strings.TrimSpace(checks) != `[{"type":"send","uri":"/healthz"}]` {
+		t.Fatalf("unexpected http checks %s", checks)
+	}
+	fake.respond("PUT /v3/services/haproxy/transactions/tx1", http.StatusOK, `{}`)
+	if err := c.CommitTransaction(ctx, "tx1"); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if c.owned.owns("worker-2-443") {
+		t.Fatalf("expected the deleted server released")
+	}
+}
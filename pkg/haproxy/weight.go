@@ -0,0 +1,147 @@
+package haproxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// WeightStrategy selects how backend server weights are derived.
+type WeightStrategy string
+
+const (
+	// WeightEqual gives every server the same weight.
+	WeightEqual WeightStrategy = "equal"
+	// WeightEndpoints weights servers by the number of ready endpoints behind them.
+	WeightEndpoints WeightStrategy = "endpoints"
+	// WeightNodeCPU weights servers by the allocatable CPU of their node.
+	WeightNodeCPU WeightStrategy = "cpu"
+	// WeightNodeLabel reads the weight from a node label, falling back to an annotation with the same key.
+	WeightNodeLabel WeightStrategy = "label"
+)
+
+const (
+	// DefaultMinWeight is the lower weight bound; it keeps servers from dropping to zero.
+	DefaultMinWeight = 1
+	// DefaultMaxWeight is the upper weight bound proportional strategies scale to.
+	DefaultMaxWeight = 100
+	// maxHAProxyWeight is the largest weight HAProxy accepts.
+	maxHAProxyWeight = 256
+)
+
+// WeightConfig configures how server weights are computed during backend building.
+type WeightConfig struct {
+	Strategy WeightStrategy
+	// Key is the node label/annotation read by WeightNodeLabel.
+	Key string
+	Min int
+	Max int
+}
+
+// ParseWeightStrategy validates a strategy name; an empty name selects WeightEqual.
+func ParseWeightStrategy(v string) (WeightStrategy, error) {
+	switch s := WeightStrategy(strings.ToLower(v)); s {
+	case "":
+		return WeightEqual, nil
+	case WeightEqual, WeightEndpoints, WeightNodeCPU, WeightNodeLabel:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown weight strategy %q", v)
+	}
+}
+
+// Validate checks the bounds and strategy-specific settings.
+func (w WeightConfig) Validate() error {
+	if w.Min < 1 || w.Max > maxHAProxyWeight || w.Min > w.Max {
+		return fmt.Errorf("weight bounds must satisfy 1 <= min (%d) <= max (%d) <= %d", w.Min, w.Max, maxHAProxyWeight)
+	}
+	if w.Strategy == WeightNodeLabel && w.Key == "" {
+		return fmt.Errorf("weight strategy %q requires a node label key", w.Strategy)
+	}
+	return nil
+}
+
+// ApplyWeights sets each server weight according to cfg. Servers the strategy has no input
+// for (no node, missing label) keep the equal weight. Results are clamped to [Min, Max].
+func ApplyWeights(servers []BackendServer, nodes []*corev1.Node, cfg WeightConfig) []BackendServer {
+	if cfg.Min <= 0 {
+		cfg.Min = DefaultMinWeight
+	}
+	if cfg.Max <= 0 {
+		cfg.Max = DefaultMaxWeight
+	}
+
+	byName := make(map[string]*corev1.Node, len(nodes))
+	for _, n := range nodes {
+		byName[n.Name] = n
+	}
+
+	values := make([]int64, len(servers))
+	var highest int64
+	for i, srv := range servers {
+		values[i] = weightInput(srv, byName[srv.NodeName], cfg)
+		if values[i] > highest {
+			highest = values[i]
+		}
+	}
+
+	for i := range servers {
+		w := 1
+		switch {
+		case values[i] <= 0:
+		case cfg.Strategy == WeightNodeLabel:
+			w = int(values[i])
+		case highest > 0:
+			// Round up so the smallest non-zero input never truncates to zero.
+			w = int((int64(cfg.Max)*values[i] + highest - 1) / highest)
+		}
+		servers[i].Weight = clampWeight(w, cfg.Min, cfg.Max)
+	}
+	return servers
+}
+
+func weightInput(srv BackendServer, node *corev1.Node, cfg WeightConfig) int64 {
+	switch cfg.Strategy {
+	case WeightEndpoints:
+		return int64(srv.ReadyEndpoints)
+	case WeightNodeCPU:
+		if node == nil {
+			return 0
+		}
+		cpu, ok := node.Status.Allocatable[corev1.ResourceCPU]
+		if !ok {
+			return 0
+		}
+		return cpu.MilliValue()
+	case WeightNodeLabel:
+		if node == nil {
+			return 0
+		}
+		raw, ok := node.Labels[cfg.Key]
+		if !ok {
+			raw, ok = node.Annotations[cfg.Key]
+		}
+		if !ok {
+			return 0
+		}
+		v, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return 0
+		}
+		return v
+	default:
+		return 0
+	}
+}
+
+func clampWeight(w, lower, upper int) int {
+	if w < lower {
+		return lower
+	}
+	if w > upper {
+		return upper
+	}
+	return w
+}
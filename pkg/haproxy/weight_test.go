@@ -0,0 +1,97 @@
+package haproxy
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestApplyWeights(t *testing.T) {
+	nodes := []*corev1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "small", Labels: map[string]string{"example.com/weight": "10"}},
+			Status:     corev1.NodeStatus{Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "large", Annotations: map[string]string{"example.com/weight": "500"}},
+			Status:     corev1.NodeStatus{Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("16")}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "unlabelled"},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		cfg      WeightConfig
+		expected []int
+	}{
+		{
+			name:     "equal",
+			cfg:      WeightConfig{Strategy: WeightEqual, Min: 1, Max: 100},
+			expected: []int{1, 1, 1},
+		},
+		{
+			name:     "endpoints",
+			cfg:      WeightConfig{Strategy: WeightEndpoints, Min: 1, Max: 100},
+			expected: []int{25, 100, 25},
+		},
+		{
+			name:     "cpu with unknown allocatable kept at min",
+			cfg:      WeightConfig{Strategy: WeightNodeCPU, Min: 5, Max: 100},
+			expected: []int{13, 100, 5},
+		},
+		{
+			name:     "label clamped to bounds",
+			cfg:      WeightConfig{Strategy: WeightNodeLabel, Key: "example.com/weight", Min: 1, Max: 256},
+			expected: []int{10, 256, 1},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			servers := []BackendServer{
+				{Name: "small-443", NodeName: "small", ReadyEndpoints: 1},
+				{Name: "large-443", NodeName: "large", ReadyEndpoints: 4},
+				{Name: "unlabelled-443", NodeName: "unlabelled", ReadyEndpoints: 1},
+			}
+			ApplyWeights(servers, nodes, tc.cfg)
+			for i, srv := range servers {
+				if srv.Weight != tc.expected[i] {
+					t.Fatalf("server %s: expected weight %d, got %d", srv.Name, tc.expected[i], srv.Weight)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeServersCountsEndpoints(t *testing.T) {
+	merged := mergeServers([]BackendServer{
+		{Name: "worker-1-443", ReadyEndpoints: 1},
+		{Name: "worker-2-443", ReadyEndpoints: 1},
+		{Name: "worker-1-443", ReadyEndpoints: 1},
+	})
+	if len(merged) != 2 {
+		t.Fatalf("expected 2 servers after merge, got %d", len(merged))
+	}
+	if merged[0].ReadyEndpoints != 2 {
+		t.Fatalf("expected 2 endpoints on worker-1, got %d", merged[0].ReadyEndpoints)
+	}
+}
+
+func TestWeightConfigValidate(t *testing.T) {
+	if err := (WeightConfig{Strategy: WeightEqual, Min: 0, Max: 10}).Validate(); err == nil {
+		t.Fatalf("expected zero lower bound to be rejected")
+	}
+	if err := (WeightConfig{Strategy: WeightEqual, Min: 1, Max: 300}).Validate(); err == nil {
+		t.Fatalf("expected upper bound above 256 to be rejected")
+	}
+	if err := (WeightConfig{Strategy: WeightNodeLabel, Min: 1, Max: 10}).Validate(); err == nil {
+		t.Fatalf("expected label strategy without key to be rejected")
+	}
+}